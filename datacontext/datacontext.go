@@ -2,6 +2,7 @@ package datacontext
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	// Imported for side-effects
 	_ "github.com/lib/pq"
@@ -10,10 +11,30 @@ import (
 )
 
 const (
+	// migrationDefaultACIs records that the default aci values were added to existing DBs
+	migrationDefaultACIs = "default-acis"
+	// migrationTestUserPassword records that the well-known password of the Test User seeded
+	// by earlier versions was removed
+	migrationTestUserPassword = "test-user-password"
+
 	adminUsername = "admin"
 	adminPassword = "admin"
+	// testUserDN names the seeded Test User, which has no password until the administrator
+	// sets one
+	testUserDN = "cn=Test User,cn=Users,dc=example,dc=org"
+	// seededTestUserPassword is the cleartext password earlier versions seeded the Test User with
+	seededTestUserPassword = "password"
 )
 
+// defaultACIs are the access control instructions of the seeded naming contexts: anyone may
+// read entries except passwords, their password policy state & access control instructions,
+// users may change their password
+var defaultACIs = []string{
+	`(targetattr!="userPassword || authPassword || aci || pwdHistory || pwdChangedTime || pwdAccountLockedTime || ` +
+		`pwdFailureTime || pwdGraceUseTime")(version 3.0; acl "Anyone may read"; allow (read,search,compare) userdn="ldap:///anyone";)`,
+	`(targetattr="userPassword")(version 3.0; acl "Users may change their password"; allow (write) userdn="ldap:///self";)`,
+}
+
 type DataContext struct {
 	DBName string
 	DBUser string
@@ -62,9 +83,28 @@ func (dc *DataContext) SeedDb() error {
 	if err := createDummySchemaIfNotExists(dc.DB); err != nil {
 		return err
 	}
+	if err := runMigrationOnce(dc.DB, migrationDefaultACIs, migrateDefaultACIs); err != nil {
+		return err
+	}
+	if err := runMigrationOnce(dc.DB, migrationTestUserPassword, migrateTestUserPassword); err != nil {
+		return err
+	}
 	return nil
 }
 
+// inTransaction runs fn within a transaction, committing only if fn succeeds
+func (dc *DataContext) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := dc.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
 func createTablesIfNotExists(db *sql.DB) error {
 	statements := []string{
 		sqlCreateUsersTable,
@@ -73,6 +113,61 @@ func createTablesIfNotExists(db *sql.DB) error {
 		sqlCreateAttributeTypesTable,
 		sqlCreateObjectClassesTable,
 		sqlCreateEntriesTable,
+		sqlCreateMigrationsTable,
+		sqlAddUsersScramColumns,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return migrateEntryPaths(db)
+}
+
+// migrateEntryPaths adds the path & depth columns to entries tables created without them
+// and updates the paths normalized differently by earlier versions (e.g. before attribute
+// type aliases were normalized)
+func migrateEntryPaths(db *sql.DB) error {
+	if _, err := db.Exec(sqlAddEntriesPathColumns); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(sqlSelectEntryPaths)
+	if err != nil {
+		return err
+	}
+	paths := map[string]string{}
+	for rows.Next() {
+		var dn, path string
+		if err := rows.Scan(&dn, &path); err != nil {
+			rows.Close()
+			return err
+		}
+		paths[dn] = path
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for dn, path := range paths {
+		parsed, err := models.ParseDN(dn)
+		if err != nil {
+			return fmt.Errorf("Invalid DN '%s': %v", dn, err)
+		}
+		if parsed.Path() == path {
+			continue
+		}
+		if _, err := db.Exec(sqlUpdateEntryPath, dn, parsed.Path(), len(parsed)); err != nil {
+			return fmt.Errorf("Updating the path of '%s' failed: %v", dn, err)
+		}
+	}
+
+	statements := []string{
+		sqlRequireEntriesPathColumns,
+		sqlCreateEntriesPathIndex,
+		sqlCreateEntriesDepthPathIndex,
+		sqlCreateEntriesParentIndex,
 	}
 	for _, statement := range statements {
 		if _, err := db.Exec(statement); err != nil {
@@ -82,6 +177,60 @@ func createTablesIfNotExists(db *sql.DB) error {
 	return nil
 }
 
+// runMigrationOnce runs migrate within a transaction unless the migration name has already
+// been recorded as run, recording it along with the changes of migrate
+func runMigrationOnce(db *sql.DB, name string, migrate func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(sqlInsertMigrationRow, name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		tx.Rollback()
+		return err
+	}
+	if err := migrate(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Migration %s failed: %v", name, err)
+	}
+	return tx.Commit()
+}
+
+// migrateDefaultACIs adds the default access control instructions to the naming contexts of
+// DBs seeded before access control was enforced, which no aci value would grant any access
+// the migration runs once, so that a directory whose aci values were all removed on purpose
+// is left as is
+func migrateDefaultACIs(tx *sql.Tx) error {
+	var count int
+	if err := tx.QueryRow(sqlSelectEntryWithOperAttributeCount, models.ACIAttribute).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	acis, err := json.Marshal(defaultACIs)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(sqlAddNamingContextsOperValues, models.ACIAttribute, string(acis))
+	return err
+}
+
+// migrateTestUserPassword removes the well-known password of the Test User seeded by earlier
+// versions, passwords changed since are left as is
+func migrateTestUserPassword(tx *sql.Tx) error {
+	password, err := json.Marshal([]string{seededTestUserPassword})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(sqlRemoveEntryUserValues, testUserDN, models.UserPasswordAttribute, string(password))
+	return err
+}
+
 func createDummySchemaIfNotExists(db *sql.DB) error {
 	var count int
 	if err := db.QueryRow(sqlSelectEntryCount).Scan(&count); err != nil {
@@ -99,6 +248,9 @@ func createDummySchemaIfNotExists(db *sql.DB) error {
 			UserValues: models.AttributeValues{
 				models.DomainComponentAttribute: []string{"example"},
 			},
+			OperValues: models.AttributeValues{
+				models.ACIAttribute: defaultACIs,
+			},
 		}); err != nil {
 			return err
 		}
@@ -163,14 +315,20 @@ func createDummySchemaIfNotExists(db *sql.DB) error {
 }
 
 func insertEntryRow(db *sql.DB, entry *models.Entry) error {
-	_, err := db.Exec(
+	dn, err := models.ParseDN(entry.DN)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
 		sqlInsertEntryRow,
 		entry.DN,
 		entry.Parent,
 		entry.RDN,
 		entry.Classes,
 		entry.UserValues,
-		entry.OperValues)
+		entry.OperValues,
+		dn.Path(),
+		len(dn))
 	return err
 }
 
@@ -183,7 +341,8 @@ func createAdminIfNotExists(db *sql.DB) error {
 	if count == 0 {
 		admin := models.CreateUser(adminUsername, adminPassword)
 		if _, err := db.Exec(sqlInsertUserRow,
-			admin.Created, admin.Username, admin.PasswordHash, admin.PasswordSalt); err != nil {
+			admin.Created, admin.Username, admin.PasswordHash, admin.PasswordSalt,
+			admin.ScramSHA1, admin.ScramSHA256); err != nil {
 			return err
 		}
 	}
@@ -227,17 +386,18 @@ func createMatchingRulesIfNotExists(db *sql.DB) error {
 	return nil
 }
 
+// createAttributeTypesIfNotExists inserts the standard attribute types missing from the DB,
+// including those added since the DB was seeded, and sets the equality matching rules of
+// those seeded without one
 func createAttributeTypesIfNotExists(db *sql.DB) error {
-	var count int
-	if err := db.QueryRow(sqlSelectAttributeTypeCount).Scan(&count); err != nil {
-		return err
-	}
-
-	if count == 0 {
-		for _, attr := range models.LDAPv3AttributeTypes {
-			if _, err := db.Exec(sqlInsertAttributeTypeRow,
-				attr.Name, attr.OID, attr.Syntax, attr.Super, attr.Names, attr.Flags,
-				attr.Usage, attr.EqualityMatch, attr.SubstrMatch, attr.OrderingMatch); err != nil {
+	for _, attr := range models.LDAPv3AttributeTypes {
+		if _, err := db.Exec(sqlInsertAttributeTypeRow,
+			attr.Name, attr.OID, attr.Syntax, attr.Super, attr.Names, attr.Flags,
+			attr.Usage, attr.EqualityMatch, attr.SubstrMatch, attr.OrderingMatch); err != nil {
+			return err
+		}
+		if attr.EqualityMatch.Valid {
+			if _, err := db.Exec(sqlUpdateAttributeTypeEqualityMatch, attr.Name, attr.EqualityMatch); err != nil {
 				return err
 			}
 		}
@@ -246,17 +406,17 @@ func createAttributeTypesIfNotExists(db *sql.DB) error {
 	return nil
 }
 
+// createObjectClassesIfNotExists inserts the standard object classes missing from the DB
 func createObjectClassesIfNotExists(db *sql.DB) error {
-	var count int
-	if err := db.QueryRow(sqlSelectObjectClassCount).Scan(&count); err != nil {
-		return err
-	}
-
-	if count == 0 {
-		for _, class := range models.LDAPv3ObjectClasses {
-			if _, err := db.Exec(sqlInsertObjectClassRow,
-				class.Name, class.OID, class.Super, class.Names, class.Flags,
-				class.MustAttributes, class.MayAttributes); err != nil {
+	for _, class := range models.LDAPv3ObjectClasses {
+		if _, err := db.Exec(sqlInsertObjectClassRow,
+			class.Name, class.OID, class.Super, class.Names, class.Flags,
+			class.MustAttributes, class.MayAttributes); err != nil {
+			return err
+		}
+		// classes seeded before some of their optional attribute types were defined
+		if len(class.MayAttributes) > 0 {
+			if _, err := db.Exec(sqlUpdateObjectClassMayAttributes, class.Name, class.MayAttributes); err != nil {
 				return err
 			}
 		}
@@ -87,3 +87,111 @@ func TestSeedDb(t *testing.T) {
 		t.Error("Wrong number of rows seeded")
 	}
 }
+
+func TestSeedDbSetsEqualityMatch(t *testing.T) {
+	dc := &DataContext{DBName: dbname, DBUser: dbuser}
+	dc.InitDb()
+	defer dc.CloseDb()
+
+	dc.SeedDb()
+	// attribute types seeded before their equality matching rule was defined
+	if _, err := dc.DB.Exec(`UPDATE attribute_types SET equality_match = NULL WHERE name = $1`,
+		models.DescriptionAttribute); err != nil {
+		t.Fatal("Error clearing equality_match:", err)
+	}
+	dc.SeedDb()
+
+	var rule sql.NullString
+	dc.DB.QueryRow(`SELECT equality_match FROM attribute_types WHERE name = $1`,
+		models.DescriptionAttribute).Scan(&rule)
+	if rule.String != models.CaseIgnoreMatchRule {
+		t.Error("Expected equality_match", models.CaseIgnoreMatchRule, "got", rule.String)
+	}
+}
+
+func TestSeedDbAddsMayAttributes(t *testing.T) {
+	dc := &DataContext{DBName: dbname, DBUser: dbuser}
+	dc.InitDb()
+	defer dc.CloseDb()
+
+	dc.SeedDb()
+	// object classes seeded before some of their optional attribute types were defined
+	if _, err := dc.DB.Exec(`UPDATE object_classes SET may_attributes = array_remove(may_attributes, $2) WHERE name = $1`,
+		models.PwdPolicyClass, models.PwdCheckQualityAttribute); err != nil {
+		t.Fatal("Error removing may_attributes:", err)
+	}
+	dc.SeedDb()
+
+	var found bool
+	dc.DB.QueryRow(`SELECT $2 = ANY(may_attributes) FROM object_classes WHERE name = $1`,
+		models.PwdPolicyClass, models.PwdCheckQualityAttribute).Scan(&found)
+	if !found {
+		t.Error("Expected", models.PwdCheckQualityAttribute, "among the may_attributes of", models.PwdPolicyClass)
+	}
+}
+
+func TestSeedDbAddsACIs(t *testing.T) {
+	dc := &DataContext{DBName: dbname, DBUser: dbuser}
+	dc.InitDb()
+	defer dc.CloseDb()
+
+	dc.SeedDb()
+	withoutACIs := func() int {
+		var count int
+		dc.DB.QueryRow(`SELECT COUNT(dn) FROM entries WHERE parent IS NULL AND NOT oper_values ? $1`,
+			models.ACIAttribute).Scan(&count)
+		return count
+	}
+	removeACIs := func() {
+		if _, err := dc.DB.Exec(`UPDATE entries SET oper_values = oper_values - $1`, models.ACIAttribute); err != nil {
+			t.Fatal("Error removing aci values:", err)
+		}
+	}
+
+	// DBs seeded before access control was enforced
+	removeACIs()
+	if _, err := dc.DB.Exec(`DELETE FROM migrations WHERE name = $1`, migrationDefaultACIs); err != nil {
+		t.Fatal("Error removing the migration:", err)
+	}
+	dc.SeedDb()
+	if count := withoutACIs(); count != 0 {
+		t.Error("Expected every naming context to hold the default aci values,", count, "do not")
+	}
+
+	// aci values removed on purpose are not restored
+	removeACIs()
+	dc.SeedDb()
+	if count := withoutACIs(); count == 0 {
+		t.Error("Expected the removed aci values not to be restored")
+	}
+}
+
+func TestSeedDbRemovesTestUserPassword(t *testing.T) {
+	dc := &DataContext{DBName: dbname, DBUser: dbuser}
+	dc.InitDb()
+	defer dc.CloseDb()
+
+	dc.SeedDb()
+	hasPassword := func() bool {
+		var found bool
+		dc.DB.QueryRow(`SELECT user_values ? $2 FROM entries WHERE dn = $1`,
+			testUserDN, models.UserPasswordAttribute).Scan(&found)
+		return found
+	}
+	if hasPassword() {
+		t.Error("Expected", testUserDN, "to be seeded without password")
+	}
+
+	// DBs seeded with the well-known password by earlier versions
+	if _, err := dc.DB.Exec(`UPDATE entries SET user_values = user_values || jsonb_build_object($2::text, jsonb_build_array($3::text)) WHERE dn = $1`,
+		testUserDN, models.UserPasswordAttribute, seededTestUserPassword); err != nil {
+		t.Fatal("Error setting the password:", err)
+	}
+	if _, err := dc.DB.Exec(`DELETE FROM migrations WHERE name = $1`, migrationTestUserPassword); err != nil {
+		t.Fatal("Error removing the migration:", err)
+	}
+	dc.SeedDb()
+	if hasPassword() {
+		t.Error("Expected the well-known password of", testUserDN, "to be removed")
+	}
+}
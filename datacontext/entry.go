@@ -1,10 +1,27 @@
 package datacontext
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/idmworks/speedir/models"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrEntryAlreadyExists is returned when an entry's DN is already in use
+	ErrEntryAlreadyExists = errors.New("Entry already exists")
+	// ErrNoSuchParent is returned when an entry's parent DN does not exist
+	ErrNoSuchParent = errors.New("Parent entry does not exist")
+	// ErrNoSuchEntry is returned when no entry exists with a DN
+	ErrNoSuchEntry = errors.New("Entry does not exist")
+	// ErrNotAllowedOnNonLeaf is returned when an operation requires an entry without children
+	ErrNotAllowedOnNonLeaf = errors.New("Entry has subordinates")
+	// ErrMoveIntoSubtree is returned when moving an entry beneath itself
+	ErrMoveIntoSubtree = errors.New("Cannot move entry beneath itself")
 )
 
 // DBEntry provides DB-centric methods for models.Entry
@@ -50,11 +67,11 @@ func (dc *DataContext) SelectAllNamingContexts() (result DBEntries, err error) {
 	return entries, nil
 }
 
-// SelectEntriesByDN returns a slice of DBEntry with a matching DN
-func (dc *DataContext) SelectEntriesByDN(dn string) (result DBEntries, err error) {
+// SelectEntriesByDN returns a slice of DBEntry named dn, however its DN is spelled
+func (dc *DataContext) SelectEntriesByDN(dn models.DN) (result DBEntries, err error) {
 	entries := make(DBEntries, 0)
 
-	rows, err := dc.DB.Query(sqlSelectEntriesByDN, dn)
+	rows, err := dc.DB.Query(sqlSelectEntriesByDN, dn.Path())
 	if err != nil {
 		return nil, fmt.Errorf("SelectEntriesByDN failed: %v", err)
 	}
@@ -76,15 +93,253 @@ func (dc *DataContext) SelectEntriesByParent(parent string) (result DBEntries, e
 	return entries, nil
 }
 
-// SelectEntriesByParent returns a slice of DBEntry with a matching parent DN
-func (dc *DataContext) SelectEntryTreeByParent(parent string) (result DBEntries, err error) {
-	entries := make(DBEntries, 0)
+// Scope identifies the entries searched relative to a base DN
+// values match the scope of an LDAP SearchRequest
+type Scope int
+
+const (
+	ScopeBaseObject Scope = iota
+	ScopeSingleLevel
+	ScopeWholeSubtree
+	// https://tools.ietf.org/html/draft-sermersheim-ldap-subordinate-scope-02
+	ScopeSubordinateSubtree
+)
+
+// EntryExists returns true if an entry named base exists
+func (dc *DataContext) EntryExists(base models.DN) (bool, error) {
+	var count int
+	if err := dc.DB.QueryRow(sqlSelectEntryCountByPath, base.Path()).Scan(&count); err != nil {
+		return false, fmt.Errorf("EntryExists failed: %v", err)
+	}
+	return count > 0, nil
+}
+
+// SearchEntries calls fn with each entry within scope of base that may match filter,
+// stopping at the first error returned by fn
+// the parts of filter Postgres can evaluate are applied by the query, fn must still test
+// each entry with filter.Matches. The query is cancelled once ctx is done
+func (dc *DataContext) SearchEntries(ctx context.Context, base models.DN, scope Scope, schema *models.Schema,
+	filter *models.Filter, fn func(entry *models.Entry) error) error {
+	path := base.Path()
+	translator := &filterTranslator{schema: schema}
+	query := sqlSelectEntries
+	switch scope {
+	case ScopeBaseObject:
+		query += sqlWhereBaseObject
+		translator.args = []interface{}{path}
+	case ScopeSingleLevel:
+		query += sqlWhereSingleLevel
+		translator.args = []interface{}{path, pathUpperBound(path), len(base) + 1}
+	case ScopeWholeSubtree:
+		query += sqlWhereWholeSubtree
+		translator.args = []interface{}{path, pathUpperBound(path)}
+	case ScopeSubordinateSubtree:
+		query += sqlWhereSubordinateSubtree
+		translator.args = []interface{}{path, pathUpperBound(path)}
+	default:
+		return fmt.Errorf("SearchEntries failed: unknown scope %d", scope)
+	}
+	if predicate, _ := translator.translate(filter); predicate != "TRUE" {
+		query += "\n\tAND " + predicate
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	rows, err := dc.DB.Query(sqlSelectEntryTreeByParent, parent+"%")
+	rows, err := dc.DB.QueryContext(ctx, query, translator.args...)
 	if err != nil {
-		return nil, fmt.Errorf("SelectEntryTreeByParent failed: %v", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("SearchEntries failed: %v", err)
 	}
+	defer rows.Close()
 
-	entries.scan(rows)
-	return entries, nil
+	for rows.Next() {
+		entry := &DBEntry{&models.Entry{}}
+		if err := entry.scan(rows); err != nil {
+			return fmt.Errorf("SearchEntries failed: %v", err)
+		}
+		if err := fn(entry.Entry); err != nil {
+			// cancel the query rather than reading the remaining rows on close
+			cancel()
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("SearchEntries failed: %v", err)
+	}
+	return nil
+}
+
+// InsertEntry inserts a new entry beneath an existing parent
+// the parent of entry is named as stored, regardless of the spelling of its DN in entry
+func (dc *DataContext) InsertEntry(entry *models.Entry) error {
+	if entry.Parent.Valid {
+		parent, err := models.ParseDN(entry.Parent.String)
+		if err != nil {
+			return err
+		}
+		storedParent, err := selectEntryDN(dc.DB.QueryRow(sqlSelectEntryDNByPath, parent.Path()))
+		switch {
+		case err == ErrNoSuchEntry:
+			return ErrNoSuchParent
+		case err != nil:
+			return fmt.Errorf("InsertEntry failed: %v", err)
+		}
+		entry.Parent.String = storedParent
+		entry.DN = entry.RDN + "," + storedParent
+	}
+	if err := insertEntryRow(dc.DB, entry); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code.Name() {
+			case "unique_violation":
+				return ErrEntryAlreadyExists
+			case "foreign_key_violation":
+				return ErrNoSuchParent
+			}
+		}
+		return fmt.Errorf("InsertEntry failed: %v", err)
+	}
+	return nil
+}
+
+// DeleteEntry deletes the leaf entry named dn
+// the parent foreign key prevents deleting entries with subordinates
+func (dc *DataContext) DeleteEntry(dn models.DN) error {
+	return dc.inTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(sqlDeleteEntryByPath, dn.Path())
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "foreign_key_violation" {
+				return ErrNotAllowedOnNonLeaf
+			}
+			return fmt.Errorf("DeleteEntry failed: %v", err)
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("DeleteEntry failed: %v", err)
+		}
+		if count == 0 {
+			return ErrNoSuchEntry
+		}
+		return nil
+	})
+}
+
+// ModifyEntry atomically applies modify to the entry named dn
+// the entry is locked until modify returns and is only updated if modify succeeds
+func (dc *DataContext) ModifyEntry(dn models.DN, modify func(entry *models.Entry) error) error {
+	return dc.inTransaction(func(tx *sql.Tx) error {
+		entry, err := selectEntryForUpdate(tx, dn)
+		if err != nil {
+			return err
+		}
+		if err := modify(entry); err != nil {
+			return err
+		}
+		return updateEntryValues(tx, entry)
+	})
+}
+
+// RenameEntry atomically renames the entry named dn to newDN, rewriting the DN of every
+// subordinate entry
+// the new DN of the entry is made of the RDN of newDN beneath its parent as stored
+// modify is applied to the entry (e.g. to update naming attributes) before it is renamed
+func (dc *DataContext) RenameEntry(dn models.DN, newDN models.DN, modify func(entry *models.Entry) error) error {
+	return dc.inTransaction(func(tx *sql.Tx) error {
+		entry, err := selectEntryForUpdate(tx, dn)
+		if err != nil {
+			return err
+		}
+
+		oldPath, newPath := dn.Path(), newDN.Path()
+		if strings.HasPrefix(newDN.Parent().Path(), oldPath) {
+			return ErrMoveIntoSubtree
+		}
+		newParent, err := selectEntryDN(tx.QueryRow(sqlSelectEntryDNByPath, newDN.Parent().Path()))
+		switch {
+		case err == ErrNoSuchEntry:
+			return ErrNoSuchParent
+		case err != nil:
+			return fmt.Errorf("RenameEntry failed: %v", err)
+		}
+
+		if newPath != oldPath {
+			var count int
+			if err := tx.QueryRow(sqlSelectEntryCountByPath, newPath).Scan(&count); err != nil {
+				return fmt.Errorf("RenameEntry failed: %v", err)
+			}
+			if count > 0 {
+				return ErrEntryAlreadyExists
+			}
+		}
+
+		if err := modify(entry); err != nil {
+			return err
+		}
+		if err := updateEntryValues(tx, entry); err != nil {
+			return err
+		}
+
+		newRDN := newDN.RDN().String()
+		if _, err := tx.Exec(sqlRenameEntryTree, entry.DN, newRDN+","+newParent, newParent, newRDN,
+			oldPath, pathUpperBound(oldPath), newPath, len(newDN)-len(dn)); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+				return ErrEntryAlreadyExists
+			}
+			return fmt.Errorf("RenameEntry failed: %v", err)
+		}
+		return nil
+	})
+}
+
+func selectEntryForUpdate(tx *sql.Tx, dn models.DN) (*models.Entry, error) {
+	rows, err := tx.Query(sqlSelectEntryByPathForUpdate, dn.Path())
+	if err != nil {
+		return nil, fmt.Errorf("selectEntryForUpdate failed: %v", err)
+	}
+	defer rows.Close()
+
+	entries := make(DBEntries, 0)
+	if err := entries.scan(rows); err != nil {
+		return nil, fmt.Errorf("selectEntryForUpdate failed: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoSuchEntry
+	}
+	return entries[0].Entry, nil
+}
+
+// selectEntryDN returns the DN, as stored, of the entry selected by row
+func selectEntryDN(row *sql.Row) (string, error) {
+	var dn string
+	switch err := row.Scan(&dn); err {
+	case nil:
+		return dn, nil
+	case sql.ErrNoRows:
+		return "", ErrNoSuchEntry
+	default:
+		return "", err
+	}
+}
+
+func updateEntryValues(tx *sql.Tx, entry *models.Entry) error {
+	if _, err := tx.Exec(sqlUpdateEntryValues,
+		entry.DN, entry.Classes, entry.UserValues, entry.OperValues); err != nil {
+		return fmt.Errorf("updateEntryValues failed: %v", err)
+	}
+	return nil
+}
+
+// pathUpperBound returns the least string greater than every path beginning with path
+func pathUpperBound(path string) string {
+	if path == "" {
+		// paths begin with an attribute type, which is always ASCII
+		return "\x7f"
+	}
+	// path ends with a comma, the next character is a hyphen
+	return path[:len(path)-1] + "-"
 }
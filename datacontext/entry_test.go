@@ -0,0 +1,186 @@
+package datacontext
+
+import (
+	"database/sql"
+	"strconv"
+	"testing"
+
+	"github.com/idmworks/speedir/models"
+)
+
+// newTestDataContext opens the seeded test DB, without the entries beneath base left by
+// earlier runs
+func newTestDataContext(t *testing.T, base string) *DataContext {
+	dc := &DataContext{DBName: dbname, DBUser: dbuser}
+	if err := dc.InitDb(); err != nil {
+		t.Fatal("InitDb failed:", err)
+	}
+	if err := dc.SeedDb(); err != nil {
+		t.Fatal("SeedDb failed:", err)
+	}
+	path := mustParseDN(t, base).Path()
+	if _, err := dc.DB.Exec(`DELETE FROM entries WHERE path >= $1 AND path < $2`, path, pathUpperBound(path)); err != nil {
+		t.Fatal("Error deleting test entries:", err)
+	}
+	return dc
+}
+
+func mustParseDN(t *testing.T, dn string) models.DN {
+	parsed, err := models.ParseDN(dn)
+	if err != nil {
+		t.Fatal("ParseDN failed:", err)
+	}
+	return parsed
+}
+
+// insertTestEntries inserts an entry named by each dn, parents first
+func insertTestEntries(t *testing.T, dc *DataContext, dns ...string) {
+	for _, dn := range dns {
+		parsed := mustParseDN(t, dn)
+		if err := dc.InsertEntry(&models.Entry{
+			DN:      dn,
+			Parent:  sql.NullString{String: parsed.Parent().String(), Valid: true},
+			RDN:     parsed.RDN().String(),
+			Classes: models.StringSlice{models.PersonClass},
+			UserValues: models.AttributeValues{
+				models.CommonNameAttribute: []string{parsed.RDN()[0].Value},
+			},
+		}); err != nil {
+			t.Fatal("InsertEntry", dn, "failed:", err)
+		}
+	}
+}
+
+// storedDN returns the DN as stored of the entry named dn, empty if there is none
+func storedDN(t *testing.T, dc *DataContext, dn string) string {
+	entries, err := dc.SelectEntriesByDN(mustParseDN(t, dn))
+	if err != nil {
+		t.Fatal("SelectEntriesByDN failed:", err)
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	return entries[0].DN
+}
+
+func TestRenameEntry(t *testing.T) {
+	base := "cn=Rename,dc=example,dc=org"
+	dc := newTestDataContext(t, base)
+	defer dc.CloseDb()
+	insertTestEntries(t, dc, base, "cn=A,"+base, "cn=Child,cn=A,"+base, "cn=B,"+base)
+	unchanged := func(entry *models.Entry) error { return nil }
+
+	tests := []struct {
+		dn       string
+		newDN    string
+		expected map[string]string
+	}{
+		// subtree rename
+		{"cn=A," + base, "cn=A2," + base, map[string]string{
+			"cn=A," + base:           "",
+			"cn=A2," + base:          "cn=A2," + base,
+			"cn=Child,cn=A2," + base: "cn=Child,cn=A2," + base,
+		}},
+		// subtree move
+		{"cn=A2," + base, "cn=A2,cn=B," + base, map[string]string{
+			"cn=Child,cn=A2," + base:      "",
+			"cn=A2,cn=B," + base:          "cn=A2,cn=B," + base,
+			"cn=Child,cn=A2,cn=B," + base: "cn=Child,cn=A2,cn=B," + base,
+		}},
+		// case-only rename
+		{"cn=B," + base, "cn=b," + base, map[string]string{
+			"cn=B," + base:                "cn=b," + base,
+			"cn=Child,cn=A2,cn=B," + base: "cn=Child,cn=A2,cn=b," + base,
+		}},
+	}
+	for _, test := range tests {
+		if err := dc.RenameEntry(mustParseDN(t, test.dn), mustParseDN(t, test.newDN), unchanged); err != nil {
+			t.Error("For", test.dn, "to", test.newDN, "expected success, got", err)
+			continue
+		}
+		for dn, expected := range test.expected {
+			if actual := storedDN(t, dc, dn); actual != expected {
+				t.Error("For", test.dn, "to", test.newDN, "expected", dn, "stored as", expected, "got", actual)
+			}
+		}
+	}
+
+	var depth int
+	child := "cn=Child,cn=A2,cn=b," + base
+	dc.DB.QueryRow(`SELECT depth FROM entries WHERE path = $1`, mustParseDN(t, child).Path()).Scan(&depth)
+	if expected := len(mustParseDN(t, child)); depth != expected {
+		t.Error("For", child, "expected depth", expected, "got", depth)
+	}
+
+	err := dc.RenameEntry(mustParseDN(t, "cn=b,"+base), mustParseDN(t, "cn=b,cn=A2,cn=b,"+base), unchanged)
+	if err != ErrMoveIntoSubtree {
+		t.Error("Expected", ErrMoveIntoSubtree, "moving an entry beneath itself, got", err)
+	}
+}
+
+func TestDeleteEntry(t *testing.T) {
+	base := "cn=Delete,dc=example,dc=org"
+	dc := newTestDataContext(t, base)
+	defer dc.CloseDb()
+	insertTestEntries(t, dc, base, "cn=Leaf,"+base)
+
+	tests := []struct {
+		dn       string
+		expected error
+	}{
+		{base, ErrNotAllowedOnNonLeaf},
+		{"CN=leaf," + base, nil},
+		{"cn=Leaf," + base, ErrNoSuchEntry},
+		{base, nil},
+	}
+	for _, test := range tests {
+		if err := dc.DeleteEntry(mustParseDN(t, test.dn)); err != test.expected {
+			t.Error("For", test.dn, "expected", test.expected, "got", err)
+		}
+	}
+}
+
+func TestModifyEntryIncrement(t *testing.T) {
+	base := "cn=Modify,dc=example,dc=org"
+	dc := newTestDataContext(t, base)
+	defer dc.CloseDb()
+	insertTestEntries(t, dc, base)
+	dn := mustParseDN(t, base)
+
+	increment := func(delta int64, err error) func(entry *models.Entry) error {
+		return func(entry *models.Entry) error {
+			values := entry.UserValues[models.PwdMaxFailureAttribute]
+			n, _ := strconv.ParseInt(append(values, "0")[0], 10, 64)
+			entry.UserValues[models.PwdMaxFailureAttribute] = []string{strconv.FormatInt(n+delta, 10)}
+			return err
+		}
+	}
+	stored := func() []string {
+		entries, err := dc.SelectEntriesByDN(dn)
+		if err != nil || len(entries) == 0 {
+			t.Fatal("SelectEntriesByDN failed:", err)
+		}
+		return entries[0].UserValues[models.PwdMaxFailureAttribute]
+	}
+
+	for _, delta := range []int64{2, 3} {
+		if err := dc.ModifyEntry(dn, increment(delta, nil)); err != nil {
+			t.Fatal("ModifyEntry failed:", err)
+		}
+	}
+	if values := stored(); len(values) != 1 || values[0] != "5" {
+		t.Error("Expected", models.PwdMaxFailureAttribute, "incremented to 5, got", values)
+	}
+
+	// the entry is only updated if modify succeeds
+	if err := dc.ModifyEntry(dn, increment(1, ErrEntryAlreadyExists)); err != ErrEntryAlreadyExists {
+		t.Error("Expected the error of modify, got", err)
+	}
+	if values := stored(); len(values) != 1 || values[0] != "5" {
+		t.Error("Expected", models.PwdMaxFailureAttribute, "unchanged, got", values)
+	}
+
+	if err := dc.ModifyEntry(mustParseDN(t, "cn=Missing,"+base), increment(1, nil)); err != ErrNoSuchEntry {
+		t.Error("Expected", ErrNoSuchEntry, "modifying a missing entry, got", err)
+	}
+}
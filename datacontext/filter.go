@@ -0,0 +1,211 @@
+package datacontext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/idmworks/speedir/models"
+)
+
+// sqlValueNormalizers mirror the models normalizers of the matching rules Postgres can
+// evaluate, each formats an SQL expression normalizing the text value %[1]s
+var sqlValueNormalizers = map[string]string{
+	models.OctetStringMatchRule:         "%[1]s",
+	models.CaseExactMatchRule:           `btrim(regexp_replace(%[1]s, '\s+', ' ', 'g'))`,
+	models.CaseExactIA5MatchRule:        `btrim(regexp_replace(%[1]s, '\s+', ' ', 'g'))`,
+	models.CaseExactSubstrMatchRule:     `btrim(regexp_replace(%[1]s, '\s+', ' ', 'g'))`,
+	models.CaseIgnoreMatchRule:          `lower(btrim(regexp_replace(%[1]s, '\s+', ' ', 'g')))`,
+	models.CaseIgnoreIA5MatchRule:       `lower(btrim(regexp_replace(%[1]s, '\s+', ' ', 'g')))`,
+	models.CaseIgnoreSubstrMatchRule:    `lower(btrim(regexp_replace(%[1]s, '\s+', ' ', 'g')))`,
+	models.CaseIgnoreIA5SubstrMatchRule: `lower(btrim(regexp_replace(%[1]s, '\s+', ' ', 'g')))`,
+}
+
+// filterTranslator translates LDAP filters into parameterized SQL predicates over the
+// entries table, appending assertion values to args
+//
+// Predicates select a superset of the entries matching a filter: anything Postgres cannot
+// evaluate translates to TRUE, leaving the caller to test the returned entries with
+// models.Filter.Matches
+type filterTranslator struct {
+	schema *models.Schema
+	args   []interface{}
+}
+
+// param appends value to the query arguments and returns its placeholder
+func (ft *filterTranslator) param(value interface{}) string {
+	ft.args = append(ft.args, value)
+	return fmt.Sprintf("$%d", len(ft.args))
+}
+
+// translate returns the SQL predicate for filter and whether the predicate is exact,
+// i.e. true precisely for the entries the filter evaluates to TRUE and false otherwise
+func (ft *filterTranslator) translate(filter *models.Filter) (predicate string, exact bool) {
+	switch filter.Type {
+	case models.FilterAnd, models.FilterOr:
+		operator, empty := " AND ", "TRUE"
+		if filter.Type == models.FilterOr {
+			operator, empty = " OR ", "FALSE"
+		}
+		predicates := []string{}
+		exact = true
+		for _, child := range filter.Children {
+			childPredicate, childExact := ft.translate(child)
+			exact = exact && childExact
+			if childPredicate == "TRUE" {
+				if filter.Type == models.FilterOr {
+					return "TRUE", exact
+				}
+				continue
+			}
+			predicates = append(predicates, childPredicate)
+		}
+		switch {
+		case len(predicates) > 0:
+			return "(" + strings.Join(predicates, operator) + ")", exact
+		case len(filter.Children) == 0:
+			// absolute true and false filters https://tools.ietf.org/html/rfc4526
+			return empty, true
+		}
+		return "TRUE", exact
+
+	case models.FilterNot:
+		// the complement of a superset is not a superset of the complement
+		if len(filter.Children) != 1 {
+			return "TRUE", false
+		}
+		// predicates over missing (NULL) columns are NULL rather than false
+		if childPredicate, childExact := ft.translate(filter.Children[0]); childExact {
+			return "NOT coalesce(" + childPredicate + ", FALSE)", true
+		}
+		return "TRUE", false
+
+	case models.FilterPresent:
+		return ft.translatePresent(filter)
+
+	case models.FilterEqualityMatch:
+		return ft.translateEquality(filter)
+
+	case models.FilterSubstrings:
+		return ft.translateSubstrings(filter)
+	}
+
+	return "TRUE", false
+}
+
+func (ft *filterTranslator) translatePresent(filter *models.Filter) (string, bool) {
+	attributeType := ft.schema.AttributeType(filter.Attribute)
+	if attributeType == nil {
+		return "TRUE", false
+	}
+	if attributeType.Name == models.ObjectClassAttribute {
+		return "TRUE", true
+	}
+	column := valuesColumn(attributeType)
+	return fmt.Sprintf("%s ?| %s::text[]", column, ft.param(ft.attributeNames(attributeType))), true
+}
+
+func (ft *filterTranslator) translateEquality(filter *models.Filter) (string, bool) {
+	attributeType := ft.schema.AttributeType(filter.Attribute)
+	if attributeType == nil {
+		return "TRUE", false
+	}
+
+	if attributeType.Name == models.ObjectClassAttribute {
+		objectClass := ft.schema.ObjectClass(filter.Value)
+		switch {
+		case objectClass == nil:
+			return "TRUE", false
+		case objectClass.Name == models.TopClass:
+			return "TRUE", true
+		}
+		// classes holds the canonical name of every class of the entry (excluding top)
+		return fmt.Sprintf("classes @> %s::text[]", ft.param(models.StringSlice{objectClass.Name})), true
+	}
+
+	rule := ft.schema.EqualityMatch(attributeType)
+	normalizer, found := sqlValueNormalizers[rule]
+	if !found {
+		return "TRUE", false
+	}
+	value, err := ft.schema.NormalizeValue(rule, filter.Value)
+	if err != nil {
+		return "TRUE", false
+	}
+
+	column := valuesColumn(attributeType)
+	if rule == models.OctetStringMatchRule {
+		predicates := []string{}
+		for _, name := range ft.attributeNames(attributeType) {
+			predicates = append(predicates, fmt.Sprintf("%s @> %s::jsonb",
+				column, ft.param(models.AttributeValues{name: []string{value}})))
+		}
+		return "(" + strings.Join(predicates, " OR ") + ")", true
+	}
+	return ft.anyValue(column, attributeType,
+		fmt.Sprintf(normalizer, "value")+" = "+ft.param(value)), true
+}
+
+func (ft *filterTranslator) translateSubstrings(filter *models.Filter) (string, bool) {
+	attributeType := ft.schema.AttributeType(filter.Attribute)
+	if attributeType == nil || attributeType.Name == models.ObjectClassAttribute {
+		return "TRUE", false
+	}
+
+	rule := ft.schema.SubstrMatch(attributeType)
+	normalizer, found := sqlValueNormalizers[rule]
+	if !found {
+		return "TRUE", false
+	}
+
+	substrings := append(append([]string{filter.Initial}, filter.Any...), filter.Final)
+	for i, substring := range substrings {
+		if substring != "" {
+			normalized, err := ft.schema.NormalizeValue(rule, substring)
+			if err != nil {
+				return "TRUE", false
+			}
+			substring = normalized
+		}
+		substrings[i] = escapeLikePattern(substring)
+	}
+	pattern := strings.Join(substrings, "%")
+
+	// values are normalized only for spaces, letting ILIKE match case-insensitively
+	operator := "LIKE"
+	if strings.HasPrefix(normalizer, "lower(") {
+		operator = "ILIKE"
+		normalizer = sqlValueNormalizers[models.CaseExactMatchRule]
+	}
+	return ft.anyValue(valuesColumn(attributeType), attributeType,
+		fmt.Sprintf(normalizer, "value")+" "+operator+" "+ft.param(pattern)), true
+}
+
+// anyValue returns a predicate true when condition holds for any value of attributeType
+// (or one of its subtypes) held in column
+func (ft *filterTranslator) anyValue(column string, attributeType *models.AttributeType, condition string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM jsonb_each(%s) AS attribute(name, vals), jsonb_array_elements_text(attribute.vals) AS value WHERE attribute.name = ANY(%s::text[]) AND %s)",
+		column, ft.param(ft.attributeNames(attributeType)), condition)
+}
+
+// attributeNames returns the names under which values of attributeType and its
+// subtypes are stored
+func (ft *filterTranslator) attributeNames(attributeType *models.AttributeType) models.StringSlice {
+	names := models.StringSlice{}
+	for _, subtype := range ft.schema.Subtypes(attributeType) {
+		names = append(names, subtype.Name)
+	}
+	return names
+}
+
+// valuesColumn returns the column holding the values of attributeType
+func valuesColumn(attributeType *models.AttributeType) string {
+	if attributeType.IsOperational() {
+		return "oper_values"
+	}
+	return "user_values"
+}
+
+// escapeLikePattern escapes the LIKE wildcards in value
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
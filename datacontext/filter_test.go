@@ -0,0 +1,65 @@
+package datacontext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/idmworks/speedir/models"
+)
+
+type translateTest struct {
+	filter   string
+	contains string
+	exact    bool
+}
+
+var translateTests = []translateTest{
+	{"(objectClass=*)", "TRUE", true},
+	{"(objectClass=person)", "classes @> $1::text[]", true},
+	{"(cn=*)", "user_values ?| $1::text[]", true},
+	{"(createTimestamp=*)", "oper_values ?| $1::text[]", true},
+	{"(cn=Test User)", "lower(btrim(regexp_replace(value, '\\s+', ' ', 'g'))) = $1", true},
+	{"(cn=te*us*)", "btrim(regexp_replace(value, '\\s+', ' ', 'g')) ILIKE $1", true},
+	{"(!(sn=user))", "NOT coalesce(", true},
+	{"(&(sn=user)(undefinedAttribute=x))", "AND", false},
+	{"(|(sn=user)(undefinedAttribute=x))", "TRUE", false},
+	{"(!(sn>=user))", "TRUE", false},
+	{"(|)", "FALSE", true},
+}
+
+func newTestSchema() *models.Schema {
+	attributeTypes := []*models.AttributeType{}
+	for i := range models.LDAPv3AttributeTypes {
+		attributeTypes = append(attributeTypes, &models.LDAPv3AttributeTypes[i])
+	}
+	objectClasses := []*models.ObjectClass{}
+	for i := range models.LDAPv3ObjectClasses {
+		objectClasses = append(objectClasses, &models.LDAPv3ObjectClasses[i])
+	}
+	return models.NewSchema(attributeTypes, objectClasses)
+}
+
+func TestFilterTranslate(t *testing.T) {
+	schema := newTestSchema()
+	for _, test := range translateTests {
+		filter, err := models.ParseFilter(test.filter)
+		if err != nil {
+			t.Error("For", test, "ParseFilter failed:", err)
+			continue
+		}
+		translator := &filterTranslator{schema: schema}
+		predicate, exact := translator.translate(filter)
+		if !strings.Contains(predicate, test.contains) || exact != test.exact {
+			t.Error("For", test, "got", predicate, exact)
+		}
+	}
+}
+
+func TestFilterTranslateArgs(t *testing.T) {
+	filter, _ := models.ParseFilter("(cn=*50%_off*)")
+	translator := &filterTranslator{schema: newTestSchema()}
+	translator.translate(filter)
+	if len(translator.args) != 2 || translator.args[0] != `%50\%\_off%` {
+		t.Error("Unexpected args", translator.args)
+	}
+}
@@ -0,0 +1,28 @@
+package datacontext
+
+import (
+	"github.com/idmworks/speedir/models"
+)
+
+// SelectSchema returns a models.Schema for all attributeTypes and objectClasses
+func (dc *DataContext) SelectSchema() (result *models.Schema, err error) {
+	dbAttributeTypes, err := dc.SelectAllAttributeTypes()
+	if err != nil {
+		return nil, err
+	}
+	dbObjectClasses, err := dc.SelectAllObjectClasses()
+	if err != nil {
+		return nil, err
+	}
+
+	attributeTypes := make([]*models.AttributeType, len(dbAttributeTypes))
+	for i, attributeType := range dbAttributeTypes {
+		attributeTypes[i] = attributeType.AttributeType
+	}
+	objectClasses := make([]*models.ObjectClass, len(dbObjectClasses))
+	for i, objectClass := range dbObjectClasses {
+		objectClasses[i] = objectClass.ObjectClass
+	}
+
+	return models.NewSchema(attributeTypes, objectClasses), nil
+}
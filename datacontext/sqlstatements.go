@@ -9,20 +9,38 @@ CREATE TABLE IF NOT EXISTS users
   created bigint,
   username text,
   passwordhash text,
-  passwordsalt text
+  passwordsalt text,
+  scramsha1 text,
+  scramsha256 text
 )
 WITH (
   OIDS=FALSE
 );`
+	// Migrations table, recording the data migrations run once
+	sqlCreateMigrationsTable = `
+CREATE TABLE IF NOT EXISTS migrations
+(
+	name text PRIMARY KEY
+)`
+	sqlInsertMigrationRow = `
+INSERT INTO migrations (name) VALUES ($1)
+ON CONFLICT (name) DO NOTHING`
+	sqlAddUsersScramColumns = `
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS scramsha1 text,
+	ADD COLUMN IF NOT EXISTS scramsha256 text`
 	sqlInsertUserRow = `
 INSERT INTO users
-(created, username, passwordhash, passwordsalt)
+(created, username, passwordhash, passwordsalt, scramsha1, scramsha256)
 VALUES
-($1, $2, $3, $4)`
+($1, $2, $3, $4, $5, $6)`
 	sqlSelectUserCountByUsername = `
 SELECT COUNT(id) FROM users WHERE username = $1`
 	sqlSelectUserByUsername = `
-SELECT id, created, username, passwordhash, passwordsalt FROM users WHERE username = $1`
+SELECT id, created, username, passwordhash, passwordsalt, coalesce(scramsha1, ''), coalesce(scramsha256, '')
+FROM users WHERE username = $1`
+	sqlUpdateUserPassword = `
+UPDATE users SET passwordhash = $2, passwordsalt = $3, scramsha1 = $4, scramsha256 = $5 WHERE id = $1`
 
 	// Syntaxes table
 	sqlCreateSyntaxesTable = `
@@ -88,8 +106,6 @@ CREATE TABLE IF NOT EXISTS attribute_types
 WITH (
 	OIDS=FALSE
 )`
-	sqlSelectAttributeTypeCount = `
-SELECT COUNT(oid) FROM attribute_types`
 	sqlSelectAllAttributeTypes = `
 SELECT name
 	, oid
@@ -107,7 +123,12 @@ INSERT INTO attribute_types
 (name, oid, syntax, super, names, flags, usage,
 	equality_match, substring_match, ordering_match)
 VALUES
-($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (name) DO NOTHING`
+	// sets the equality matching rule of attribute types seeded before it was defined
+	sqlUpdateAttributeTypeEqualityMatch = `
+UPDATE attribute_types SET equality_match = $2
+WHERE name = $1 AND equality_match IS NULL`
 
 	// ObjectClasses table
 	sqlCreateObjectClassesTable = `
@@ -124,8 +145,6 @@ CREATE TABLE IF NOT EXISTS object_classes
 WITH (
 	OIDS=FALSE
 )`
-	sqlSelectObjectClassCount = `
-SELECT COUNT(oid) FROM object_classes`
 	sqlSelectAllObjectClasses = `
 SELECT name
 	, oid
@@ -140,9 +159,17 @@ INSERT INTO object_classes
 (name, oid, super, names, flags,
 	must_attributes, may_attributes)
 VALUES
-($1, $2, $3, $4, $5, $6, $7)`
+($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (name) DO NOTHING`
+	sqlUpdateObjectClassMayAttributes = `
+UPDATE object_classes
+SET may_attributes = coalesce(may_attributes, '{}') ||
+	ARRAY(SELECT unnest($2::text[]) EXCEPT SELECT unnest(coalesce(may_attributes, '{}')))
+WHERE name = $1 AND NOT coalesce(may_attributes, '{}') @> $2::text[]`
 
 	// Entries table
+	// path holds the normalized RDNs of an entry from the root down (see models.DN.Path)
+	// so that subtrees are contiguous ranges of the path index
 	sqlCreateEntriesTable = `
 CREATE TABLE IF NOT EXISTS entries
 (
@@ -151,13 +178,41 @@ CREATE TABLE IF NOT EXISTS entries
 	rdn text NOT NULL,
 	classes text[],
   user_values jsonb,
-	oper_values jsonb
+	oper_values jsonb,
+	path text COLLATE "C" NOT NULL,
+	depth int NOT NULL
 )
 WITH (
 	OIDS=FALSE
 )`
+	sqlAddEntriesPathColumns = `
+ALTER TABLE entries
+	ADD COLUMN IF NOT EXISTS path text COLLATE "C",
+	ADD COLUMN IF NOT EXISTS depth int`
+	sqlSelectEntryPaths = `
+SELECT dn, coalesce(path, '') FROM entries`
+	sqlUpdateEntryPath = `
+UPDATE entries SET path = $2, depth = $3 WHERE dn = $1`
+	sqlRequireEntriesPathColumns = `
+ALTER TABLE entries
+	ALTER COLUMN path SET NOT NULL,
+	ALTER COLUMN depth SET NOT NULL`
+	sqlCreateEntriesPathIndex = `
+CREATE UNIQUE INDEX IF NOT EXISTS entries_path_idx ON entries (path)`
+	sqlCreateEntriesDepthPathIndex = `
+CREATE INDEX IF NOT EXISTS entries_depth_path_idx ON entries (depth, path)`
+	sqlCreateEntriesParentIndex = `
+CREATE INDEX IF NOT EXISTS entries_parent_idx ON entries (parent)`
 	sqlSelectEntryCount = `
 SELECT COUNT(dn) FROM entries`
+	sqlSelectEntryWithOperAttributeCount = `
+SELECT COUNT(dn) FROM entries WHERE oper_values ? $1`
+	sqlAddNamingContextsOperValues = `
+UPDATE entries SET oper_values = coalesce(oper_values, '{}') || jsonb_build_object($1::text, $2::jsonb)
+WHERE parent IS NULL`
+	sqlRemoveEntryUserValues = `
+UPDATE entries SET user_values = user_values - $2::text
+WHERE dn = $1 AND user_values -> $2::text = $3::jsonb`
 	sqlSelectAllNamingContexts = `
 SELECT dn
 	, parent
@@ -175,7 +230,7 @@ SELECT dn
 	, user_values
 	, oper_values
 FROM entries
-WHERE dn = $1`
+WHERE path = $1`
 	sqlSelectEntriesByParent = `
 SELECT dn
 	, parent
@@ -185,19 +240,63 @@ SELECT dn
 	, oper_values
 FROM entries
 WHERE parent = $1`
-	sqlSelectEntryTreeByParent = `
+	// search predicates are appended to sqlSelectEntries by SearchEntries
+	sqlSelectEntries = `
 SELECT dn
 	, parent
 	, rdn
 	, array_to_json(classes)
 	, user_values
 	, oper_values
-FROM entries
-WHERE dn LIKE $1`
+FROM entries`
+	// $1 is the path of the base entry, $2 the upper bound of paths beneath it
+	// and $3 the depth of its immediate subordinates
+	sqlWhereBaseObject = `
+WHERE path = $1`
+	sqlWhereSingleLevel = `
+WHERE depth = $3 AND path > $1 AND path < $2`
+	sqlWhereWholeSubtree = `
+WHERE path >= $1 AND path < $2`
+	sqlWhereSubordinateSubtree = `
+WHERE path > $1 AND path < $2`
+	sqlSelectEntryCountByPath = `
+SELECT COUNT(dn) FROM entries WHERE path = $1`
+	sqlSelectEntryDNByPath = `
+SELECT dn FROM entries WHERE path = $1`
 	sqlInsertEntryRow = `
 INSERT INTO entries
 (dn, parent, rdn, classes,
-	user_values, oper_values)
+	user_values, oper_values, path, depth)
 VALUES
-($1, $2, $3, $4, $5, $6)`
+($1, $2, $3, $4, $5, $6, $7, $8)`
+	sqlSelectEntryByPathForUpdate = `
+SELECT dn
+	, parent
+	, rdn
+	, array_to_json(classes)
+	, user_values
+	, oper_values
+FROM entries
+WHERE path = $1
+FOR UPDATE`
+	sqlUpdateEntryValues = `
+UPDATE entries
+SET classes = $2
+	, user_values = $3
+	, oper_values = $4
+WHERE dn = $1`
+	// renames $1 to $2 (with parent $3 and rdn $4) along with all its subordinates,
+	// replacing the path prefix $5 (bounded by $6) with $7 and adjusting depths by $8
+	sqlRenameEntryTree = `
+UPDATE entries
+SET dn = CASE WHEN dn = $1 THEN $2
+		ELSE left(dn, length(dn) - length($1)) || $2 END
+	, parent = CASE WHEN dn = $1 THEN $3
+		ELSE left(parent, length(parent) - length($1)) || $2 END
+	, rdn = CASE WHEN dn = $1 THEN $4 ELSE rdn END
+	, path = $7 || substr(path, length($5) + 1)
+	, depth = depth + $8
+WHERE path >= $5 AND path < $6`
+	sqlDeleteEntryByPath = `
+DELETE FROM entries WHERE path = $1`
 )
@@ -32,7 +32,9 @@ func (user *DBUser) scan(rows *sql.Rows) error {
 		&user.Created,
 		&user.Username,
 		&user.PasswordHash,
-		&user.PasswordSalt)
+		&user.PasswordSalt,
+		&user.ScramSHA1,
+		&user.ScramSHA256)
 	return err
 }
 
@@ -48,3 +50,17 @@ func (dc *DataContext) SelectUsersByUsername(username string) (result DBUsers, e
 	users.scan(rows)
 	return users, nil
 }
+
+// SelectAdminUsers returns a slice of DBUser holding the directory administrator
+func (dc *DataContext) SelectAdminUsers() (result DBUsers, err error) {
+	return dc.SelectUsersByUsername(adminUsername)
+}
+
+// UpdateUserPassword stores the password hash, salt & SCRAM keys of user
+func (dc *DataContext) UpdateUserPassword(user *models.User) error {
+	if _, err := dc.DB.Exec(sqlUpdateUserPassword,
+		user.Id, user.PasswordHash, user.PasswordSalt, user.ScramSHA1, user.ScramSHA256); err != nil {
+		return fmt.Errorf("UpdateUserPassword failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+package models
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrInvalidACI is returned when an aci value cannot be parsed
+var ErrInvalidACI = errors.New("Invalid aci syntax")
+
+// AccessRight is a set of operations granted or denied by an access control instruction
+type AccessRight int
+
+const (
+	ReadRight AccessRight = 1 << iota
+	SearchRight
+	CompareRight
+	WriteRight
+	AddRight
+	DeleteRight
+
+	AllRights = ReadRight | SearchRight | CompareRight | WriteRight | AddRight | DeleteRight
+)
+
+var accessRightNames = []struct {
+	name  string
+	right AccessRight
+}{
+	{"read", ReadRight},
+	{"search", SearchRight},
+	{"compare", CompareRight},
+	{"write", WriteRight},
+	{"add", AddRight},
+	{"delete", DeleteRight},
+	{"all", AllRights},
+}
+
+func (right AccessRight) String() string {
+	names := []string{}
+	for _, named := range accessRightNames[:len(accessRightNames)-1] {
+		if right&named.right != 0 {
+			names = append(names, named.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// SubjectType identifies the clients matched by a subject of an access control instruction
+type SubjectType int
+
+const (
+	// SubjectUserDN matches the client bound as DN
+	SubjectUserDN SubjectType = iota
+	// SubjectGroupDN matches the members of the group entry named DN
+	SubjectGroupDN
+	// SubjectSelf matches the client bound as the entry accessed
+	SubjectSelf
+	// SubjectAnyone matches every client
+	SubjectAnyone
+	// SubjectAuthenticated matches the clients that are not anonymous
+	SubjectAuthenticated
+	// SubjectAnonymous matches the anonymous clients
+	SubjectAnonymous
+	// SubjectIPRange matches the clients connecting from Network
+	SubjectIPRange
+)
+
+// ACISubject is a subject of an access control instruction
+type ACISubject struct {
+	Type    SubjectType
+	DN      DN
+	Network *net.IPNet
+}
+
+// ACIPermission grants or denies Rights to the clients matched by any of its subjects
+type ACIPermission struct {
+	Allow    bool
+	Rights   AccessRight
+	Subjects []ACISubject
+}
+
+// ACI is an access control instruction held by an aci value, applying to the entries of
+// the subtree of the entry holding it
+//
+//	aci        = *target "(" "version 3.0;" "acl" quoted ";" 1*permission ")"
+//	target     = "(" ( "target" | "targetfilter" ) "=" quoted ")" /
+//	             "(" "targetattr" ( "=" | "!=" ) quoted ")"
+//	permission = ( "allow" | "deny" ) "(" right *( "," right ) ")" subject *( "or" subject ) ";"
+//	right      = "read" | "search" | "compare" | "write" | "add" | "delete" | "all"
+//	subject    = ( "userdn" | "groupdn" | "ip" ) "=" quoted
+//
+// targets & subjects name entries as "ldap:///" DN, targetattr & subjects may list several
+// values separated by "||". userdn also accepts "ldap:///self", "ldap:///anyone",
+// "ldap:///all" for authenticated clients & "ldap:///anonymous", ip accepts an address or
+// a CIDR range. For example:
+//
+//	(targetattr!="userPassword")(version 3.0; acl "Read"; allow (read,search,compare) userdn="ldap:///anyone";)
+type ACI struct {
+	// Holder is the DN of the entry holding the aci value
+	Holder DN
+	Name   string
+	// Target restricts the ACI to a subtree of the subtree of Holder, nil if unrestricted
+	Target DN
+	// TargetAttributes restricts the ACI to attribute types & their subtypes, all if empty
+	// ExcludeAttributes restricts it to the other attribute types instead
+	TargetAttributes  []string
+	ExcludeAttributes bool
+	// TargetFilter restricts the ACI to the entries it matches, nil if unrestricted
+	TargetFilter *Filter
+	Permissions  []ACIPermission
+}
+
+// Requester is a client whose access is evaluated
+type Requester struct {
+	// DN is the identity the client is bound as, empty if anonymous
+	DN      DN
+	Address net.IP
+	// IsMember returns true if the client is a member of the group entry named group
+	IsMember func(group DN) bool
+}
+
+// AccessControls holds the access control instructions stored in the directory
+type AccessControls []*ACI
+
+// Allows returns true if an access control instruction grants right on attributeType of
+// the entry named dn to requester & none denies it, attributeType is nil for the rights on
+// the entry itself
+func (acis AccessControls) Allows(schema *Schema, requester *Requester, dn DN, entry *Entry,
+	attributeType *AttributeType, right AccessRight) bool {
+	allowed := false
+	for _, aci := range acis {
+		if !aci.appliesTo(schema, dn, entry, attributeType) {
+			continue
+		}
+		for _, permission := range aci.Permissions {
+			if permission.Rights&right == 0 || !permission.matches(requester, dn) {
+				continue
+			}
+			if !permission.Allow {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// appliesTo returns true if attributeType of the entry named dn is targeted by aci
+func (aci *ACI) appliesTo(schema *Schema, dn DN, entry *Entry, attributeType *AttributeType) bool {
+	path := dn.Path()
+	if !strings.HasPrefix(path, aci.Holder.Path()) ||
+		aci.Target != nil && !strings.HasPrefix(path, aci.Target.Path()) {
+		return false
+	}
+	if aci.TargetFilter != nil && !aci.TargetFilter.Matches(schema, entry) {
+		return false
+	}
+	if attributeType == nil || len(aci.TargetAttributes) == 0 {
+		return true
+	}
+
+	targeted := false
+	for _, name := range aci.TargetAttributes {
+		if name == AllUserAttributes {
+			targeted = true
+		} else if target := schema.AttributeType(name); target != nil && schema.IsSubtype(attributeType, target) {
+			targeted = true
+		}
+	}
+	return targeted != aci.ExcludeAttributes
+}
+
+// matches returns true if requester accessing the entry named dn is one of the subjects
+// of permission
+func (permission *ACIPermission) matches(requester *Requester, dn DN) bool {
+	anonymous := requester.DN.IsEmpty()
+	for _, subject := range permission.Subjects {
+		switch subject.Type {
+		case SubjectAnyone:
+			return true
+		case SubjectAuthenticated:
+			if !anonymous {
+				return true
+			}
+		case SubjectAnonymous:
+			if anonymous {
+				return true
+			}
+		case SubjectSelf:
+			if !anonymous && requester.DN.Equal(dn) {
+				return true
+			}
+		case SubjectUserDN:
+			if !anonymous && requester.DN.Equal(subject.DN) {
+				return true
+			}
+		case SubjectGroupDN:
+			if !anonymous && requester.IsMember != nil && requester.IsMember(subject.DN) {
+				return true
+			}
+		case SubjectIPRange:
+			if requester.Address != nil && subject.Network.Contains(requester.Address) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseACI parses an aci value held by the entry named holder
+func ParseACI(holder DN, value string) (*ACI, error) {
+	aci := &ACI{Holder: holder}
+	parser := &aciParser{s: value}
+
+	// targets
+	for {
+		if !parser.consume("(") {
+			return nil, ErrInvalidACI
+		}
+		keyword := parser.keyword()
+		if keyword == "version" {
+			break
+		}
+		exclude := parser.consume("!=")
+		if !exclude && !parser.consume("=") {
+			return nil, ErrInvalidACI
+		}
+		target, ok := parser.quoted()
+		if !ok || !parser.consume(")") {
+			return nil, ErrInvalidACI
+		}
+		if err := aci.setTarget(keyword, target, exclude); err != nil {
+			return nil, err
+		}
+	}
+
+	if parser.keyword() != "3.0" || !parser.consume(";") || parser.keyword() != "acl" {
+		return nil, ErrInvalidACI
+	}
+	name, ok := parser.quoted()
+	if !ok || !parser.consume(";") {
+		return nil, ErrInvalidACI
+	}
+	aci.Name = name
+
+	for !parser.consume(")") {
+		permission, err := parser.permission()
+		if err != nil {
+			return nil, err
+		}
+		aci.Permissions = append(aci.Permissions, permission)
+	}
+	if len(aci.Permissions) == 0 || strings.TrimSpace(parser.s[parser.pos:]) != "" {
+		return nil, ErrInvalidACI
+	}
+	return aci, nil
+}
+
+// setTarget records the target keyword of aci
+func (aci *ACI) setTarget(keyword string, value string, exclude bool) error {
+	if exclude && keyword != "targetattr" {
+		return ErrInvalidACI
+	}
+	switch keyword {
+	case "target":
+		if aci.Target != nil {
+			return ErrInvalidACI
+		}
+		dn, err := parseLDAPURL(value)
+		if err != nil {
+			return err
+		}
+		aci.Target = dn
+	case "targetattr":
+		if len(aci.TargetAttributes) > 0 {
+			return ErrInvalidACI
+		}
+		for _, name := range strings.Split(value, "||") {
+			if name = strings.TrimSpace(name); name == "" {
+				return ErrInvalidACI
+			}
+			aci.TargetAttributes = append(aci.TargetAttributes, name)
+		}
+		aci.ExcludeAttributes = exclude
+	case "targetfilter":
+		if aci.TargetFilter != nil {
+			return ErrInvalidACI
+		}
+		filter, err := ParseFilter(value)
+		if err != nil {
+			return ErrInvalidACI
+		}
+		aci.TargetFilter = filter
+	default:
+		return ErrInvalidACI
+	}
+	return nil
+}
+
+// aciParser scans an aci value
+type aciParser struct {
+	s   string
+	pos int
+}
+
+func (parser *aciParser) skipSpaces() {
+	for parser.pos < len(parser.s) && parser.s[parser.pos] == ' ' {
+		parser.pos++
+	}
+}
+
+// consume skips token if it comes next, ignoring leading spaces
+func (parser *aciParser) consume(token string) bool {
+	parser.skipSpaces()
+	if !strings.HasPrefix(parser.s[parser.pos:], token) {
+		return false
+	}
+	parser.pos += len(token)
+	return true
+}
+
+// keyword returns the next word of letters, digits & dots in lower case, ignoring leading spaces
+func (parser *aciParser) keyword() string {
+	parser.skipSpaces()
+	start := parser.pos
+	for parser.pos < len(parser.s) {
+		c := parser.s[parser.pos]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.') {
+			break
+		}
+		parser.pos++
+	}
+	return strings.ToLower(parser.s[start:parser.pos])
+}
+
+// quoted returns the next double quoted string, in which quotes & backslashes are escaped
+// by a backslash
+func (parser *aciParser) quoted() (string, bool) {
+	if !parser.consume(`"`) {
+		return "", false
+	}
+	value := []byte{}
+	for ; parser.pos < len(parser.s); parser.pos++ {
+		switch c := parser.s[parser.pos]; {
+		case c == '"':
+			parser.pos++
+			return string(value), true
+		case c == '\\' && parser.pos+1 < len(parser.s):
+			parser.pos++
+			value = append(value, parser.s[parser.pos])
+		default:
+			value = append(value, c)
+		}
+	}
+	return "", false
+}
+
+// permission parses an allow or deny permission along with its subjects
+func (parser *aciParser) permission() (ACIPermission, error) {
+	permission := ACIPermission{}
+	switch parser.keyword() {
+	case "allow":
+		permission.Allow = true
+	case "deny":
+	default:
+		return permission, ErrInvalidACI
+	}
+
+	if !parser.consume("(") {
+		return permission, ErrInvalidACI
+	}
+	for {
+		right := parseAccessRight(parser.keyword())
+		if right == 0 {
+			return permission, ErrInvalidACI
+		}
+		permission.Rights |= right
+		if parser.consume(")") {
+			break
+		}
+		if !parser.consume(",") {
+			return permission, ErrInvalidACI
+		}
+	}
+
+	for {
+		keyword := parser.keyword()
+		if !parser.consume("=") {
+			return permission, ErrInvalidACI
+		}
+		value, ok := parser.quoted()
+		if !ok {
+			return permission, ErrInvalidACI
+		}
+		for _, value := range strings.Split(value, "||") {
+			subject, err := parseACISubject(keyword, strings.TrimSpace(value))
+			if err != nil {
+				return permission, err
+			}
+			permission.Subjects = append(permission.Subjects, subject)
+		}
+		if parser.consume(";") {
+			return permission, nil
+		}
+		if parser.keyword() != "or" {
+			return permission, ErrInvalidACI
+		}
+	}
+}
+
+func parseAccessRight(name string) AccessRight {
+	for _, named := range accessRightNames {
+		if named.name == name {
+			return named.right
+		}
+	}
+	return 0
+}
+
+// parseACISubject parses the value of a userdn, groupdn or ip subject
+func parseACISubject(keyword string, value string) (ACISubject, error) {
+	switch keyword {
+	case "userdn":
+		switch strings.ToLower(value) {
+		case "ldap:///self":
+			return ACISubject{Type: SubjectSelf}, nil
+		case "ldap:///anyone":
+			return ACISubject{Type: SubjectAnyone}, nil
+		case "ldap:///all":
+			return ACISubject{Type: SubjectAuthenticated}, nil
+		case "ldap:///anonymous":
+			return ACISubject{Type: SubjectAnonymous}, nil
+		}
+		dn, err := parseLDAPURL(value)
+		return ACISubject{Type: SubjectUserDN, DN: dn}, err
+	case "groupdn":
+		dn, err := parseLDAPURL(value)
+		return ACISubject{Type: SubjectGroupDN, DN: dn}, err
+	case "ip":
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil {
+				bits := 8 * len(ip)
+				if ip4 := ip.To4(); ip4 != nil {
+					ip, bits = ip4, 32
+				}
+				return ACISubject{Type: SubjectIPRange, Network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return ACISubject{}, ErrInvalidACI
+		}
+		return ACISubject{Type: SubjectIPRange, Network: network}, nil
+	}
+	return ACISubject{}, ErrInvalidACI
+}
+
+// parseLDAPURL returns the non-empty DN of an "ldap:///" URL
+func parseLDAPURL(value string) (DN, error) {
+	const prefix = "ldap:///"
+	if len(value) < len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return nil, ErrInvalidACI
+	}
+	dn, err := ParseDN(value[len(prefix):])
+	if err != nil || dn.IsEmpty() {
+		return nil, ErrInvalidACI
+	}
+	return dn, nil
+}
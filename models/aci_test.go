@@ -0,0 +1,106 @@
+package models
+
+import (
+	"net"
+	"testing"
+)
+
+func mustParseDN(t *testing.T, dn string) DN {
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		t.Fatal("ParseDN failed:", dn, err)
+	}
+	return parsed
+}
+
+func TestParseACI(t *testing.T) {
+	holder := mustParseDN(t, "dc=example,dc=org")
+	aci, err := ParseACI(holder, `(target="ldap:///ou=People,dc=example,dc=org")(targetattr != "userPassword || aci")`+
+		`(targetfilter="(objectClass=person)")(version 3.0; acl "People \"read\""; `+
+		`allow (read, search) userdn="ldap:///self || ldap:///cn=admin,dc=example,dc=org" or ip="192.0.2.0/24"; `+
+		`deny (all) groupdn="ldap:///cn=Banned,dc=example,dc=org";)`)
+	if err != nil {
+		t.Fatal("ParseACI failed:", err)
+	}
+	if aci.Name != `People "read"` || aci.Target.String() != "ou=People,dc=example,dc=org" ||
+		len(aci.TargetAttributes) != 2 || !aci.ExcludeAttributes || aci.TargetFilter == nil {
+		t.Error("Unexpected targets", aci)
+	}
+	if len(aci.Permissions) != 2 {
+		t.Fatal("Expected 2 permissions, got", aci.Permissions)
+	}
+	allow, deny := aci.Permissions[0], aci.Permissions[1]
+	if !allow.Allow || allow.Rights != ReadRight|SearchRight || len(allow.Subjects) != 3 ||
+		allow.Subjects[0].Type != SubjectSelf || allow.Subjects[1].Type != SubjectUserDN ||
+		allow.Subjects[2].Type != SubjectIPRange {
+		t.Error("Unexpected allow permission", allow)
+	}
+	if deny.Allow || deny.Rights != AllRights || len(deny.Subjects) != 1 || deny.Subjects[0].Type != SubjectGroupDN {
+		t.Error("Unexpected deny permission", deny)
+	}
+
+	invalid := []string{
+		``,
+		`(version 3.0; acl "none";)`,
+		`(version 3.0; acl "rights"; allow (fly) userdn="ldap:///anyone";)`,
+		`(version 3.0; acl "subject"; allow (read) userdn="cn=admin";)`,
+		`(target!="ldap:///dc=example,dc=org")(version 3.0; acl "op"; allow (read) userdn="ldap:///anyone";)`,
+		`(targetfilter="(cn=")(version 3.0; acl "filter"; allow (read) userdn="ldap:///anyone";)`,
+		`(version 3.0; acl "ip"; allow (read) ip="192.0.2.0/33";)`,
+		`(version 3.0; acl "trailing"; allow (read) userdn="ldap:///anyone";) extra`,
+	}
+	for _, value := range invalid {
+		if _, err := ParseACI(holder, value); err != ErrInvalidACI {
+			t.Errorf("For %q expected ErrInvalidACI, got %v", value, err)
+		}
+	}
+}
+
+func TestAccessControlsAllows(t *testing.T) {
+	schema := newTestSchema()
+	holder := mustParseDN(t, "cn=Users,dc=example,dc=org")
+	acis := AccessControls{}
+	for _, value := range []string{
+		`(targetattr!="userPassword")(version 3.0; acl "read"; allow (read,search) userdn="ldap:///all";)`,
+		`(targetattr="userPassword")(version 3.0; acl "self"; allow (write) userdn="ldap:///self";)`,
+		`(version 3.0; acl "admins"; allow (all) groupdn="ldap:///cn=Admins,dc=example,dc=org";)`,
+		`(version 3.0; acl "network"; deny (write) ip="192.0.2.0/24";)`,
+	} {
+		aci, err := ParseACI(holder, value)
+		if err != nil {
+			t.Fatal("ParseACI failed:", value, err)
+		}
+		acis = append(acis, aci)
+	}
+
+	dn := mustParseDN(t, "cn=Test User,cn=Users,dc=example,dc=org")
+	entry := &Entry{DN: dn.String(), Classes: StringSlice{PersonClass}}
+	cn, userPassword := schema.AttributeType(CommonNameAttribute), schema.AttributeType(UserPasswordAttribute)
+	self := &Requester{DN: dn, Address: net.ParseIP("198.51.100.1")}
+	other := &Requester{DN: mustParseDN(t, "cn=Test User2,cn=Users,dc=example,dc=org")}
+	admin := &Requester{DN: other.DN, IsMember: func(group DN) bool { return group.String() == "cn=Admins,dc=example,dc=org" }}
+
+	tests := []struct {
+		requester     *Requester
+		dn            DN
+		attributeType *AttributeType
+		right         AccessRight
+		expected      bool
+	}{
+		{self, dn, cn, ReadRight, true},
+		{&Requester{}, dn, cn, ReadRight, false},
+		{other, dn, userPassword, ReadRight, false},
+		{self, dn, userPassword, WriteRight, true},
+		{other, dn, userPassword, WriteRight, false},
+		{&Requester{DN: dn, Address: net.ParseIP("192.0.2.7")}, dn, userPassword, WriteRight, false},
+		{other, dn, nil, DeleteRight, false},
+		{admin, dn, nil, DeleteRight, true},
+		// outside the subtree of the holder
+		{admin, mustParseDN(t, "dc=example,dc=org"), nil, DeleteRight, false},
+	}
+	for _, test := range tests {
+		if allowed := acis.Allows(schema, test.requester, test.dn, entry, test.attributeType, test.right); allowed != test.expected {
+			t.Errorf("For %v %v on %v of %v expected %v", test.requester.DN, test.right, test.attributeType, test.dn, test.expected)
+		}
+	}
+}
@@ -81,6 +81,11 @@ func (attributeType *AttributeType) FlagsString() string {
 	return result
 }
 
+// IsOperational returns true for attribute types not governed by object classes
+func (attributeType *AttributeType) IsOperational() bool {
+	return attributeType.Usage != AUNone && attributeType.Usage != AUUserApplications
+}
+
 func (attributeType *AttributeType) SuperString() string {
 	return getPrefixedString("SUP", attributeType.Super)
 }
@@ -219,6 +224,28 @@ const (
 	OrgStatusAttributeID          = "0.9.2342.19200300.100.1.45"
 	BuildingNameAttributeID       = "0.9.2342.19200300.100.1.48"
 	DocumentPublisherAttributeID  = "0.9.2342.19200300.100.1.56"
+	// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11
+	PwdAttributeAttributeID            = "1.3.6.1.4.1.42.2.27.8.1.1"
+	PwdMaxAgeAttributeID               = "1.3.6.1.4.1.42.2.27.8.1.3"
+	PwdInHistoryAttributeID            = "1.3.6.1.4.1.42.2.27.8.1.4"
+	PwdCheckQualityAttributeID         = "1.3.6.1.4.1.42.2.27.8.1.5"
+	PwdMinLengthAttributeID            = "1.3.6.1.4.1.42.2.27.8.1.6"
+	PwdExpireWarningAttributeID        = "1.3.6.1.4.1.42.2.27.8.1.7"
+	PwdGraceAuthNLimitAttributeID      = "1.3.6.1.4.1.42.2.27.8.1.8"
+	PwdLockoutAttributeID              = "1.3.6.1.4.1.42.2.27.8.1.9"
+	PwdLockoutDurationAttributeID      = "1.3.6.1.4.1.42.2.27.8.1.10"
+	PwdMaxFailureAttributeID           = "1.3.6.1.4.1.42.2.27.8.1.11"
+	PwdFailureCountIntervalAttributeID = "1.3.6.1.4.1.42.2.27.8.1.12"
+	PwdChangedTimeAttributeID          = "1.3.6.1.4.1.42.2.27.8.1.16"
+	PwdAccountLockedTimeAttributeID    = "1.3.6.1.4.1.42.2.27.8.1.17"
+	PwdFailureTimeAttributeID          = "1.3.6.1.4.1.42.2.27.8.1.19"
+	PwdHistoryAttributeID              = "1.3.6.1.4.1.42.2.27.8.1.20"
+	PwdGraceUseTimeAttributeID         = "1.3.6.1.4.1.42.2.27.8.1.21"
+	PwdPolicySubentryAttributeID       = "1.3.6.1.4.1.42.2.27.8.1.23"
+	// 389 Directory Server access control instructions
+	ACIAttributeID = "2.16.840.1.113730.3.1.55"
+	// https://tools.ietf.org/html/rfc3112
+	AuthPasswordAttributeID = "1.3.6.1.4.1.4203.1.3.4"
 
 	// names
 	// https://tools.ietf.org/html/rfc4512
@@ -314,6 +341,28 @@ const (
 	SecretaryAttribute          = "secretary"
 	UniqueIdentifierAttribute   = "uniqueIdentifier"
 	UserClassAttribute          = "userClass"
+	// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11
+	PwdAttributeAttribute            = "pwdAttribute"
+	PwdMaxAgeAttribute               = "pwdMaxAge"
+	PwdInHistoryAttribute            = "pwdInHistory"
+	PwdCheckQualityAttribute         = "pwdCheckQuality"
+	PwdMinLengthAttribute            = "pwdMinLength"
+	PwdExpireWarningAttribute        = "pwdExpireWarning"
+	PwdGraceAuthNLimitAttribute      = "pwdGraceAuthNLimit"
+	PwdLockoutAttribute              = "pwdLockout"
+	PwdLockoutDurationAttribute      = "pwdLockoutDuration"
+	PwdMaxFailureAttribute           = "pwdMaxFailure"
+	PwdFailureCountIntervalAttribute = "pwdFailureCountInterval"
+	PwdChangedTimeAttribute          = "pwdChangedTime"
+	PwdAccountLockedTimeAttribute    = "pwdAccountLockedTime"
+	PwdFailureTimeAttribute          = "pwdFailureTime"
+	PwdHistoryAttribute              = "pwdHistory"
+	PwdGraceUseTimeAttribute         = "pwdGraceUseTime"
+	PwdPolicySubentryAttribute       = "pwdPolicySubentry"
+	// 389 Directory Server access control instructions
+	ACIAttribute = "aci"
+	// https://tools.ietf.org/html/rfc3112
+	AuthPasswordAttribute = "authPassword"
 )
 
 // LDAPv3AttributeTypes represents the standard Attribute Types
@@ -504,24 +553,27 @@ var LDAPv3AttributeTypes = [...]AttributeType{
 		Names: StringSlice{"commonName"},
 	},
 	AttributeType{
-		OID:         DomainComponentAttributeID,
-		Syntax:      sql.NullString{String: IA5StringSyntaxID, Valid: true},
-		Name:        DomainComponentAttribute,
-		Names:       StringSlice{"domainComponent"},
-		SubstrMatch: sql.NullString{String: CaseIgnoreIA5SubstrMatchRule, Valid: true},
-		Flags:       ATSingleValue,
+		OID:           DomainComponentAttributeID,
+		Syntax:        sql.NullString{String: IA5StringSyntaxID, Valid: true},
+		Name:          DomainComponentAttribute,
+		Names:         StringSlice{"domainComponent"},
+		EqualityMatch: sql.NullString{String: CaseIgnoreIA5MatchRule, Valid: true},
+		SubstrMatch:   sql.NullString{String: CaseIgnoreIA5SubstrMatchRule, Valid: true},
+		Flags:         ATSingleValue,
 	},
 	AttributeType{
-		OID:         DescriptionAttributeID,
-		Syntax:      sql.NullString{String: DirectoryStringSyntaxID, Valid: true},
-		Name:        DescriptionAttribute,
-		SubstrMatch: sql.NullString{String: CaseIgnoreSubstrMatchRule, Valid: true},
+		OID:           DescriptionAttributeID,
+		Syntax:        sql.NullString{String: DirectoryStringSyntaxID, Valid: true},
+		Name:          DescriptionAttribute,
+		EqualityMatch: sql.NullString{String: CaseIgnoreMatchRule, Valid: true},
+		SubstrMatch:   sql.NullString{String: CaseIgnoreSubstrMatchRule, Valid: true},
 	},
 	AttributeType{
-		OID:         DestinationIndicatorAttributeID,
-		Syntax:      sql.NullString{String: PrintableStringSyntaxID, Valid: true},
-		Name:        DestinationIndicatorAttribute,
-		SubstrMatch: sql.NullString{String: CaseIgnoreSubstrMatchRule, Valid: true},
+		OID:           DestinationIndicatorAttributeID,
+		Syntax:        sql.NullString{String: PrintableStringSyntaxID, Valid: true},
+		Name:          DestinationIndicatorAttribute,
+		EqualityMatch: sql.NullString{String: CaseIgnoreMatchRule, Valid: true},
+		SubstrMatch:   sql.NullString{String: CaseIgnoreSubstrMatchRule, Valid: true},
 	},
 	AttributeType{
 		OID:           DistinguishedNameAttributeID,
@@ -914,4 +966,161 @@ var LDAPv3AttributeTypes = [...]AttributeType{
 		EqualityMatch: sql.NullString{String: CaseIgnoreMatchRule, Valid: true},
 		SubstrMatch:   sql.NullString{String: CaseIgnoreSubstrMatchRule, Valid: true},
 	},
+	// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11
+	AttributeType{
+		OID:           PwdAttributeAttributeID,
+		Syntax:        sql.NullString{String: OIDSyntaxID, Valid: true},
+		Name:          PwdAttributeAttribute,
+		EqualityMatch: sql.NullString{String: ObjectIdentifierMatchRule, Valid: true},
+	},
+	AttributeType{
+		OID:           PwdMaxAgeAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdMaxAgeAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdInHistoryAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdInHistoryAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdCheckQualityAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdCheckQualityAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdMinLengthAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdMinLengthAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdExpireWarningAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdExpireWarningAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdGraceAuthNLimitAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdGraceAuthNLimitAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdLockoutAttributeID,
+		Syntax:        sql.NullString{String: BooleanSyntaxID, Valid: true},
+		Name:          PwdLockoutAttribute,
+		EqualityMatch: sql.NullString{String: BooleanMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdLockoutDurationAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdLockoutDurationAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdMaxFailureAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdMaxFailureAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdFailureCountIntervalAttributeID,
+		Syntax:        sql.NullString{String: IntegerSyntaxID, Valid: true},
+		Name:          PwdFailureCountIntervalAttribute,
+		EqualityMatch: sql.NullString{String: IntegerMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: IntegerOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+	},
+	AttributeType{
+		OID:           PwdChangedTimeAttributeID,
+		Syntax:        sql.NullString{String: GeneralizedTimeSyntaxID, Valid: true},
+		Name:          PwdChangedTimeAttribute,
+		EqualityMatch: sql.NullString{String: GeneralizedTimeMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: GeneralizedTimeOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue | ATNoUserMods,
+		Usage:         AUDirectoryOperation,
+	},
+	AttributeType{
+		OID:           PwdAccountLockedTimeAttributeID,
+		Syntax:        sql.NullString{String: GeneralizedTimeSyntaxID, Valid: true},
+		Name:          PwdAccountLockedTimeAttribute,
+		EqualityMatch: sql.NullString{String: GeneralizedTimeMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: GeneralizedTimeOrderingMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+		Usage:         AUDirectoryOperation,
+	},
+	AttributeType{
+		OID:           PwdFailureTimeAttributeID,
+		Syntax:        sql.NullString{String: GeneralizedTimeSyntaxID, Valid: true},
+		Name:          PwdFailureTimeAttribute,
+		EqualityMatch: sql.NullString{String: GeneralizedTimeMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: GeneralizedTimeOrderingMatchRule, Valid: true},
+		Flags:         ATNoUserMods,
+		Usage:         AUDirectoryOperation,
+	},
+	AttributeType{
+		OID:           PwdHistoryAttributeID,
+		Syntax:        sql.NullString{String: OctetStringSyntaxID, Valid: true},
+		Name:          PwdHistoryAttribute,
+		EqualityMatch: sql.NullString{String: OctetStringMatchRule, Valid: true},
+		Flags:         ATNoUserMods,
+		Usage:         AUDirectoryOperation,
+	},
+	AttributeType{
+		OID:           PwdGraceUseTimeAttributeID,
+		Syntax:        sql.NullString{String: GeneralizedTimeSyntaxID, Valid: true},
+		Name:          PwdGraceUseTimeAttribute,
+		EqualityMatch: sql.NullString{String: GeneralizedTimeMatchRule, Valid: true},
+		OrderingMatch: sql.NullString{String: GeneralizedTimeOrderingMatchRule, Valid: true},
+		Flags:         ATNoUserMods,
+		Usage:         AUDirectoryOperation,
+	},
+	AttributeType{
+		OID:           PwdPolicySubentryAttributeID,
+		Syntax:        sql.NullString{String: DistinguishedNameSyntaxID, Valid: true},
+		Name:          PwdPolicySubentryAttribute,
+		EqualityMatch: sql.NullString{String: DistinguishedNameMatchRule, Valid: true},
+		Flags:         ATSingleValue,
+		Usage:         AUDirectoryOperation,
+	},
+	// 389 Directory Server access control instructions
+	AttributeType{
+		OID:           ACIAttributeID,
+		Syntax:        sql.NullString{String: DirectoryStringSyntaxID, Valid: true},
+		Name:          ACIAttribute,
+		EqualityMatch: sql.NullString{String: CaseExactMatchRule, Valid: true},
+		Flags:         ATNone,
+		Usage:         AUDirectoryOperation,
+	},
+	// https://tools.ietf.org/html/rfc3112 holding the SCRAM keys of userPassword (RFC 5803),
+	// maintained by the server rather than clients
+	AttributeType{
+		OID:           AuthPasswordAttributeID,
+		Syntax:        sql.NullString{String: OctetStringSyntaxID, Valid: true},
+		Name:          AuthPasswordAttribute,
+		EqualityMatch: sql.NullString{String: OctetStringMatchRule, Valid: true},
+		Flags:         ATNoUserMods,
+		Usage:         AUDirectoryOperation,
+	},
 }
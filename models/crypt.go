@@ -0,0 +1,258 @@
+package models
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// cryptAlphabet is the base64 alphabet of crypt(3)
+	cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// sha-crypt salts are truncated to 16 characters
+	shaCryptMaxSalt = 16
+	// rounds of sha-crypt when unspecified & bounds of the specified rounds
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	// md5-crypt salts are truncated to 8 characters
+	md5CryptMaxSalt = 8
+)
+
+// byte orders of the encoded digests
+var (
+	sha256CryptOrder = [][]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29}, {31, 30},
+	}
+	sha512CryptOrder = [][]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26},
+		{6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32},
+		{12, 33, 54}, {34, 55, 13}, {56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38},
+		{18, 39, 60}, {40, 61, 19}, {62, 20, 41}, {63},
+	}
+	md5CryptOrder = [][]int{
+		{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}, {11},
+	}
+)
+
+// verifyCrypt returns true if encoded is the crypt(3) hash of password
+// the MD5 ($1$), SHA-256 ($5$), SHA-512 ($6$) & bcrypt ($2a$, $2b$, $2y$) methods are supported
+func verifyCrypt(encoded string, password string) bool {
+	var computed string
+	switch {
+	case strings.HasPrefix(encoded, "$1$"):
+		computed = md5Crypt(password, encoded[len("$1$"):])
+	case strings.HasPrefix(encoded, "$5$"):
+		computed = shaCrypt("$5$", sha256.New, sha256CryptOrder, password, encoded[len("$5$"):])
+	case strings.HasPrefix(encoded, "$6$"):
+		computed = shaCrypt("$6$", sha512.New, sha512CryptOrder, password, encoded[len("$6$"):])
+	case strings.HasPrefix(encoded, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(encoded)) == 1
+}
+
+// hashCrypt returns the SHA-512 crypt(3) hash of password
+func hashCrypt(password string) (string, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return "", err
+	}
+	return shaCrypt("$6$", sha512.New, sha512CryptOrder, password, encodeCrypt(salt, nil)), nil
+}
+
+// shaCrypt returns the sha-crypt hash of password, setting is the salt optionally preceded by
+// "rounds=<N>$" & followed by "$" and the hash to verify
+// https://www.akkadia.org/drepper/SHA-crypt.txt
+func shaCrypt(magic string, newHash func() hash.Hash, order [][]int, password string, setting string) string {
+	rounds, customRounds := shaCryptDefaultRounds, false
+	if strings.HasPrefix(setting, "rounds=") {
+		end := strings.IndexByte(setting, '$')
+		if end < 0 {
+			return ""
+		}
+		n, err := strconv.Atoi(setting[len("rounds="):end])
+		if err != nil {
+			return ""
+		}
+		rounds, customRounds, setting = n, true, setting[end+1:]
+		if rounds < shaCryptMinRounds {
+			rounds = shaCryptMinRounds
+		} else if rounds > shaCryptMaxRounds {
+			rounds = shaCryptMaxRounds
+		}
+	}
+	salt := setting
+	if end := strings.IndexByte(salt, '$'); end >= 0 {
+		salt = salt[:end]
+	}
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+	p, s := []byte(password), []byte(salt)
+
+	b := newHash()
+	b.Write(p)
+	b.Write(s)
+	b.Write(p)
+	digestB := b.Sum(nil)
+
+	a := newHash()
+	a.Write(p)
+	a.Write(s)
+	a.Write(repeatBytes(digestB, len(p)))
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(p)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	dp := newHash()
+	for range p {
+		dp.Write(p)
+	}
+	pSequence := repeatBytes(dp.Sum(nil), len(p))
+
+	ds := newHash()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(s)
+	}
+	sSequence := repeatBytes(ds.Sum(nil), len(s))
+
+	digest := digestA
+	for i := 0; i < rounds; i++ {
+		c := newHash()
+		if i&1 != 0 {
+			c.Write(pSequence)
+		} else {
+			c.Write(digest)
+		}
+		if i%3 != 0 {
+			c.Write(sSequence)
+		}
+		if i%7 != 0 {
+			c.Write(pSequence)
+		}
+		if i&1 != 0 {
+			c.Write(digest)
+		} else {
+			c.Write(pSequence)
+		}
+		digest = c.Sum(nil)
+	}
+
+	prefix := magic
+	if customRounds {
+		prefix += fmt.Sprintf("rounds=%d$", rounds)
+	}
+	return prefix + salt + "$" + encodeCrypt(digest, order)
+}
+
+// md5Crypt returns the md5-crypt hash of password, setting is the salt optionally followed by
+// "$" and the hash to verify
+func md5Crypt(password string, setting string) string {
+	salt := setting
+	if end := strings.IndexByte(salt, '$'); end >= 0 {
+		salt = salt[:end]
+	}
+	if len(salt) > md5CryptMaxSalt {
+		salt = salt[:md5CryptMaxSalt]
+	}
+	p, s := []byte(password), []byte(salt)
+
+	alternate := md5.New()
+	alternate.Write(p)
+	alternate.Write(s)
+	alternate.Write(p)
+	digestAlternate := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(p)
+	ctx.Write([]byte("$1$"))
+	ctx.Write(s)
+	ctx.Write(repeatBytes(digestAlternate, len(p)))
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(p[:1])
+		}
+	}
+	digest := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		c := md5.New()
+		if i&1 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(digest)
+		}
+		if i%3 != 0 {
+			c.Write(s)
+		}
+		if i%7 != 0 {
+			c.Write(p)
+		}
+		if i&1 != 0 {
+			c.Write(digest)
+		} else {
+			c.Write(p)
+		}
+		digest = c.Sum(nil)
+	}
+	return "$1$" + salt + "$" + encodeCrypt(digest, md5CryptOrder)
+}
+
+// repeatBytes returns the first length bytes of block repeated
+func repeatBytes(block []byte, length int) []byte {
+	result := make([]byte, 0, length)
+	for len(result) < length {
+		n := length - len(result)
+		if n > len(block) {
+			n = len(block)
+		}
+		result = append(result, block[:n]...)
+	}
+	return result
+}
+
+// encodeCrypt encodes the bytes of digest in the crypt(3) base64 alphabet, grouped by order
+// each group of 3 bytes is encoded as 4 characters, shorter groups (the last) as 1 character
+// more than their number of bytes, a nil order encodes digest in sequence
+func encodeCrypt(digest []byte, order [][]int) string {
+	if order == nil {
+		for i := 0; i < len(digest); i += 3 {
+			group := []int{}
+			for j := i; j < i+3 && j < len(digest); j++ {
+				group = append(group, j)
+			}
+			order = append(order, group)
+		}
+	}
+
+	buffer := strings.Builder{}
+	for _, group := range order {
+		value := 0
+		for _, index := range group {
+			value = value<<8 | int(digest[index])
+		}
+		for i := 0; i <= len(group); i++ {
+			buffer.WriteByte(cryptAlphabet[value&0x3f])
+			value >>= 6
+		}
+	}
+	return buffer.String()
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidDN is returned when a string cannot be parsed as a DN
+var ErrInvalidDN = errors.New("Invalid DN syntax")
+
+// pathTypes maps the lowercased names & OIDs of the standard attribute types to their
+// lowercased primary name, so that paths do not depend on how types are designated
+var pathTypes = map[string]string{}
+
+func init() {
+	for _, attributeType := range LDAPv3AttributeTypes {
+		name := strings.ToLower(attributeType.Name)
+		pathTypes[name] = name
+		pathTypes[attributeType.OID] = name
+		for _, alias := range attributeType.Names {
+			pathTypes[strings.ToLower(alias)] = name
+		}
+	}
+}
+
+// AttributeTypeAndValue represents a single attribute assertion within an RDN
+type AttributeTypeAndValue struct {
+	Type  string
+	Value string
+}
+
+// RDN represents a relative distinguished name (one or more assertions joined by "+")
+type RDN []AttributeTypeAndValue
+
+// DN represents a distinguished name, ordered from the leaf RDN to the root RDN
+// https://tools.ietf.org/html/rfc4514
+type DN []RDN
+
+// ParseDN parses the string representation of a DN
+func ParseDN(dn string) (DN, error) {
+	result := DN{}
+	if strings.TrimSpace(dn) == "" {
+		return result, nil
+	}
+
+	rdn := RDN{}
+	atav := AttributeTypeAndValue{}
+	buffer := []byte{}
+	inValue := false
+	escaped := false
+
+	finishValue := func() {
+		atav.Value = strings.TrimRight(string(buffer), " ")
+		rdn = append(rdn, atav)
+		atav = AttributeTypeAndValue{}
+		buffer = buffer[:0]
+		inValue = false
+	}
+
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case escaped:
+			if isHexDigit(c) && i+1 < len(dn) && isHexDigit(dn[i+1]) {
+				decoded, _ := hex.DecodeString(dn[i : i+2])
+				buffer = append(buffer, decoded...)
+				i++
+			} else {
+				buffer = append(buffer, c)
+			}
+			escaped = false
+		case !inValue && c == '=':
+			atav.Type = strings.TrimSpace(string(buffer))
+			if atav.Type == "" {
+				return nil, ErrInvalidDN
+			}
+			buffer = buffer[:0]
+			inValue = true
+			// skip leading spaces in the value
+			for i+1 < len(dn) && dn[i+1] == ' ' {
+				i++
+			}
+		case !inValue:
+			if c == ',' || c == '+' || c == ';' {
+				return nil, ErrInvalidDN
+			}
+			buffer = append(buffer, c)
+		case c == '\\':
+			escaped = true
+		case c == '+':
+			finishValue()
+		case c == ',' || c == ';':
+			finishValue()
+			result = append(result, rdn)
+			rdn = RDN{}
+		default:
+			buffer = append(buffer, c)
+		}
+	}
+
+	if escaped || !inValue {
+		return nil, ErrInvalidDN
+	}
+	finishValue()
+	result = append(result, rdn)
+
+	return result, nil
+}
+
+// String returns the string representation of dn
+func (dn DN) String() string {
+	rdns := make([]string, len(dn))
+	for i, rdn := range dn {
+		rdns[i] = rdn.String()
+	}
+	return strings.Join(rdns, ",")
+}
+
+// Parent returns the DN of the immediate superior of dn
+func (dn DN) Parent() DN {
+	if len(dn) == 0 {
+		return dn
+	}
+	return dn[1:]
+}
+
+// RDN returns the leaf RDN of dn
+func (dn DN) RDN() RDN {
+	if len(dn) == 0 {
+		return nil
+	}
+	return dn[0]
+}
+
+// IsEmpty returns true for the zero-length (root DSE) DN
+func (dn DN) IsEmpty() bool {
+	return len(dn) == 0
+}
+
+// Equal returns true if dn & other name the same entry
+func (dn DN) Equal(other DN) bool {
+	return dn.Path() == other.Path()
+}
+
+// Path returns the hierarchical key of dn: its normalized RDNs from the root down, each
+// terminated by a comma, so that the path of every subordinate of dn starts with its path
+// types and values are compared case-insensitively and insignificant spaces are removed,
+// standard attribute types designated by an alias or OID are replaced by their name
+func (dn DN) Path() string {
+	buffer := bytes.Buffer{}
+	for i := len(dn) - 1; i >= 0; i-- {
+		atavs := make([]string, len(dn[i]))
+		for j, atav := range dn[i] {
+			value := strings.ToLower(strings.Join(strings.Fields(atav.Value), " "))
+			atavs[j] = pathType(atav.Type) + "=" + escapeDNValue(value)
+		}
+		sort.Strings(atavs)
+		buffer.WriteString(strings.Join(atavs, "+"))
+		buffer.WriteByte(',')
+	}
+	return buffer.String()
+}
+
+// pathType returns the normalized name of attributeType within paths
+func pathType(attributeType string) string {
+	attributeType = strings.ToLower(attributeType)
+	if name, found := pathTypes[attributeType]; found {
+		return name
+	}
+	return attributeType
+}
+
+// String returns the string representation of rdn
+func (rdn RDN) String() string {
+	atavs := make([]string, len(rdn))
+	for i, atav := range rdn {
+		atavs[i] = fmt.Sprintf("%s=%s", atav.Type, escapeDNValue(atav.Value))
+	}
+	return strings.Join(atavs, "+")
+}
+
+// escapeDNValue escapes the characters reserved by RFC 4514 section 2.4
+func escapeDNValue(value string) string {
+	buffer := make([]byte, 0, len(value))
+	last := len(value) - 1
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			c == ' ' && (i == 0 || i == last),
+			c == '#' && i == 0:
+			buffer = append(buffer, '\\', c)
+		case c == 0:
+			buffer = append(buffer, `\00`...)
+		default:
+			buffer = append(buffer, c)
+		}
+	}
+	return string(buffer)
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
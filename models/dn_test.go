@@ -0,0 +1,89 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+type dnTest struct {
+	dn       string
+	expected string
+	parent   string
+}
+
+var validDNs = []dnTest{
+	{"dc=example,dc=org", "dc=example,dc=org", "dc=org"},
+	{"cn=Test User, cn=Users, dc=example,dc=org", "cn=Test User,cn=Users,dc=example,dc=org", "cn=Users,dc=example,dc=org"},
+	{`cn=Doe\, John,dc=org`, `cn=Doe\, John,dc=org`, "dc=org"},
+	{`cn=a\2Cb,dc=org`, `cn=a\,b,dc=org`, "dc=org"},
+	{"cn=a+sn=b,dc=org", "cn=a+sn=b,dc=org", "dc=org"},
+	{"", "", ""},
+}
+
+var invalidDNs = []string{
+	"dc",
+	"=example",
+	"dc=example,",
+	`cn=trailing\`,
+}
+
+func TestParseDN(t *testing.T) {
+	for _, test := range validDNs {
+		dn, err := ParseDN(test.dn)
+		if err != nil {
+			t.Error("For", test.dn, "ParseDN failed:", err)
+			continue
+		}
+		if actual := dn.String(); actual != test.expected {
+			t.Error("For", test.dn, "expected", test.expected, "got", actual)
+		}
+		if actual := dn.Parent().String(); actual != test.parent {
+			t.Error("For", test.dn, "expected parent", test.parent, "got", actual)
+		}
+	}
+}
+
+func TestParseInvalidDN(t *testing.T) {
+	for _, dn := range invalidDNs {
+		if _, err := ParseDN(dn); err == nil {
+			t.Error("For", dn, "ParseDN did not fail")
+		}
+	}
+}
+
+func TestDNPath(t *testing.T) {
+	base, _ := ParseDN("dc=example,dc=org")
+	child, _ := ParseDN("CN=Test  User,cn=Users,DC=Example,dc=org")
+	sibling, _ := ParseDN("dc=example,dc=organic")
+	comma, _ := ParseDN(`dc=example\,x,dc=org`)
+
+	if base.Path() != "dc=org,dc=example," {
+		t.Error("Unexpected path", base.Path())
+	}
+	if child.Path() != "dc=org,dc=example,cn=users,cn=test user," {
+		t.Error("Unexpected path", child.Path())
+	}
+	// aliases & OIDs designate the same attribute types
+	alias, _ := ParseDN("commonName=Test User,2.5.4.3=Users,domainComponent=example,dc=org")
+	if alias.Path() != child.Path() {
+		t.Error("Unexpected path", alias.Path())
+	}
+	for _, dn := range []DN{sibling, comma} {
+		if strings.HasPrefix(dn.Path(), base.Path()) {
+			t.Error("Path", dn.Path(), "is not beneath", base.Path())
+		}
+	}
+}
+
+func TestDNEqual(t *testing.T) {
+	dn, _ := ParseDN("cn=Test User,cn=Users,dc=example,dc=org")
+	same, _ := ParseDN("CN=test  user, cn=users,DC=Example,dc=org")
+	parent, _ := ParseDN("cn=Users,dc=example,dc=org")
+
+	if !dn.Equal(same) {
+		t.Error(dn, "should equal", same)
+	}
+	if dn.Equal(parent) {
+		t.Error(dn, "should not equal", parent)
+	}
+}
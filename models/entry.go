@@ -2,8 +2,13 @@ package models
 
 import (
 	"database/sql"
+	"time"
 )
 
+// GeneralizedTimeFormat is the layout used for GeneralizedTime values
+// https://tools.ietf.org/html/rfc4517#section-3.3.13
+const GeneralizedTimeFormat = "20060102150405Z"
+
 // Entry model in the DB
 type Entry struct {
 	DN         string
@@ -13,3 +18,43 @@ type Entry struct {
 	UserValues AttributeValues
 	OperValues AttributeValues
 }
+
+// GeneralizedTime formats t as a GeneralizedTime value
+func GeneralizedTime(t time.Time) string {
+	return t.UTC().Format(GeneralizedTimeFormat)
+}
+
+// Values returns the values of attributeType held by entry
+func (entry *Entry) Values(attributeType *AttributeType) []string {
+	switch {
+	case attributeType.Name == ObjectClassAttribute:
+		// we don't store "top" in the DB - every entry has it
+		return append([]string{TopClass}, entry.Classes...)
+	case attributeType.IsOperational():
+		return entry.OperValues[attributeType.Name]
+	default:
+		return entry.UserValues[attributeType.Name]
+	}
+}
+
+// SetValues replaces the values of attributeType held by entry
+// an empty values removes the attribute
+func (entry *Entry) SetValues(attributeType *AttributeType, values []string) {
+	if attributeType.Name == ObjectClassAttribute {
+		entry.Classes = values
+		return
+	}
+
+	attributes := &entry.UserValues
+	if attributeType.IsOperational() {
+		attributes = &entry.OperValues
+	}
+	if *attributes == nil {
+		*attributes = AttributeValues{}
+	}
+	if len(values) == 0 {
+		delete(*attributes, attributeType.Name)
+	} else {
+		(*attributes)[attributeType.Name] = values
+	}
+}
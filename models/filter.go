@@ -0,0 +1,440 @@
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidFilter is returned when a string cannot be parsed as a filter
+var ErrInvalidFilter = errors.New("Invalid filter syntax")
+
+// FilterType identifies the kind of an LDAP filter
+// values match the context-specific tags of the Filter CHOICE in RFC 4511
+type FilterType int
+
+const (
+	FilterAnd FilterType = iota
+	FilterOr
+	FilterNot
+	FilterEqualityMatch
+	FilterSubstrings
+	FilterGreaterOrEqual
+	FilterLessOrEqual
+	FilterPresent
+	FilterApproxMatch
+	FilterExtensibleMatch
+)
+
+// FilterResult is the three-valued result of evaluating a filter
+// https://tools.ietf.org/html/rfc4511#section-4.5.1.7
+type FilterResult int
+
+const (
+	FilterUndefined FilterResult = iota
+	FilterFalse
+	FilterTrue
+)
+
+// Filter represents an LDAP search filter
+// https://tools.ietf.org/html/rfc4511#section-4.5.1.7
+type Filter struct {
+	Type FilterType
+	// Children holds the operands of and, or and not filters
+	Children []*Filter
+	// Attribute is the attribute description of an assertion
+	Attribute string
+	// Value is the assertion value
+	Value string
+	// Initial, Any and Final hold the substrings of a substrings filter
+	Initial string
+	Any     []string
+	Final   string
+	// MatchingRule and DNAttributes are used by extensible match filters
+	MatchingRule string
+	DNAttributes bool
+}
+
+// Matches returns true if filter evaluates to TRUE for entry
+func (filter *Filter) Matches(schema *Schema, entry *Entry) bool {
+	return filter.Evaluate(schema, entry) == FilterTrue
+}
+
+// Evaluate evaluates filter against entry using the matching rules in schema
+func (filter *Filter) Evaluate(schema *Schema, entry *Entry) FilterResult {
+	switch filter.Type {
+	case FilterAnd:
+		result := FilterTrue
+		for _, child := range filter.Children {
+			switch child.Evaluate(schema, entry) {
+			case FilterFalse:
+				return FilterFalse
+			case FilterUndefined:
+				result = FilterUndefined
+			}
+		}
+		return result
+
+	case FilterOr:
+		result := FilterFalse
+		for _, child := range filter.Children {
+			switch child.Evaluate(schema, entry) {
+			case FilterTrue:
+				return FilterTrue
+			case FilterUndefined:
+				result = FilterUndefined
+			}
+		}
+		return result
+
+	case FilterNot:
+		if len(filter.Children) != 1 {
+			return FilterUndefined
+		}
+		switch filter.Children[0].Evaluate(schema, entry) {
+		case FilterTrue:
+			return FilterFalse
+		case FilterFalse:
+			return FilterTrue
+		}
+		return FilterUndefined
+
+	case FilterPresent:
+		attributeType := schema.AttributeType(filter.Attribute)
+		if attributeType == nil {
+			return FilterFalse
+		}
+		return filterResult(len(schema.EntryValues(entry, attributeType)) > 0)
+
+	case FilterExtensibleMatch:
+		return filter.evaluateExtensible(schema, entry)
+	}
+
+	attributeType := schema.AttributeType(filter.Attribute)
+	if attributeType == nil {
+		return FilterUndefined
+	}
+
+	for _, value := range schema.EntryValues(entry, attributeType) {
+		var match bool
+		var err error
+		switch filter.Type {
+		case FilterEqualityMatch:
+			match, err = schema.ValuesMatch(attributeType, value, filter.Value)
+		case FilterApproxMatch:
+			match, err = schema.ValuesApproximatelyMatch(attributeType, value, filter.Value)
+		case FilterSubstrings:
+			match, err = schema.SubstringsMatch(attributeType, value, filter.Initial, filter.Any, filter.Final)
+		case FilterGreaterOrEqual, FilterLessOrEqual:
+			var order int
+			order, err = schema.CompareValues(attributeType, value, filter.Value)
+			match = (filter.Type == FilterGreaterOrEqual && order >= 0) ||
+				(filter.Type == FilterLessOrEqual && order <= 0)
+		default:
+			return FilterUndefined
+		}
+		if err != nil {
+			return FilterUndefined
+		}
+		if match {
+			return FilterTrue
+		}
+	}
+	return FilterFalse
+}
+
+// evaluateExtensible evaluates an extensible match filter, testing the values of the
+// filter attribute (or all attributes) and optionally the attributes of the entry's DN
+func (filter *Filter) evaluateExtensible(schema *Schema, entry *Entry) FilterResult {
+	var normalize normalizer
+	if filter.MatchingRule != "" {
+		var found bool
+		if normalize, found = normalizers[schema.matchingRuleName(filter.MatchingRule)]; !found {
+			return FilterUndefined
+		}
+	}
+
+	candidates := map[*AttributeType][]string{}
+	if filter.Attribute != "" {
+		attributeType := schema.AttributeType(filter.Attribute)
+		if attributeType == nil {
+			return FilterUndefined
+		}
+		candidates[attributeType] = schema.EntryValues(entry, attributeType)
+	} else {
+		for _, attributes := range []AttributeValues{entry.UserValues, entry.OperValues} {
+			for name, values := range attributes {
+				if attributeType := schema.AttributeType(name); attributeType != nil {
+					candidates[attributeType] = append(candidates[attributeType], values...)
+				}
+			}
+		}
+	}
+	if filter.DNAttributes {
+		if dn, err := ParseDN(entry.DN); err == nil {
+			for _, rdn := range dn {
+				for _, atav := range rdn {
+					if attributeType := schema.AttributeType(atav.Type); attributeType != nil {
+						if filter.Attribute == "" || schema.IsSubtype(attributeType, schema.AttributeType(filter.Attribute)) {
+							candidates[attributeType] = append(candidates[attributeType], atav.Value)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	result := FilterFalse
+	for attributeType, values := range candidates {
+		rule := normalize
+		if rule == nil {
+			rule = normalizers[schema.EqualityMatch(attributeType)]
+		}
+		if rule == nil {
+			result = FilterUndefined
+			continue
+		}
+		assertion, err := rule(schema, filter.Value)
+		if err != nil {
+			result = FilterUndefined
+			continue
+		}
+		for _, value := range values {
+			if normalized, err := rule(schema, value); err == nil && normalized == assertion {
+				return FilterTrue
+			}
+		}
+	}
+	return result
+}
+
+func filterResult(match bool) FilterResult {
+	if match {
+		return FilterTrue
+	}
+	return FilterFalse
+}
+
+// String returns the string representation of filter
+// https://tools.ietf.org/html/rfc4515
+func (filter *Filter) String() string {
+	buffer := bytes.NewBufferString("(")
+	switch filter.Type {
+	case FilterAnd, FilterOr, FilterNot:
+		buffer.WriteString([...]string{"&", "|", "!"}[filter.Type])
+		for _, child := range filter.Children {
+			buffer.WriteString(child.String())
+		}
+	case FilterEqualityMatch:
+		buffer.WriteString(filter.Attribute + "=" + escapeFilterValue(filter.Value))
+	case FilterApproxMatch:
+		buffer.WriteString(filter.Attribute + "~=" + escapeFilterValue(filter.Value))
+	case FilterGreaterOrEqual:
+		buffer.WriteString(filter.Attribute + ">=" + escapeFilterValue(filter.Value))
+	case FilterLessOrEqual:
+		buffer.WriteString(filter.Attribute + "<=" + escapeFilterValue(filter.Value))
+	case FilterPresent:
+		buffer.WriteString(filter.Attribute + "=*")
+	case FilterSubstrings:
+		buffer.WriteString(filter.Attribute + "=" + escapeFilterValue(filter.Initial) + "*")
+		for _, any := range filter.Any {
+			buffer.WriteString(escapeFilterValue(any) + "*")
+		}
+		buffer.WriteString(escapeFilterValue(filter.Final))
+	case FilterExtensibleMatch:
+		buffer.WriteString(filter.Attribute)
+		if filter.DNAttributes {
+			buffer.WriteString(":dn")
+		}
+		if filter.MatchingRule != "" {
+			buffer.WriteString(":" + filter.MatchingRule)
+		}
+		buffer.WriteString(":=" + escapeFilterValue(filter.Value))
+	}
+	buffer.WriteString(")")
+	return buffer.String()
+}
+
+// Attributes returns the attribute descriptions asserted by filter and its operands
+func (filter *Filter) Attributes() []string {
+	attributes := []string{}
+	if filter.Attribute != "" {
+		attributes = append(attributes, filter.Attribute)
+	}
+	for _, child := range filter.Children {
+		attributes = append(attributes, child.Attributes()...)
+	}
+	return attributes
+}
+
+// ParseFilter parses the string representation of a filter
+// https://tools.ietf.org/html/rfc4515
+func ParseFilter(filter string) (*Filter, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		// be lenient and accept a bare item such as "uid=jdoe"
+		filter = "(" + filter + ")"
+	}
+	result, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, ErrInvalidFilter
+	}
+	return result, nil
+}
+
+func parseFilter(s string) (*Filter, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", ErrInvalidFilter
+	}
+	s = s[1:]
+
+	switch s[0] {
+	case '&', '|', '!':
+		filter := &Filter{Type: FilterAnd}
+		switch s[0] {
+		case '|':
+			filter.Type = FilterOr
+		case '!':
+			filter.Type = FilterNot
+		}
+		s = s[1:]
+		for len(s) > 0 && s[0] == '(' {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			filter.Children = append(filter.Children, child)
+			s = rest
+		}
+		if len(s) == 0 || s[0] != ')' {
+			return nil, "", ErrInvalidFilter
+		}
+		if filter.Type == FilterNot && len(filter.Children) != 1 {
+			return nil, "", ErrInvalidFilter
+		}
+		return filter, s[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", ErrInvalidFilter
+	}
+	filter, err := parseFilterItem(s[:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return filter, s[end+1:], nil
+}
+
+func parseFilterItem(item string) (*Filter, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, ErrInvalidFilter
+	}
+	attribute, value := item[:eq], item[eq+1:]
+
+	filter := &Filter{Type: FilterEqualityMatch}
+	switch attribute[len(attribute)-1] {
+	case '~':
+		filter.Type = FilterApproxMatch
+	case '>':
+		filter.Type = FilterGreaterOrEqual
+	case '<':
+		filter.Type = FilterLessOrEqual
+	case ':':
+		filter.Type = FilterExtensibleMatch
+	}
+	if filter.Type != FilterEqualityMatch {
+		attribute = attribute[:len(attribute)-1]
+	}
+
+	if filter.Type == FilterExtensibleMatch {
+		parts := strings.Split(attribute, ":")
+		filter.Attribute = parts[0]
+		for _, part := range parts[1:] {
+			if strings.EqualFold(part, "dn") {
+				filter.DNAttributes = true
+			} else if part != "" {
+				filter.MatchingRule = part
+			}
+		}
+		if filter.Attribute == "" && filter.MatchingRule == "" {
+			return nil, ErrInvalidFilter
+		}
+	} else {
+		filter.Attribute = attribute
+		if attribute == "" || strings.ContainsAny(attribute, " :()") {
+			return nil, ErrInvalidFilter
+		}
+	}
+
+	if filter.Type == FilterEqualityMatch && strings.IndexByte(value, '*') >= 0 {
+		if value == "*" {
+			filter.Type = FilterPresent
+			return filter, nil
+		}
+		filter.Type = FilterSubstrings
+		substrings := strings.Split(value, "*")
+		last := len(substrings) - 1
+		for i, substring := range substrings {
+			unescaped, err := unescapeFilterValue(substring)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case i == 0:
+				filter.Initial = unescaped
+			case i == last:
+				filter.Final = unescaped
+			case unescaped != "":
+				filter.Any = append(filter.Any, unescaped)
+			}
+		}
+		return filter, nil
+	}
+
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+	filter.Value = unescaped
+	return filter, nil
+}
+
+func unescapeFilterValue(value string) (string, error) {
+	buffer := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buffer = append(buffer, value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", ErrInvalidFilter
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", ErrInvalidFilter
+		}
+		buffer = append(buffer, decoded...)
+		i += 2
+	}
+	return string(buffer), nil
+}
+
+func escapeFilterValue(value string) string {
+	buffer := bytes.Buffer{}
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			buffer.WriteString(fmt.Sprintf("\\%02x", c))
+		default:
+			buffer.WriteByte(c)
+		}
+	}
+	return buffer.String()
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"testing"
+)
+
+type filterTest struct {
+	filter   string
+	expected FilterResult
+}
+
+var filterTests = []filterTest{
+	{"(cn=test user)", FilterTrue},
+	{"(CN=Other User)", FilterFalse},
+	{"(objectClass=person)", FilterTrue},
+	{"(objectClass=top)", FilterTrue},
+	{"(objectClass=*)", FilterTrue},
+	{"(mail=*)", FilterFalse},
+	{"(name=Test User)", FilterTrue},
+	{"(sn=Us*)", FilterTrue},
+	{"(cn=*st*ser)", FilterTrue},
+	{"(sn~=Usr)", FilterTrue},
+	{"(&(objectClass=person)(sn=user))", FilterTrue},
+	{"(|(sn=other)(cn=test*))", FilterTrue},
+	{"(!(sn=user))", FilterFalse},
+	{"(undefinedAttribute=x)", FilterUndefined},
+	{"(|(undefinedAttribute=x)(sn=user))", FilterTrue},
+	{"(&(undefinedAttribute=x)(sn=other))", FilterFalse},
+	{"(!(undefinedAttribute=x))", FilterUndefined},
+	{"(createTimestamp>=20150101000000Z)", FilterTrue},
+	{"(createTimestamp<=20150101000000Z)", FilterFalse},
+	{"(cn:caseExactMatch:=Test User)", FilterTrue},
+	{"(cn:caseExactMatch:=test user)", FilterFalse},
+	{"(:dn:2.5.13.2:=users)", FilterTrue},
+}
+
+func newTestEntry() *Entry {
+	return &Entry{
+		DN:      "cn=Test User,cn=Users,dc=example,dc=org",
+		RDN:     "cn=Test User",
+		Classes: StringSlice{PersonClass},
+		UserValues: AttributeValues{
+			CommonNameAttribute: []string{"Test User"},
+			SurnameAttribute:    []string{"User"},
+		},
+		OperValues: AttributeValues{
+			CreateTimestampAttribute: []string{"20160101000000Z"},
+		},
+	}
+}
+
+func TestFilterEvaluate(t *testing.T) {
+	schema := newTestSchema()
+	entry := newTestEntry()
+	for _, test := range filterTests {
+		filter, err := ParseFilter(test.filter)
+		if err != nil {
+			t.Error("For", test, "ParseFilter failed:", err)
+			continue
+		}
+		if result := filter.Evaluate(schema, entry); result != test.expected {
+			t.Error("For", test, "got", result)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, s := range []string{"(&(cn=a\\2ab)(|(sn>=x)(!(mail=*))))", "(cn=*a*b*)", "(cn:dn:caseIgnoreMatch:=x)"} {
+		filter, err := ParseFilter(s)
+		if err != nil {
+			t.Error("For", s, "ParseFilter failed:", err)
+			continue
+		}
+		if filter.String() != s {
+			t.Error("For", s, "got", filter.String())
+		}
+	}
+	for _, s := range []string{"(cn=a", "(&(cn=a)", "(cn=\\zz)", "()"} {
+		if _, err := ParseFilter(s); err == nil {
+			t.Error("For", s, "expected error")
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoMatchingRule is returned when an attribute type has no suitable matching rule
+	ErrNoMatchingRule = errors.New("No matching rule for attribute type")
+	// ErrInvalidAssertion is returned when a value is not valid for a matching rule
+	ErrInvalidAssertion = errors.New("Invalid assertion value for matching rule")
+)
+
+// normalizer prepares a value for comparison under a matching rule
+type normalizer func(schema *Schema, value string) (string, error)
+
+// comparer orders two normalized values under an ordering matching rule
+type comparer func(a string, b string) int
+
+// equality and substrings matching rules compare normalized values
+var normalizers = map[string]normalizer{
+	ObjectIdentifierMatchRule:        normalizeOID,
+	DistinguishedNameMatchRule:       normalizeDN,
+	UniqueMemberMatchRule:            normalizeDN,
+	CaseIgnoreMatchRule:              normalizeCaseIgnore,
+	CaseIgnoreOrderingMatchRule:      normalizeCaseIgnore,
+	CaseIgnoreSubstrMatchRule:        normalizeCaseIgnore,
+	CaseIgnoreListMatchRule:          normalizeCaseIgnore,
+	CaseIgnoreListSubstrMatchRule:    normalizeCaseIgnore,
+	CaseIgnoreIA5MatchRule:           normalizeCaseIgnore,
+	CaseIgnoreIA5SubstrMatchRule:     normalizeCaseIgnore,
+	CaseExactMatchRule:               normalizeCaseExact,
+	CaseExactOrderingMatchRule:       normalizeCaseExact,
+	CaseExactSubstrMatchRule:         normalizeCaseExact,
+	CaseExactIA5MatchRule:            normalizeCaseExact,
+	NumericStringMatchRule:           normalizeNumericString,
+	NumericStringOrderingMatchRule:   normalizeNumericString,
+	NumericStringSubstrMatchRule:     normalizeNumericString,
+	TelephoneNumberMatchRule:         normalizeTelephoneNumber,
+	TelephoneNumberSubstrMatchRule:   normalizeTelephoneNumber,
+	BooleanMatchRule:                 normalizeBoolean,
+	IntegerMatchRule:                 normalizeInteger,
+	IntegerOrderingMatchRule:         normalizeInteger,
+	BitStringMatchRule:               normalizeExact,
+	OctetStringMatchRule:             normalizeExact,
+	OctetStringOrderingMatchRule:     normalizeExact,
+	GeneralizedTimeMatchRule:         normalizeGeneralizedTime,
+	GeneralizedTimeOrderingMatchRule: normalizeGeneralizedTime,
+}
+
+// ordering matching rules compare normalized values
+var comparers = map[string]comparer{
+	CaseIgnoreOrderingMatchRule:      strings.Compare,
+	CaseExactOrderingMatchRule:       strings.Compare,
+	NumericStringOrderingMatchRule:   compareNumeric,
+	IntegerOrderingMatchRule:         compareNumeric,
+	OctetStringOrderingMatchRule:     strings.Compare,
+	GeneralizedTimeOrderingMatchRule: strings.Compare,
+}
+
+// EqualityMatch returns the equality matching rule of attributeType, inherited from its
+// superior type if necessary
+func (schema *Schema) EqualityMatch(attributeType *AttributeType) string {
+	for _, attributeType := range schema.SuperTypes(attributeType) {
+		if attributeType.EqualityMatch.Valid {
+			return attributeType.EqualityMatch.String
+		}
+	}
+	return ""
+}
+
+// SubstrMatch returns the substrings matching rule of attributeType, inherited from its
+// superior type if necessary
+func (schema *Schema) SubstrMatch(attributeType *AttributeType) string {
+	for _, attributeType := range schema.SuperTypes(attributeType) {
+		if attributeType.SubstrMatch.Valid {
+			return attributeType.SubstrMatch.String
+		}
+	}
+	return ""
+}
+
+// OrderingMatch returns the ordering matching rule of attributeType, inherited from its
+// superior type if necessary
+func (schema *Schema) OrderingMatch(attributeType *AttributeType) string {
+	for _, attributeType := range schema.SuperTypes(attributeType) {
+		if attributeType.OrderingMatch.Valid {
+			return attributeType.OrderingMatch.String
+		}
+	}
+	return ""
+}
+
+// NormalizeValue prepares value for comparison under the named matching rule
+func (schema *Schema) NormalizeValue(rule string, value string) (string, error) {
+	normalize, found := normalizers[rule]
+	if !found {
+		return "", ErrNoMatchingRule
+	}
+	return normalize(schema, value)
+}
+
+// ValuesMatch returns true if value equals assertion under the equality matching rule
+// of attributeType
+func (schema *Schema) ValuesMatch(attributeType *AttributeType, value string, assertion string) (bool, error) {
+	normalize, found := normalizers[schema.EqualityMatch(attributeType)]
+	if !found {
+		return false, ErrNoMatchingRule
+	}
+	normalizedAssertion, err := normalize(schema, assertion)
+	if err != nil {
+		return false, ErrInvalidAssertion
+	}
+	normalizedValue, err := normalize(schema, value)
+	if err != nil {
+		// stored values that can't be normalized never match
+		return false, nil
+	}
+	return normalizedValue == normalizedAssertion, nil
+}
+
+// CompareValues orders value relative to assertion under the ordering matching rule of
+// attributeType, returning -1, 0 or +1
+func (schema *Schema) CompareValues(attributeType *AttributeType, value string, assertion string) (int, error) {
+	rule := schema.OrderingMatch(attributeType)
+	normalize, found := normalizers[rule]
+	compare, comparable := comparers[rule]
+	if !found || !comparable {
+		return 0, ErrNoMatchingRule
+	}
+	normalizedAssertion, err := normalize(schema, assertion)
+	if err != nil {
+		return 0, ErrInvalidAssertion
+	}
+	normalizedValue, err := normalize(schema, value)
+	if err != nil {
+		return 0, ErrInvalidAssertion
+	}
+	return compare(normalizedValue, normalizedAssertion), nil
+}
+
+// SubstringsMatch returns true if value matches the initial, any and final substrings
+// under the substrings matching rule of attributeType
+func (schema *Schema) SubstringsMatch(attributeType *AttributeType, value string,
+	initial string, any []string, final string) (bool, error) {
+	normalize, found := normalizers[schema.SubstrMatch(attributeType)]
+	if !found {
+		return false, ErrNoMatchingRule
+	}
+	value, err := normalize(schema, value)
+	if err != nil {
+		return false, nil
+	}
+	substring := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		// substrings keep their surrounding spaces significant only within the value
+		normalized, _ := normalize(schema, s)
+		return normalized
+	}
+
+	if initial = substring(initial); !strings.HasPrefix(value, initial) {
+		return false, nil
+	}
+	value = value[len(initial):]
+
+	if final = substring(final); !strings.HasSuffix(value, final) {
+		return false, nil
+	}
+	value = value[:len(value)-len(final)]
+
+	for _, s := range any {
+		s = substring(s)
+		i := strings.Index(value, s)
+		if i < 0 {
+			return false, nil
+		}
+		value = value[i+len(s):]
+	}
+	return true, nil
+}
+
+// prepareString applies the insignificant space handling of RFC 4518 section 2.6.1
+func prepareString(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func normalizeExact(schema *Schema, value string) (string, error) {
+	return value, nil
+}
+
+func normalizeCaseExact(schema *Schema, value string) (string, error) {
+	return prepareString(value), nil
+}
+
+func normalizeCaseIgnore(schema *Schema, value string) (string, error) {
+	return strings.ToLower(prepareString(value)), nil
+}
+
+func normalizeNumericString(schema *Schema, value string) (string, error) {
+	return strings.Replace(value, " ", "", -1), nil
+}
+
+func normalizeTelephoneNumber(schema *Schema, value string) (string, error) {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(value)), nil
+}
+
+func normalizeBoolean(schema *Schema, value string) (string, error) {
+	switch value {
+	case "TRUE", "FALSE":
+		return value, nil
+	}
+	return "", ErrInvalidAssertion
+}
+
+func normalizeInteger(schema *Schema, value string) (string, error) {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(value), 10)
+	if !ok {
+		return "", ErrInvalidAssertion
+	}
+	return n.String(), nil
+}
+
+// normalizeOID resolves descriptors (e.g. "person") to their OID
+func normalizeOID(schema *Schema, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if objectClass := schema.ObjectClass(value); objectClass != nil {
+		return objectClass.OID, nil
+	}
+	if attributeType := schema.AttributeType(value); attributeType != nil {
+		return attributeType.OID, nil
+	}
+	return strings.ToLower(value), nil
+}
+
+// normalizeDN resolves attribute type names and case folds values of a DN
+func normalizeDN(schema *Schema, value string) (string, error) {
+	dn, err := ParseDN(value)
+	if err != nil {
+		return "", err
+	}
+	for _, rdn := range dn {
+		for i, atav := range rdn {
+			if attributeType := schema.AttributeType(atav.Type); attributeType != nil {
+				rdn[i].Type = attributeType.OID
+			} else {
+				rdn[i].Type = strings.ToLower(atav.Type)
+			}
+			rdn[i].Value = strings.ToLower(prepareString(atav.Value))
+		}
+	}
+	return dn.String(), nil
+}
+
+func normalizeGeneralizedTime(schema *Schema, value string) (string, error) {
+	t, err := ParseGeneralizedTime(value)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format("20060102150405.000000000Z"), nil
+}
+
+// ParseGeneralizedTime parses the common forms of a GeneralizedTime value
+func ParseGeneralizedTime(value string) (time.Time, error) {
+	layouts := []string{
+		GeneralizedTimeFormat,
+		"20060102150405.999999999Z",
+		"20060102150405-0700",
+		"20060102150405.999999999-0700",
+		"200601021504Z",
+		"2006010215Z",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrInvalidAssertion
+}
+
+func compareNumeric(a string, b string) int {
+	x, _ := new(big.Int).SetString(a, 10)
+	y, _ := new(big.Int).SetString(b, 10)
+	if x == nil || y == nil {
+		return strings.Compare(a, b)
+	}
+	return x.Cmp(y)
+}
+
+// ValuesApproximatelyMatch returns true if value approximately equals assertion
+// string values are compared by the soundex code of each word, other values fall back
+// to the equality matching rule of attributeType
+func (schema *Schema) ValuesApproximatelyMatch(attributeType *AttributeType, value string, assertion string) (bool, error) {
+	switch schema.EqualityMatch(attributeType) {
+	case CaseIgnoreMatchRule, CaseIgnoreIA5MatchRule, CaseIgnoreListMatchRule,
+		CaseExactMatchRule, CaseExactIA5MatchRule:
+		return approximateString(value) == approximateString(assertion), nil
+	}
+	return schema.ValuesMatch(attributeType, value, assertion)
+}
+
+// matchingRuleName returns the name of the matching rule identified by a name or OID
+func (schema *Schema) matchingRuleName(rule string) string {
+	for _, matchingRule := range LDAPv3MatchingRules {
+		if matchingRule.OID == rule || strings.EqualFold(matchingRule.Name, rule) {
+			return matchingRule.Name
+		}
+	}
+	return rule
+}
+
+// approximateString returns the soundex codes of each word in value
+func approximateString(value string) string {
+	words := strings.Fields(strings.ToUpper(value))
+	for i, word := range words {
+		words[i] = soundex(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// soundex returns the American Soundex code for word (an upper case string)
+func soundex(word string) string {
+	const codes = "01230120022455012623010202"
+	result := []byte{}
+	var last byte
+	for i := 0; i < len(word) && len(result) < 4; i++ {
+		c := word[i]
+		if c < 'A' || c > 'Z' {
+			continue
+		}
+		code := codes[c-'A']
+		if len(result) == 0 {
+			result = append(result, c)
+		} else if code != '0' && code != last {
+			result = append(result, code)
+		}
+		if c != 'H' && c != 'W' {
+			last = code
+		}
+	}
+	if len(result) == 0 {
+		return word
+	}
+	for len(result) < 4 {
+		result = append(result, '0')
+	}
+	return string(result)
+}
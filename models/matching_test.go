@@ -0,0 +1,70 @@
+package models
+
+import (
+	"testing"
+)
+
+type matchTest struct {
+	attribute string
+	value     string
+	assertion string
+	expected  bool
+}
+
+var equalityTests = []matchTest{
+	{CommonNameAttribute, "Test  User", "test user", true},
+	{CommonNameAttribute, "Test User", "Test User2", false},
+	{UIDAttribute, "jdoe", "JDOE", true},
+	{MemberAttribute, "cn=Test User,cn=Users,dc=example,dc=org", "CN=test user, commonName=users,DC=Example,DC=org", true},
+	{ObjectClassAttribute, PersonClass, PersonClassID, true},
+	{ObjectClassAttribute, "Person", PersonClass, true},
+	{CreateTimestampAttribute, "20150101000000Z", "20150101010000+0100", true},
+	{UserPasswordAttribute, "secret", "SECRET", false},
+}
+
+func newTestSchema() *Schema {
+	attributeTypes := []*AttributeType{}
+	for i := range LDAPv3AttributeTypes {
+		attributeTypes = append(attributeTypes, &LDAPv3AttributeTypes[i])
+	}
+	objectClasses := []*ObjectClass{}
+	for i := range LDAPv3ObjectClasses {
+		objectClasses = append(objectClasses, &LDAPv3ObjectClasses[i])
+	}
+	return NewSchema(attributeTypes, objectClasses)
+}
+
+func TestValuesMatch(t *testing.T) {
+	schema := newTestSchema()
+	for _, test := range equalityTests {
+		match, err := schema.ValuesMatch(schema.AttributeType(test.attribute), test.value, test.assertion)
+		if err != nil {
+			t.Error("For", test, "ValuesMatch failed:", err)
+		}
+		if match != test.expected {
+			t.Error("For", test, "expected", test.expected)
+		}
+	}
+}
+
+func TestSubstringsMatch(t *testing.T) {
+	schema := newTestSchema()
+	cn := schema.AttributeType(CommonNameAttribute)
+	if match, _ := schema.SubstringsMatch(cn, "Test User", "te", []string{"t u"}, "ER"); !match {
+		t.Error("SubstringsMatch returned false")
+	}
+	if match, _ := schema.SubstringsMatch(cn, "Test User", "user", nil, ""); match {
+		t.Error("SubstringsMatch returned true")
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	schema := newTestSchema()
+	timestamp := schema.AttributeType(ModifyTimestampAttribute)
+	if order, err := schema.CompareValues(timestamp, "20150101000000Z", "20160101000000Z"); err != nil || order >= 0 {
+		t.Error("CompareValues returned", order, err)
+	}
+	if _, err := schema.CompareValues(schema.AttributeType(MemberAttribute), "a", "b"); err != ErrNoMatchingRule {
+		t.Error("CompareValues did not fail for attribute without ordering")
+	}
+}
@@ -97,6 +97,8 @@ const (
 	FriendlyCountryClassID   = "0.9.2342.19200300.100.4.18"
 	RFC822LocalPartClassID   = "0.9.2342.19200300.100.4.14"
 	SimpleSecurityObjClassID = "0.9.2342.19200300.100.4.19"
+	// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11
+	PwdPolicyClassID = "1.3.6.1.4.1.42.2.27.8.2.1"
 
 	// names
 	// https://tools.ietf.org/html/rfc4512
@@ -129,6 +131,8 @@ const (
 	RFC822LocalPartClass   = "rFC822LocalPart"
 	RoomClass              = "room"
 	SimpleSecurityObjClass = "simpleSecurityObject"
+	// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11
+	PwdPolicyClass = "pwdPolicy"
 )
 
 // LDAPv3AttributeTypes represents the standard Object Classes
@@ -536,4 +540,23 @@ var LDAPv3ObjectClasses = [...]ObjectClass{
 		MustAttributes: StringSlice{UserPasswordAttribute},
 		Flags:          OCAuxiliary,
 	},
+	// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11
+	ObjectClass{
+		OID:            PwdPolicyClassID,
+		Name:           PwdPolicyClass,
+		MustAttributes: StringSlice{PwdAttributeAttribute},
+		MayAttributes: StringSlice{
+			PwdMaxAgeAttribute,
+			PwdInHistoryAttribute,
+			PwdCheckQualityAttribute,
+			PwdMinLengthAttribute,
+			PwdExpireWarningAttribute,
+			PwdGraceAuthNLimitAttribute,
+			PwdLockoutAttribute,
+			PwdLockoutDurationAttribute,
+			PwdMaxFailureAttribute,
+			PwdFailureCountIntervalAttribute,
+		},
+		Flags: OCAuxiliary,
+	},
 }
@@ -0,0 +1,301 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// pbkdf2Iterations is the iteration count of new PBKDF2 hashes
+	pbkdf2Iterations = 10000
+	// parameters of new Argon2id hashes https://tools.ietf.org/html/rfc9106#section-4
+	argon2Time    = 2
+	argon2Memory  = 19 * 1024
+	argon2Threads = 1
+	argon2KeySize = 32
+)
+
+// PasswordScheme hashes & verifies userPassword values of the form "{SCHEME}encoded"
+// https://tools.ietf.org/html/rfc3112
+type PasswordScheme struct {
+	Name string
+	// Weak is set for schemes that are replaced by the default scheme once the password
+	// is known (e.g. after a bind)
+	Weak   bool
+	hash   func(password string) (string, error)
+	verify func(encoded string, password string) bool
+}
+
+var passwordSchemes = []*PasswordScheme{
+	{Name: "SHA", Weak: true, hash: saltedDigest(sha1.New, 0), verify: verifySaltedDigest(sha1.New)},
+	{Name: "SSHA", Weak: true, hash: saltedDigest(sha1.New, saltSize), verify: verifySaltedDigest(sha1.New)},
+	{Name: "SHA256", Weak: true, hash: saltedDigest(sha256.New, 0), verify: verifySaltedDigest(sha256.New)},
+	{Name: "SSHA256", Weak: true, hash: saltedDigest(sha256.New, saltSize), verify: verifySaltedDigest(sha256.New)},
+	{Name: "SHA512", Weak: true, hash: saltedDigest(sha512.New, 0), verify: verifySaltedDigest(sha512.New)},
+	{Name: "SSHA512", Weak: true, hash: saltedDigest(sha512.New, saltSize), verify: verifySaltedDigest(sha512.New)},
+	{Name: "CRYPT", Weak: true, hash: hashCrypt, verify: verifyCrypt},
+	{Name: "PBKDF2", Weak: true, hash: pbkdf2Hash(sha1.New), verify: verifyPBKDF2(sha1.New)},
+	{Name: "PBKDF2-SHA256", hash: pbkdf2Hash(sha256.New), verify: verifyPBKDF2(sha256.New)},
+	{Name: "PBKDF2-SHA512", hash: pbkdf2Hash(sha512.New), verify: verifyPBKDF2(sha512.New)},
+	{Name: "ARGON2", hash: hashArgon2, verify: verifyArgon2},
+	{Name: ScramSHA1, hash: scramHash(ScramSHA1), verify: verifyScram(ScramSHA1)},
+	{Name: ScramSHA256, hash: scramHash(ScramSHA256), verify: verifyScram(ScramSHA256)},
+}
+
+// FindPasswordScheme returns the password scheme name, nil if unknown
+func FindPasswordScheme(name string) *PasswordScheme {
+	for _, scheme := range passwordSchemes {
+		if strings.EqualFold(scheme.Name, name) {
+			return scheme
+		}
+	}
+	return nil
+}
+
+// HashPassword returns the userPassword value holding password hashed with scheme
+func HashPassword(scheme string, password string) (string, error) {
+	passwordScheme := FindPasswordScheme(scheme)
+	if passwordScheme == nil {
+		return "", fmt.Errorf("Unknown password scheme '%s'", scheme)
+	}
+	encoded, err := passwordScheme.hash(password)
+	if err != nil {
+		return "", fmt.Errorf("HashPassword failed: %v", err)
+	}
+	return "{" + passwordScheme.Name + "}" + encoded, nil
+}
+
+// ParsePasswordValue splits a userPassword value into its scheme & encoded password
+// the scheme is empty for cleartext values, values with an unknown scheme return an error
+func ParsePasswordValue(value string) (scheme string, encoded string, err error) {
+	if !strings.HasPrefix(value, "{") {
+		return "", value, nil
+	}
+	end := strings.IndexByte(value, '}')
+	if end < 0 {
+		return "", value, nil
+	}
+	passwordScheme := FindPasswordScheme(value[1:end])
+	if passwordScheme == nil {
+		return "", "", fmt.Errorf("Unknown password scheme '%s'", value[1:end])
+	}
+	return passwordScheme.Name, value[end+1:], nil
+}
+
+// IsHashedPasswordValue returns true if the userPassword value is not held in cleartext,
+// including values of unknown schemes
+func IsHashedPasswordValue(value string) bool {
+	scheme, _, err := ParsePasswordValue(value)
+	return err != nil || scheme != ""
+}
+
+// MatchPasswordValue returns the index of the first of the userPassword values matching
+// password, -1 if none match or password is empty
+func MatchPasswordValue(values []string, password string) int {
+	if password == "" {
+		return -1
+	}
+	for i, value := range values {
+		scheme, encoded, err := ParsePasswordValue(value)
+		switch {
+		case err != nil:
+			continue
+		case scheme == "":
+			if subtle.ConstantTimeCompare([]byte(encoded), []byte(password)) == 1 {
+				return i
+			}
+		case FindPasswordScheme(scheme).verify(encoded, password):
+			return i
+		}
+	}
+	return -1
+}
+
+// ComparePasswordValues returns true if password matches one of the userPassword values,
+// an empty password never matches
+func ComparePasswordValues(values []string, password string) bool {
+	return MatchPasswordValue(values, password) >= 0
+}
+
+// IsWeakPasswordValue returns true if the userPassword value is held in cleartext or
+// hashed with a weak scheme
+func IsWeakPasswordValue(value string) bool {
+	scheme, _, err := ParsePasswordValue(value)
+	return err == nil && (scheme == "" || FindPasswordScheme(scheme).Weak)
+}
+
+// PasswordScramKeys returns the SCRAM keys of mechanism for the first of the userPassword
+// values stored as keys of mechanism or in cleartext, nil if there are none
+// keys of cleartext values are derived using salt
+func PasswordScramKeys(mechanism string, values []string, salt []byte) *ScramKeys {
+	for _, value := range values {
+		scheme, encoded, err := ParsePasswordValue(value)
+		switch {
+		case err != nil:
+		case scheme == "":
+			return NewScramKeys(ScramHash(mechanism), encoded, salt, ScramIterations)
+		case scheme == mechanism:
+			if keys, err := ParseScramKeys(encoded); err == nil {
+				return keys
+			}
+		}
+	}
+	return nil
+}
+
+// saltedDigest returns a hash function encoding base64(H(password + salt) + salt), the
+// digest is unsalted if saltSize is 0
+func saltedDigest(newHash func() hash.Hash, saltSize int) func(password string) (string, error) {
+	return func(password string) (string, error) {
+		salt, err := randomSalt(saltSize)
+		if err != nil {
+			return "", err
+		}
+		h := newHash()
+		h.Write([]byte(password))
+		h.Write(salt)
+		return base64.StdEncoding.EncodeToString(append(h.Sum(nil), salt...)), nil
+	}
+}
+
+func verifySaltedDigest(newHash func() hash.Hash) func(encoded string, password string) bool {
+	return func(encoded string, password string) bool {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		size := newHash().Size()
+		if err != nil || len(decoded) < size {
+			return false
+		}
+		h := newHash()
+		h.Write([]byte(password))
+		h.Write(decoded[size:])
+		return subtle.ConstantTimeCompare(h.Sum(nil), decoded[:size]) == 1
+	}
+}
+
+// pbkdf2Hash returns a hash function encoding "<iterations>$<salt>$<key>" where salt & key
+// use the adapted base64 encoding of passlib (OpenLDAP pw-pbkdf2): "." replaces "+",
+// without padding
+func pbkdf2Hash(newHash func() hash.Hash) func(password string) (string, error) {
+	return func(password string) (string, error) {
+		salt, err := randomSalt(saltSize)
+		if err != nil {
+			return "", err
+		}
+		key := pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, newHash().Size(), newHash)
+		return fmt.Sprintf("%d$%s$%s", pbkdf2Iterations, encodeAdaptedBase64(salt), encodeAdaptedBase64(key)), nil
+	}
+}
+
+func verifyPBKDF2(newHash func() hash.Hash) func(encoded string, password string) bool {
+	return func(encoded string, password string) bool {
+		fields := strings.Split(encoded, "$")
+		if len(fields) != 3 {
+			return false
+		}
+		iterations, err := strconv.Atoi(fields[0])
+		if err != nil || iterations <= 0 {
+			return false
+		}
+		salt, err := decodeAdaptedBase64(fields[1])
+		if err != nil {
+			return false
+		}
+		expected, err := decodeAdaptedBase64(fields[2])
+		if err != nil || len(expected) == 0 {
+			return false
+		}
+		key := pbkdf2.Key([]byte(password), salt, iterations, len(expected), newHash)
+		return subtle.ConstantTimeCompare(key, expected) == 1
+	}
+}
+
+func encodeAdaptedBase64(data []byte) string {
+	return strings.Replace(base64.RawStdEncoding.EncodeToString(data), "+", ".", -1)
+}
+
+func decodeAdaptedBase64(encoded string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(strings.Replace(encoded, ".", "+", -1), "="))
+}
+
+// hashArgon2 encodes an Argon2id hash in the PHC string format of the reference
+// implementation: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func hashArgon2(password string) (string, error) {
+	salt, err := randomSalt(saltSize)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeySize)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyArgon2 verifies an Argon2id or Argon2i hash in the PHC string format
+func verifyArgon2(encoded string, password string) bool {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[0] != "" || fields[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || threads == 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	var key []byte
+	switch fields[1] {
+	case "argon2id":
+		key = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	case "argon2i":
+		key = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// scramHash returns a hash function encoding the SCRAM keys of mechanism, see ScramKeys.String
+func scramHash(mechanism string) func(password string) (string, error) {
+	return func(password string) (string, error) {
+		salt, err := randomSalt(saltSize)
+		if err != nil {
+			return "", err
+		}
+		return NewScramKeys(ScramHash(mechanism), password, salt, ScramIterations).String(), nil
+	}
+}
+
+func verifyScram(mechanism string) func(encoded string, password string) bool {
+	return func(encoded string, password string) bool {
+		keys, err := ParseScramKeys(encoded)
+		return err == nil && keys.Verify(ScramHash(mechanism), password)
+	}
+}
+
+func randomSalt(size int) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	salt := make([]byte, size)
+	_, err := io.ReadFull(rand.Reader, salt)
+	return salt, err
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+type passwordTest struct {
+	value    string
+	password string
+}
+
+// reference values generated by OpenLDAP slappasswd, passlib, openssl passwd & the reference
+// Argon2 implementation
+var passwordValues = []passwordTest{
+	{"secret", "secret"},
+	{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret"},
+	{"{SSHA}1G904nLkTkGWjKNnQuB/hpWXC/hzYWx0c2FsdA==", "secret"},
+	{"{ssha512}aCu7JRc+kLsuEmFs1zTY+AiP7DSGnjjG+dH28Dp+E5usqoAixeTPihKqZmkWal4mUfp63tqvCAkFV1LKTDFH6XNhbHRzYWx0", "secret"},
+	{"{PBKDF2-SHA256}1000$AAECAwQFBgcICQoLDA0ODw$Tvsru20utY6o3q7VRBeuL9h/1QqKhWhwk2PaYNRWBgY", "secret"},
+	{"{CRYPT}$1$abcdefgh$G//4keteveJp0qb8z2DxG/", "password"},
+	{"{CRYPT}$1$abcdefgh$jUYc1Xi7pkozuzWQ0Dft71", "a"},
+	{"{CRYPT}$5$saltstring$OH4IDuTlsuTYPdED1gsuiRMyTAwNlRWyA6Xr3I4/dQ5", "password"},
+	{"{CRYPT}$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!"},
+	{"{CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!"},
+	{"{CRYPT}$6$saltstringsaltst$6JOgtRfhXqEisnc/Nr64lml/zPnCnvtLyMVxFEVg0sI2Ph9URAKlnVjjIHOFI2r8ATszyoPTXlBwcJIQYQ0QN0", "password"},
+	{"{CRYPT}$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"},
+	{"{ARGON2}$argon2i$v=19$m=65536,t=2,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", "password"},
+}
+
+func TestComparePasswordValues(t *testing.T) {
+	for _, test := range passwordValues {
+		if !ComparePasswordValues([]string{test.value}, test.password) {
+			t.Error("For", test.value, "password", test.password, "did not match")
+		}
+		if ComparePasswordValues([]string{test.value}, test.password+"x") {
+			t.Error("For", test.value, "password", test.password+"x", "matched")
+		}
+	}
+}
+
+func TestMatchPasswordValue(t *testing.T) {
+	values := []string{"{UNKNOWN}secret", "{UNKNOWN}secret", "{SSHA}1G904nLkTkGWjKNnQuB/hpWXC/hzYWx0c2FsdA=="}
+	if i := MatchPasswordValue(values, "secret"); i != 2 {
+		t.Error("Expected value 2 to match, got", i)
+	}
+	if i := MatchPasswordValue([]string{""}, ""); i != -1 {
+		t.Error("Expected an empty password not to match, got", i)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	for _, scheme := range passwordSchemes {
+		value, err := HashPassword(strings.ToLower(scheme.Name), "secret")
+		if err != nil {
+			t.Error("For", scheme.Name, "HashPassword failed:", err)
+			continue
+		}
+		if !strings.HasPrefix(value, "{"+scheme.Name+"}") {
+			t.Error("For", scheme.Name, "unexpected value", value)
+		}
+		if !ComparePasswordValues([]string{value}, "secret") || ComparePasswordValues([]string{value}, "secreT") {
+			t.Error("For", scheme.Name, "value", value, "does not verify the password")
+		}
+		if IsWeakPasswordValue(value) != scheme.Weak {
+			t.Error("For", scheme.Name, "expected IsWeakPasswordValue", scheme.Weak)
+		}
+	}
+	if _, err := HashPassword("UNKNOWN", "secret"); err == nil {
+		t.Error("HashPassword did not fail for an unknown scheme")
+	}
+}
+
+func TestIsWeakPasswordValue(t *testing.T) {
+	if !IsWeakPasswordValue("secret") {
+		t.Error("Expected cleartext values to be weak")
+	}
+	if IsWeakPasswordValue("{UNKNOWN}secret") {
+		t.Error("Expected values of unknown schemes not to be weak")
+	}
+}
+
+func TestAuthPasswordValues(t *testing.T) {
+	values, err := NewAuthPasswordValues("secret")
+	if err != nil {
+		t.Fatal("NewAuthPasswordValues failed:", err)
+	}
+	if !HasAuthPasswordScramKeys(values) || HasAuthPasswordScramKeys(values[1:]) {
+		t.Error("Unexpected keys", values)
+	}
+	for _, mechanism := range []string{ScramSHA1, ScramSHA256} {
+		keys := AuthPasswordScramKeys(mechanism, values)
+		if keys == nil || !keys.Verify(ScramHash(mechanism), "secret") {
+			t.Error("For", mechanism, "keys", keys, "do not verify the password")
+		}
+	}
+	if keys := AuthPasswordScramKeys(ScramSHA256, []string{"SCRAM-SHA-256$invalid"}); keys != nil {
+		t.Error("Expected no keys for an invalid value, got", keys)
+	}
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PasswordPolicyError is the reason a password policy refused an operation
+// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11#section-6.2
+type PasswordPolicyError int
+
+const (
+	PasswordExpired PasswordPolicyError = iota
+	AccountLocked
+	ChangeAfterReset
+	PasswordModNotAllowed
+	MustSupplyOldPassword
+	InsufficientPasswordQuality
+	PasswordTooShort
+	PasswordTooYoung
+	PasswordInHistory
+)
+
+const (
+	// PermanentLockTime is the pwdAccountLockedTime of accounts locked until an administrator
+	// unlocks them
+	PermanentLockTime = "000001010000Z"
+	// pwdFailureTimeFormat keeps the failures recorded within the same second distinct
+	pwdFailureTimeFormat = "20060102150405.000000Z"
+)
+
+// PasswordPolicy holds the settings of a pwdPolicy entry, durations of 0 are unlimited
+// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11#section-5.2
+type PasswordPolicy struct {
+	DN string
+	// CheckQuality is 2 to refuse new passwords supplied already hashed, whose quality cannot
+	// be checked, 0 or 1 to accept them
+	CheckQuality int
+	// MinLength is the minimum number of characters of new passwords
+	MinLength int
+	// InHistory is the number of previous passwords that cannot be reused
+	InHistory int
+	// MaxAge is the duration after which passwords expire
+	MaxAge time.Duration
+	// ExpireWarning is the duration before expiration during which binds are warned
+	ExpireWarning time.Duration
+	// GraceAuthNLimit is the number of binds allowed once the password has expired
+	GraceAuthNLimit int
+	// Lockout locks accounts after MaxFailure consecutive failed binds
+	Lockout         bool
+	LockoutDuration time.Duration
+	MaxFailure      int
+	// FailureCountInterval is the duration after which failures are forgotten
+	FailureCountInterval time.Duration
+}
+
+// NewPasswordPolicy returns the password policy held by the pwdPolicy entry, invalid or
+// missing settings are ignored
+func NewPasswordPolicy(entry *Entry) *PasswordPolicy {
+	integer := func(name string) int {
+		values := entry.UserValues[name]
+		if len(values) == 0 {
+			return 0
+		}
+		n, err := strconv.Atoi(values[0])
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	seconds := func(name string) time.Duration {
+		return time.Duration(integer(name)) * time.Second
+	}
+
+	lockout := entry.UserValues[PwdLockoutAttribute]
+	return &PasswordPolicy{
+		DN:                   entry.DN,
+		CheckQuality:         integer(PwdCheckQualityAttribute),
+		MinLength:            integer(PwdMinLengthAttribute),
+		InHistory:            integer(PwdInHistoryAttribute),
+		MaxAge:               seconds(PwdMaxAgeAttribute),
+		ExpireWarning:        seconds(PwdExpireWarningAttribute),
+		GraceAuthNLimit:      integer(PwdGraceAuthNLimitAttribute),
+		Lockout:              len(lockout) > 0 && strings.EqualFold(lockout[0], "TRUE"),
+		LockoutDuration:      seconds(PwdLockoutDurationAttribute),
+		MaxFailure:           integer(PwdMaxFailureAttribute),
+		FailureCountInterval: seconds(PwdFailureCountIntervalAttribute),
+	}
+}
+
+// IsLocked returns true if the account held by entry is locked at now
+func (policy *PasswordPolicy) IsLocked(entry *Entry, now time.Time) bool {
+	values := entry.OperValues[PwdAccountLockedTimeAttribute]
+	switch {
+	case len(values) == 0:
+		return false
+	case values[0] == PermanentLockTime || policy.LockoutDuration == 0:
+		return true
+	}
+	locked, err := ParseGeneralizedTime(values[0])
+	return err != nil || now.Before(locked.Add(policy.LockoutDuration))
+}
+
+// RecordFailure records a failed bind at now, locking the account once MaxFailure failures
+// have been recorded within FailureCountInterval
+func (policy *PasswordPolicy) RecordFailure(entry *Entry, now time.Time) {
+	if !policy.Lockout {
+		return
+	}
+	failures := []string{}
+	for _, value := range entry.OperValues[PwdFailureTimeAttribute] {
+		failed, err := ParseGeneralizedTime(value)
+		if err == nil && (policy.FailureCountInterval == 0 || now.Sub(failed) < policy.FailureCountInterval) {
+			failures = append(failures, value)
+		}
+	}
+	failures = append(failures, now.UTC().Format(pwdFailureTimeFormat))
+	setOperationalValues(entry, PwdFailureTimeAttribute, failures)
+
+	if policy.MaxFailure > 0 && len(failures) >= policy.MaxFailure {
+		setOperationalValues(entry, PwdAccountLockedTimeAttribute, []string{GeneralizedTime(now)})
+	}
+}
+
+// RecordSuccess forgets the failed binds & the expired lock of the account held by entry
+func (policy *PasswordPolicy) RecordSuccess(entry *Entry) {
+	setOperationalValues(entry, PwdFailureTimeAttribute, nil)
+	setOperationalValues(entry, PwdAccountLockedTimeAttribute, nil)
+}
+
+// Expiration returns the time the password held by entry expires, zero if it does not
+func (policy *PasswordPolicy) Expiration(entry *Entry) time.Time {
+	values := entry.OperValues[PwdChangedTimeAttribute]
+	if policy.MaxAge == 0 || len(values) == 0 {
+		return time.Time{}
+	}
+	changed, err := ParseGeneralizedTime(values[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return changed.Add(policy.MaxAge)
+}
+
+// UseGraceAuthN records a bind at now using an expired password, returning the number of
+// grace binds remaining, false if none were left
+func (policy *PasswordPolicy) UseGraceAuthN(entry *Entry, now time.Time) (remaining int, ok bool) {
+	used := entry.OperValues[PwdGraceUseTimeAttribute]
+	if len(used) >= policy.GraceAuthNLimit {
+		return 0, false
+	}
+	used = append(append([]string{}, used...), now.UTC().Format(pwdFailureTimeFormat))
+	setOperationalValues(entry, PwdGraceUseTimeAttribute, used)
+	return policy.GraceAuthNLimit - len(used), true
+}
+
+// CheckPassword returns the error preventing password from replacing the current
+// userPassword values of entry, false if password is acceptable
+// the quality of passwords supplied already hashed cannot be checked, they are only refused
+// when CheckQuality is 2
+func (policy *PasswordPolicy) CheckPassword(entry *Entry, current []string, password string) (PasswordPolicyError, bool) {
+	if IsHashedPasswordValue(password) {
+		return InsufficientPasswordQuality, policy.CheckQuality == 2
+	}
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		return PasswordTooShort, true
+	}
+	if policy.InHistory > 0 {
+		previous := append([]string{}, current...)
+		for _, value := range entry.OperValues[PwdHistoryAttribute] {
+			if _, previousValue, ok := parsePwdHistory(value); ok {
+				previous = append(previous, previousValue)
+			}
+		}
+		if ComparePasswordValues(previous, password) {
+			return PasswordInHistory, true
+		}
+	}
+	return 0, false
+}
+
+// RecordPasswordChange records that the previous userPassword values of entry were replaced
+// at now, keeping the InHistory most recent values
+func (policy *PasswordPolicy) RecordPasswordChange(entry *Entry, previous []string, now time.Time) {
+	setOperationalValues(entry, PwdChangedTimeAttribute, []string{GeneralizedTime(now)})
+	setOperationalValues(entry, PwdGraceUseTimeAttribute, nil)
+
+	if policy.InHistory == 0 {
+		setOperationalValues(entry, PwdHistoryAttribute, nil)
+		return
+	}
+	history := append([]string{}, entry.OperValues[PwdHistoryAttribute]...)
+	for _, value := range previous {
+		history = append(history, formatPwdHistory(now, value))
+	}
+	if len(history) > policy.InHistory {
+		history = history[len(history)-policy.InHistory:]
+	}
+	setOperationalValues(entry, PwdHistoryAttribute, history)
+}
+
+// formatPwdHistory returns the pwdHistory value recording the userPassword value replaced at t
+// pwdHistory = time "#" syntaxOID "#" length "#" data
+func formatPwdHistory(t time.Time, value string) string {
+	return fmt.Sprintf("%s#%s#%d#%s", t.UTC().Format(pwdFailureTimeFormat), OctetStringSyntaxID, len(value), value)
+}
+
+func parsePwdHistory(history string) (t time.Time, value string, ok bool) {
+	fields := strings.SplitN(history, "#", 4)
+	if len(fields) != 4 {
+		return time.Time{}, "", false
+	}
+	t, err := ParseGeneralizedTime(fields[0])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	if length, err := strconv.Atoi(fields[2]); err != nil || length != len(fields[3]) {
+		return time.Time{}, "", false
+	}
+	return t, fields[3], true
+}
+
+// setOperationalValues replaces the values of the operational attribute name held by entry,
+// removing the attribute if values is empty
+func setOperationalValues(entry *Entry, name string, values []string) {
+	if len(values) == 0 {
+		delete(entry.OperValues, name)
+		return
+	}
+	if entry.OperValues == nil {
+		entry.OperValues = AttributeValues{}
+	}
+	entry.OperValues[name] = values
+}
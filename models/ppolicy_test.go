@@ -0,0 +1,127 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestPolicy() *PasswordPolicy {
+	return NewPasswordPolicy(&Entry{
+		DN: "cn=default,ou=policies,dc=example,dc=org",
+		UserValues: AttributeValues{
+			PwdAttributeAttribute:            []string{UserPasswordAttribute},
+			PwdMinLengthAttribute:            []string{"8"},
+			PwdInHistoryAttribute:            []string{"2"},
+			PwdMaxAgeAttribute:               []string{"3600"},
+			PwdExpireWarningAttribute:        []string{"600"},
+			PwdGraceAuthNLimitAttribute:      []string{"2"},
+			PwdLockoutAttribute:              []string{"TRUE"},
+			PwdLockoutDurationAttribute:      []string{"300"},
+			PwdMaxFailureAttribute:           []string{"3"},
+			PwdFailureCountIntervalAttribute: []string{"60"},
+		},
+	})
+}
+
+func TestNewPasswordPolicy(t *testing.T) {
+	policy := newTestPolicy()
+	if policy.MinLength != 8 || policy.InHistory != 2 || policy.MaxAge != time.Hour ||
+		policy.ExpireWarning != 10*time.Minute || policy.GraceAuthNLimit != 2 || !policy.Lockout ||
+		policy.LockoutDuration != 5*time.Minute || policy.MaxFailure != 3 || policy.FailureCountInterval != time.Minute {
+		t.Error("Unexpected policy", policy)
+	}
+}
+
+func TestPasswordPolicyLockout(t *testing.T) {
+	policy := newTestPolicy()
+	entry := &Entry{}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// failures older than the count interval are forgotten
+	policy.RecordFailure(entry, now)
+	policy.RecordFailure(entry, now.Add(2*time.Minute))
+	policy.RecordFailure(entry, now.Add(2*time.Minute))
+	if policy.IsLocked(entry, now.Add(2*time.Minute)) {
+		t.Error("Locked after", entry.OperValues[PwdFailureTimeAttribute])
+	}
+	policy.RecordFailure(entry, now.Add(2*time.Minute))
+	if !policy.IsLocked(entry, now.Add(2*time.Minute)) {
+		t.Error("Not locked after", entry.OperValues[PwdFailureTimeAttribute])
+	}
+	if policy.IsLocked(entry, now.Add(7*time.Minute)) {
+		t.Error("Still locked once the lockout duration has elapsed")
+	}
+
+	policy.RecordSuccess(entry)
+	if len(entry.OperValues[PwdFailureTimeAttribute]) != 0 || len(entry.OperValues[PwdAccountLockedTimeAttribute]) != 0 {
+		t.Error("Failures not forgotten", entry.OperValues)
+	}
+
+	entry.OperValues[PwdAccountLockedTimeAttribute] = []string{PermanentLockTime}
+	if !policy.IsLocked(entry, now.Add(24*time.Hour)) {
+		t.Error("Expected a permanent lock")
+	}
+}
+
+func TestPasswordPolicyExpiration(t *testing.T) {
+	policy := newTestPolicy()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := &Entry{}
+	if expiration := policy.Expiration(entry); !expiration.IsZero() {
+		t.Error("Expected no expiration, got", expiration)
+	}
+
+	policy.RecordPasswordChange(entry, nil, now)
+	if expiration := policy.Expiration(entry); !expiration.Equal(now.Add(time.Hour)) {
+		t.Error("Unexpected expiration", expiration)
+	}
+
+	for expected := 1; expected >= 0; expected-- {
+		if remaining, ok := policy.UseGraceAuthN(entry, now); !ok || remaining != expected {
+			t.Error("Expected", expected, "grace binds remaining, got", remaining, ok)
+		}
+	}
+	if _, ok := policy.UseGraceAuthN(entry, now); ok {
+		t.Error("Expected no grace binds remaining")
+	}
+
+	policy.RecordPasswordChange(entry, nil, now)
+	if _, ok := policy.UseGraceAuthN(entry, now); !ok {
+		t.Error("Expected grace binds to be reset by a password change")
+	}
+}
+
+func TestPasswordPolicyCheckPassword(t *testing.T) {
+	policy := newTestPolicy()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := &Entry{}
+
+	if code, refused := policy.CheckPassword(entry, nil, "short"); !refused || code != PasswordTooShort {
+		t.Error("Expected passwordTooShort, got", code, refused)
+	}
+	hashed := "{SSHA}1G904nLkTkGWjKNnQuB/hpWXC/hzYWx0c2FsdA=="
+	if _, refused := policy.CheckPassword(entry, nil, hashed); refused {
+		t.Error("Expected hashed passwords to be accepted")
+	}
+	policy.CheckQuality = 2
+	if code, refused := policy.CheckPassword(entry, nil, hashed); !refused || code != InsufficientPasswordQuality {
+		t.Error("Expected insufficientPasswordQuality for hashed passwords, got", code, refused)
+	}
+	policy.CheckQuality = 0
+
+	current := []string{"password1"}
+	for _, password := range []string{"password2", "password3"} {
+		policy.RecordPasswordChange(entry, current, now)
+		current = []string{password}
+	}
+	// the history holds password1 & password2, password3 is current
+	for _, password := range []string{"password1", "password2", "password3"} {
+		if code, refused := policy.CheckPassword(entry, current, password); !refused || code != PasswordInHistory {
+			t.Error("For", password, "expected passwordInHistory, got", code, refused)
+		}
+	}
+	policy.RecordPasswordChange(entry, current, now)
+	if _, refused := policy.CheckPassword(entry, []string{"password4"}, "password1"); refused {
+		t.Error("Expected password1 to have left the history", entry.OperValues[PwdHistoryAttribute])
+	}
+}
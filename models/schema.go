@@ -0,0 +1,110 @@
+package models
+
+import (
+	"sort"
+	"strings"
+)
+
+// Schema provides case-insensitive lookups of attribute types and object classes
+// by name, alternate name or OID
+type Schema struct {
+	attributeTypes map[string]*AttributeType
+	objectClasses  map[string]*ObjectClass
+}
+
+// NewSchema creates a Schema indexing attributeTypes and objectClasses
+func NewSchema(attributeTypes []*AttributeType, objectClasses []*ObjectClass) *Schema {
+	schema := &Schema{
+		attributeTypes: make(map[string]*AttributeType),
+		objectClasses:  make(map[string]*ObjectClass),
+	}
+	for _, attributeType := range attributeTypes {
+		schema.attributeTypes[strings.ToLower(attributeType.Name)] = attributeType
+		schema.attributeTypes[attributeType.OID] = attributeType
+		for _, name := range attributeType.Names {
+			schema.attributeTypes[strings.ToLower(name)] = attributeType
+		}
+	}
+	for _, objectClass := range objectClasses {
+		schema.objectClasses[strings.ToLower(objectClass.Name)] = objectClass
+		schema.objectClasses[objectClass.OID] = objectClass
+		for _, name := range objectClass.Names {
+			schema.objectClasses[strings.ToLower(name)] = objectClass
+		}
+	}
+	return schema
+}
+
+// AttributeType returns the attribute type for name or nil if undefined
+func (schema *Schema) AttributeType(name string) *AttributeType {
+	return schema.attributeTypes[strings.ToLower(name)]
+}
+
+// ObjectClass returns the object class for name or nil if undefined
+func (schema *Schema) ObjectClass(name string) *ObjectClass {
+	return schema.objectClasses[strings.ToLower(name)]
+}
+
+// SuperClasses returns objectClass followed by each of its superclasses
+func (schema *Schema) SuperClasses(objectClass *ObjectClass) []*ObjectClass {
+	result := []*ObjectClass{}
+	for objectClass != nil {
+		result = append(result, objectClass)
+		if !objectClass.Super.Valid || strings.EqualFold(objectClass.Super.String, objectClass.Name) {
+			break
+		}
+		objectClass = schema.ObjectClass(objectClass.Super.String)
+	}
+	return result
+}
+
+// SuperTypes returns attributeType followed by each of its superior types
+func (schema *Schema) SuperTypes(attributeType *AttributeType) []*AttributeType {
+	result := []*AttributeType{}
+	for attributeType != nil && len(result) < 16 {
+		result = append(result, attributeType)
+		if !attributeType.Super.Valid {
+			break
+		}
+		attributeType = schema.AttributeType(attributeType.Super.String)
+	}
+	return result
+}
+
+// IsSubtype returns true if attributeType is super or one of its subtypes
+func (schema *Schema) IsSubtype(attributeType *AttributeType, super *AttributeType) bool {
+	for _, attributeType := range schema.SuperTypes(attributeType) {
+		if attributeType == super {
+			return true
+		}
+	}
+	return false
+}
+
+// EntryValues returns the values of attributeType and all of its subtypes held by entry
+func (schema *Schema) EntryValues(entry *Entry, attributeType *AttributeType) []string {
+	values := append([]string{}, entry.Values(attributeType)...)
+	for _, attributes := range []AttributeValues{entry.UserValues, entry.OperValues} {
+		for name, v := range attributes {
+			subtype := schema.AttributeType(name)
+			if subtype != nil && subtype != attributeType && schema.IsSubtype(subtype, attributeType) {
+				values = append(values, v...)
+			}
+		}
+	}
+	return values
+}
+
+// Subtypes returns attributeType followed by each of its subtypes
+func (schema *Schema) Subtypes(attributeType *AttributeType) []*AttributeType {
+	result := []*AttributeType{attributeType}
+	seen := map[*AttributeType]bool{attributeType: true}
+	for _, subtype := range schema.attributeTypes {
+		if !seen[subtype] && schema.IsSubtype(subtype, attributeType) {
+			seen[subtype] = true
+			result = append(result, subtype)
+		}
+	}
+	sort.Slice(result[1:], func(i, j int) bool { return result[i+1].Name < result[j+1].Name })
+	return result
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// ScramSHA1 is the SCRAM mechanism using SHA-1 https://tools.ietf.org/html/rfc5802
+	ScramSHA1 = "SCRAM-SHA-1"
+	// ScramSHA256 is the SCRAM mechanism using SHA-256 https://tools.ietf.org/html/rfc7677
+	ScramSHA256 = "SCRAM-SHA-256"
+	// ScramIterations is the iteration count used to derive new SCRAM keys
+	// stored SCRAM keys let whoever obtains them test password guesses at the cost of these
+	// PBKDF2 iterations, whatever the scheme hashing the password itself (e.g. ARGON2)
+	ScramIterations = pbkdf2Iterations
+)
+
+// ScramHash returns the hash function of the SCRAM mechanism, nil if mechanism is unknown
+func ScramHash(mechanism string) func() hash.Hash {
+	switch mechanism {
+	case ScramSHA1:
+		return sha1.New
+	case ScramSHA256:
+		return sha256.New
+	}
+	return nil
+}
+
+// ScramKeys are the SCRAM credentials of a password: the StoredKey & ServerKey along with
+// the salt & iteration count used to derive them
+// https://tools.ietf.org/html/rfc5802#section-3
+type ScramKeys struct {
+	Iterations int
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewScramKeys derives the SCRAM keys of password using hash
+func NewScramKeys(hash func() hash.Hash, password string, salt []byte, iterations int) *ScramKeys {
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iterations, hash().Size(), hash)
+	return newScramKeysFromSaltedPassword(hash, saltedPassword, salt, iterations)
+}
+
+func newScramKeysFromSaltedPassword(hash func() hash.Hash, saltedPassword []byte, salt []byte, iterations int) *ScramKeys {
+	clientKey := ScramHMAC(hash, saltedPassword, "Client Key")
+	storedKey := hash()
+	storedKey.Write(clientKey)
+	return &ScramKeys{
+		Iterations: iterations,
+		Salt:       salt,
+		StoredKey:  storedKey.Sum(nil),
+		ServerKey:  ScramHMAC(hash, saltedPassword, "Server Key"),
+	}
+}
+
+// ScramHMAC returns the HMAC of message keyed by key using hash
+func ScramHMAC(hash func() hash.Hash, key []byte, message string) []byte {
+	mac := hmac.New(hash, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// Verify returns true if keys were derived from password using hash
+func (keys *ScramKeys) Verify(hash func() hash.Hash, password string) bool {
+	derived := NewScramKeys(hash, password, keys.Salt, keys.Iterations)
+	return hmac.Equal(derived.StoredKey, keys.StoredKey)
+}
+
+// String returns keys formatted as the authPassword value of RFC 5803:
+// iteration count ":" salt "$" StoredKey ":" ServerKey
+func (keys *ScramKeys) String() string {
+	encoding := base64.StdEncoding
+	return fmt.Sprintf("%d:%s$%s:%s", keys.Iterations, encoding.EncodeToString(keys.Salt),
+		encoding.EncodeToString(keys.StoredKey), encoding.EncodeToString(keys.ServerKey))
+}
+
+// ParseScramKeys parses keys formatted by ScramKeys.String
+func ParseScramKeys(value string) (*ScramKeys, error) {
+	fields := strings.FieldsFunc(value, func(r rune) bool { return r == ':' || r == '$' })
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("Invalid SCRAM keys '%s'", value)
+	}
+	keys := &ScramKeys{}
+	var err error
+	if keys.Iterations, err = strconv.Atoi(fields[0]); err != nil || keys.Iterations <= 0 {
+		return nil, fmt.Errorf("Invalid SCRAM iteration count '%s'", fields[0])
+	}
+	for i, key := range []*[]byte{&keys.Salt, &keys.StoredKey, &keys.ServerKey} {
+		if *key, err = base64.StdEncoding.DecodeString(fields[i+1]); err != nil {
+			return nil, fmt.Errorf("Invalid SCRAM keys '%s': %v", value, err)
+		}
+	}
+	return keys, nil
+}
+
+// NewAuthPasswordValues returns the authPassword values holding the SCRAM keys of password
+// for every SCRAM mechanism https://tools.ietf.org/html/rfc5803
+func NewAuthPasswordValues(password string) ([]string, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return nil, fmt.Errorf("generateSalt failed: %v", err)
+	}
+	values := []string{}
+	for _, mechanism := range []string{ScramSHA1, ScramSHA256} {
+		keys := NewScramKeys(ScramHash(mechanism), password, salt, ScramIterations)
+		values = append(values, mechanism+"$"+keys.String())
+	}
+	return values, nil
+}
+
+// AuthPasswordScramKeys returns the SCRAM keys of mechanism held by the authPassword values,
+// nil if none
+func AuthPasswordScramKeys(mechanism string, values []string) *ScramKeys {
+	for _, value := range values {
+		if !strings.HasPrefix(value, mechanism+"$") {
+			continue
+		}
+		if keys, err := ParseScramKeys(value[len(mechanism)+1:]); err == nil {
+			return keys
+		}
+	}
+	return nil
+}
+
+// HasAuthPasswordScramKeys returns true if the authPassword values hold the keys of every
+// SCRAM mechanism, derived with at least ScramIterations
+func HasAuthPasswordScramKeys(values []string) bool {
+	for _, mechanism := range []string{ScramSHA1, ScramSHA256} {
+		if keys := AuthPasswordScramKeys(mechanism, values); keys == nil || keys.Iterations < ScramIterations {
+			return false
+		}
+	}
+	return true
+}
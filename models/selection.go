@@ -0,0 +1,106 @@
+package models
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// AllUserAttributes selects every user attribute of an entry
+	AllUserAttributes = "*"
+	// AllOperationalAttributes selects every operational attribute of an entry
+	AllOperationalAttributes = "+"
+	// NoAttributes selects no attributes at all
+	// http://www.alvestrand.no/objectid/1.1.html
+	NoAttributes = "1.1"
+)
+
+// Attribute is an attribute type name along with its values
+type Attribute struct {
+	Type   string
+	Values []string
+}
+
+// AttributeSelection holds the attributes requested by a search
+// https://tools.ietf.org/html/rfc4511#section-4.5.1.8
+type AttributeSelection struct {
+	allUser        bool
+	allOperational bool
+	attributeTypes []*AttributeType
+}
+
+// NewAttributeSelection resolves the attribute descriptions of a search request through
+// schema, unrecognized attribute types are ignored
+func NewAttributeSelection(schema *Schema, attributes []string) *AttributeSelection {
+	selection := &AttributeSelection{allUser: len(attributes) == 0}
+	for _, name := range attributes {
+		// attribute options (e.g. ";binary") select the same values
+		if i := strings.IndexByte(name, ';'); i >= 0 {
+			name = name[:i]
+		}
+		switch name {
+		case AllUserAttributes:
+			selection.allUser = true
+		case AllOperationalAttributes:
+			selection.allOperational = true
+		case NoAttributes:
+			// only meaningful alone, otherwise ignored
+		default:
+			if attributeType := schema.AttributeType(name); attributeType != nil {
+				selection.attributeTypes = append(selection.attributeTypes, attributeType)
+			}
+		}
+	}
+	return selection
+}
+
+// Selects returns true if values of attributeType are requested, either explicitly, as a
+// subtype of a requested type or through "*" or "+"
+func (selection *AttributeSelection) Selects(schema *Schema, attributeType *AttributeType) bool {
+	if attributeType == nil {
+		return false
+	}
+	if attributeType.IsOperational() && selection.allOperational ||
+		!attributeType.IsOperational() && selection.allUser {
+		return true
+	}
+	for _, selected := range selection.attributeTypes {
+		if schema.IsSubtype(attributeType, selected) {
+			return true
+		}
+	}
+	return false
+}
+
+// Attributes returns the selected attributes of entry, objectClass first followed by user
+// and operational attributes ordered by name
+func (selection *AttributeSelection) Attributes(schema *Schema, entry *Entry) []Attribute {
+	result := []Attribute{}
+	if objectClass := schema.AttributeType(ObjectClassAttribute); selection.Selects(schema, objectClass) {
+		result = append(result, Attribute{Type: ObjectClassAttribute, Values: entry.Values(objectClass)})
+	}
+
+	for i, attributes := range []AttributeValues{entry.UserValues, entry.OperValues} {
+		operational := i == 1
+		names := []string{}
+		for name := range attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			attributeType := schema.AttributeType(name)
+			if attributeType == nil {
+				// values of attribute types no longer in the schema are only returned in bulk
+				if operational && selection.allOperational || !operational && selection.allUser {
+					result = append(result, Attribute{Type: name, Values: attributes[name]})
+				}
+				continue
+			}
+			if selection.Selects(schema, attributeType) {
+				result = append(result, Attribute{Type: name, Values: attributes[name]})
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"testing"
+)
+
+type selectionTest struct {
+	attributes []string
+	expected   []string
+}
+
+var selectionTests = []selectionTest{
+	{[]string{}, []string{ObjectClassAttribute, CommonNameAttribute, SurnameAttribute}},
+	{[]string{AllUserAttributes}, []string{ObjectClassAttribute, CommonNameAttribute, SurnameAttribute}},
+	{[]string{AllOperationalAttributes}, []string{CreateTimestampAttribute}},
+	{[]string{AllUserAttributes, AllOperationalAttributes},
+		[]string{ObjectClassAttribute, CommonNameAttribute, SurnameAttribute, CreateTimestampAttribute}},
+	{[]string{NoAttributes}, []string{}},
+	{[]string{"2.5.4.3", "CREATETIMESTAMP"}, []string{CommonNameAttribute, CreateTimestampAttribute}},
+	{[]string{NameAttribute}, []string{CommonNameAttribute, SurnameAttribute}},
+	{[]string{"sn;lang-en", "undefinedAttribute"}, []string{SurnameAttribute}},
+}
+
+func TestAttributeSelection(t *testing.T) {
+	schema := newTestSchema()
+	entry := newTestEntry()
+	for _, test := range selectionTests {
+		attributes := NewAttributeSelection(schema, test.attributes).Attributes(schema, entry)
+		types := []string{}
+		for _, attribute := range attributes {
+			types = append(types, attribute.Type)
+		}
+		if len(types) != len(test.expected) {
+			t.Error("For", test, "got", types)
+			continue
+		}
+		for i := range types {
+			if types[i] != test.expected[i] {
+				t.Error("For", test, "got", types)
+				break
+			}
+		}
+	}
+}
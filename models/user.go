@@ -3,6 +3,8 @@ package models
 import (
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -12,20 +14,29 @@ import (
 )
 
 const (
+	// DefaultPasswordScheme is the scheme of the passwords set without specifying one
+	DefaultPasswordScheme = "ARGON2"
 	// HashIterations is the number of iterations (used by PBKDF2)
 	hashIterations = 4096
 	// HashKeyLength is the desired derived key length (used by PBKDF2)
 	hashKeyLength = 32
 	saltSize      = 16
+	// generatedPasswordSize is the number of random bytes of a generated password
+	generatedPasswordSize = 12
 )
 
 // User model in the DB
 type User struct {
-	Id           int64
-	Created      int64
-	Username     string
+	Id       int64
+	Created  int64
+	Username string
+	// PasswordHash holds either a password value prefixed by its scheme, see HashPassword, or
+	// the legacy PBKDF2-SHA1 hash of the password salted with PasswordSalt
 	PasswordHash string
 	PasswordSalt string
+	// ScramSHA1 & ScramSHA256 hold the SCRAM keys of the password, see ScramKeys.String
+	ScramSHA1   string
+	ScramSHA256 string
 }
 
 // CreateUser creates a User with the specified username and password
@@ -40,6 +51,10 @@ func CreateUser(username string, password string) User {
 
 // ComparePassword compares the password with the user's hash and salt
 func (user *User) ComparePassword(password string) (result bool, err error) {
+	if !user.hasLegacyPassword() {
+		return ComparePasswordValues([]string{user.PasswordHash}, password), nil
+	}
+
 	salt, err := base64.StdEncoding.DecodeString(user.PasswordSalt)
 	if err != nil {
 		return false, fmt.Errorf("DecodeString failed: %v", err)
@@ -49,22 +64,95 @@ func (user *User) ComparePassword(password string) (result bool, err error) {
 	expecting := base64.StdEncoding.EncodeToString(passwordHash)
 	actual := user.PasswordHash
 
-	return expecting == actual, nil
+	return subtle.ConstantTimeCompare([]byte(expecting), []byte(actual)) == 1, nil
 }
 
-// SetPassword sets the password hash and salt on a user
+// SetPassword sets the password hash on a user using the default scheme
 func (user *User) SetPassword(password string) error {
+	return user.SetHashedPassword(DefaultPasswordScheme, password)
+}
+
+// SetHashedPassword sets the password hash on a user using scheme, discarding the SCRAM
+// keys of the previous password
+func (user *User) SetHashedPassword(scheme string, password string) error {
+	passwordHash, err := HashPassword(scheme, password)
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = passwordHash
+	user.PasswordSalt = ""
+	user.ScramSHA1, user.ScramSHA256 = "", ""
+	return nil
+}
+
+// SetScramKeys sets the SCRAM keys of password on a user, which must be its current password
+// the keys are only as hard to attack as PBKDF2 with ScramIterations, see ScramIterations
+func (user *User) SetScramKeys(password string) error {
 	salt, err := generateSalt()
 	if err != nil {
 		return fmt.Errorf("generateSalt failed: %v", err)
 	}
-
-	passwordHash := pbkdf2.Key([]byte(password), salt, hashIterations, hashKeyLength, sha1.New)
-	user.PasswordHash = base64.StdEncoding.EncodeToString(passwordHash)
-	user.PasswordSalt = base64.StdEncoding.EncodeToString(salt)
+	user.ScramSHA1 = NewScramKeys(sha1.New, password, salt, ScramIterations).String()
+	user.ScramSHA256 = NewScramKeys(sha256.New, password, salt, ScramIterations).String()
 	return nil
 }
 
+// HasScramKeys returns true if user holds the keys of every SCRAM mechanism, derived with at
+// least ScramIterations
+func (user *User) HasScramKeys() bool {
+	for _, value := range []string{user.ScramSHA1, user.ScramSHA256} {
+		if keys, err := ParseScramKeys(value); err != nil || keys.Iterations < ScramIterations {
+			return false
+		}
+	}
+	return true
+}
+
+// HasWeakPassword returns true if the password of user is held as a legacy PBKDF2-SHA1 hash
+// or hashed with a weak scheme
+func (user *User) HasWeakPassword() bool {
+	return user.hasLegacyPassword() || IsWeakPasswordValue(user.PasswordHash)
+}
+
+// hasLegacyPassword returns true if the password hash of user is salted with PasswordSalt
+func (user *User) hasLegacyPassword() bool {
+	return user.PasswordSalt != ""
+}
+
+// ScramKeys returns the SCRAM keys of the password of user for mechanism, nil if unavailable
+// SCRAM-SHA-1 keys can be derived from the legacy password hash of users without stored keys:
+// the first 20 bytes of the PBKDF2-SHA1 hash are the SCRAM-SHA-1 SaltedPassword
+func (user *User) ScramKeys(mechanism string) (*ScramKeys, error) {
+	switch {
+	case mechanism == ScramSHA1 && user.ScramSHA1 != "":
+		return ParseScramKeys(user.ScramSHA1)
+	case mechanism == ScramSHA256 && user.ScramSHA256 != "":
+		return ParseScramKeys(user.ScramSHA256)
+	case mechanism != ScramSHA1 || !user.hasLegacyPassword():
+		return nil, nil
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(user.PasswordSalt)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeString failed: %v", err)
+	}
+	passwordHash, err := base64.StdEncoding.DecodeString(user.PasswordHash)
+	if err != nil || len(passwordHash) < sha1.Size {
+		return nil, fmt.Errorf("Invalid password hash for %s", user.Username)
+	}
+	return newScramKeysFromSaltedPassword(sha1.New, passwordHash[:sha1.Size], salt, hashIterations), nil
+}
+
+// GeneratePassword returns a random password
+func GeneratePassword() (string, error) {
+	password := make([]byte, generatedPasswordSize)
+	if _, err := io.ReadFull(rand.Reader, password); err != nil {
+		return "", fmt.Errorf("GeneratePassword failed: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(password), nil
+}
+
 func generateSalt() (result []byte, err error) {
 	salt := make([]byte, saltSize)
 	_, err = io.ReadFull(rand.Reader, salt)
@@ -34,14 +34,92 @@ func TestComparePassword(t *testing.T) {
 	}
 }
 
-func comparePassword(user User, password string) bool {
-	salt, err := base64.StdEncoding.DecodeString(user.PasswordSalt)
+func TestGeneratePassword(t *testing.T) {
+	first, err := GeneratePassword()
 	if err != nil {
-		log.Fatalln("DecodeString failed", err)
+		t.Fatal("GeneratePassword failed:", err)
+	}
+	second, _ := GeneratePassword()
+	if first == "" || first == second {
+		t.Error("GeneratePassword returned", first, "then", second)
+	}
+}
+
+func TestLegacyPassword(t *testing.T) {
+	user := legacyUser("username", "password")
+	if match, err := user.ComparePassword("password"); err != nil || !match {
+		t.Error("For", user, "ComparePassword returned", match, err)
+	}
+	if match, _ := user.ComparePassword("wrong"); match {
+		t.Error("For", user, "ComparePassword matched a wrong password")
+	}
+	if !user.HasWeakPassword() {
+		t.Error("For", user, "expected a weak password")
 	}
 
+	user.SetPassword("password")
+	if user.HasWeakPassword() || !comparePassword(user, "password") {
+		t.Error("For", user, "expected the password to be rehashed")
+	}
+}
+
+func TestUserScramKeys(t *testing.T) {
+	user := CreateUser("username", "password")
+	if user.HasScramKeys() {
+		t.Error("Expected no SCRAM keys unless set")
+	}
+	user.SetScramKeys("password")
+	if !user.HasScramKeys() {
+		t.Error("Expected SCRAM keys once set")
+	}
+
+	for _, mechanism := range []string{ScramSHA1, ScramSHA256} {
+		keys, err := user.ScramKeys(mechanism)
+		if err != nil || keys == nil {
+			t.Fatal("ScramKeys failed for", mechanism, err)
+		}
+		if !keys.Verify(ScramHash(mechanism), "password") {
+			t.Error("For", mechanism, "keys", keys, "do not verify the password")
+		}
+	}
+
+	// the keys of the previous password are discarded
+	user.SetPassword("changed")
+	if keys, _ := user.ScramKeys(ScramSHA256); keys != nil {
+		t.Error("Expected the SCRAM keys to be discarded, got", keys)
+	}
+
+	// SCRAM-SHA-1 keys are derived from the legacy password hash when not stored
+	user = legacyUser("username", "password")
+	salt, _ := base64.StdEncoding.DecodeString(user.PasswordSalt)
+	expected := NewScramKeys(sha1.New, "password", salt, hashIterations)
+	if keys, _ := user.ScramKeys(ScramSHA1); keys == nil || keys.String() != expected.String() {
+		t.Error("Expected", expected, "got", keys)
+	}
+	if keys, _ := user.ScramKeys(ScramSHA256); keys != nil {
+		t.Error("Expected no SCRAM-SHA-256 keys, got", keys)
+	}
+}
+
+// legacyUser returns a user whose password is held as a PBKDF2-SHA1 hash without SCRAM keys
+func legacyUser(username string, password string) User {
+	salt, err := generateSalt()
+	if err != nil {
+		log.Fatalln("generateSalt failed", err)
+	}
 	passwordHash := pbkdf2.Key([]byte(password), salt, hashIterations, hashKeyLength, sha1.New)
-	exptected := base64.StdEncoding.EncodeToString(passwordHash)
+	return User{
+		Username:     username,
+		PasswordHash: base64.StdEncoding.EncodeToString(passwordHash),
+		PasswordSalt: base64.StdEncoding.EncodeToString(salt),
+	}
+}
 
-	return exptected == user.PasswordHash
+func comparePassword(user User, password string) bool {
+	scheme, _, err := ParsePasswordValue(user.PasswordHash)
+	if err != nil {
+		log.Fatalln("ParsePasswordValue failed", err)
+	}
+	return scheme == DefaultPasswordScheme && user.PasswordSalt == "" &&
+		ComparePasswordValues([]string{user.PasswordHash}, password)
 }
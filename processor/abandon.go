@@ -0,0 +1,23 @@
+package processor
+
+import (
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+func init() {
+	requestProcessors = append(requestProcessors,
+		requestProcessor{
+			ldapCode: ldap.ApplicationAbandonRequest,
+			handler:  handleAbandonRequest,
+			inline:   true,
+		})
+}
+
+// handleAbandonRequest stops the identified operation, there is no response
+// https://tools.ietf.org/html/rfc4511#section-4.11
+func handleAbandonRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	// AbandonRequest ::= [APPLICATION 16] MessageID
+	session.abandonOperation(ber.DecodeInteger(request.Data.Bytes()))
+	return nil
+}
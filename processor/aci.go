@@ -0,0 +1,210 @@
+package processor
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/ldap"
+)
+
+// accessControls returns the access control instructions held by the aci values of the
+// directory, loading them on first use
+// aci values that cannot be parsed are logged and ignored, denying the rights they grant
+func (proc *Processor) accessControls() (models.AccessControls, error) {
+	proc.aciLock.Lock()
+	defer proc.aciLock.Unlock()
+	if proc.acis != nil {
+		return proc.acis, nil
+	}
+
+	schema, err := proc.getSchema()
+	if err != nil {
+		return nil, err
+	}
+	acis := models.AccessControls{}
+	aciType := schema.AttributeType(models.ACIAttribute)
+	if aciType == nil {
+		proc.acis = acis
+		return acis, nil
+	}
+
+	filter := &models.Filter{Type: models.FilterPresent, Attribute: models.ACIAttribute}
+	err = proc.searchEntries(context.Background(), models.DN{}, datacontext.ScopeWholeSubtree, schema, filter,
+		func(entry *models.Entry) error {
+			holder, err := models.ParseDN(entry.DN)
+			if err != nil {
+				return nil
+			}
+			for _, value := range entry.Values(aciType) {
+				aci, err := models.ParseACI(holder, value)
+				if err != nil {
+					log.Println("Ignoring invalid aci of", entry.DN+":", value)
+					continue
+				}
+				acis = append(acis, aci)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	proc.acis = acis
+	return acis, nil
+}
+
+// invalidateAccessControls discards the loaded access control instructions once a write
+// operation may have modified them
+func (proc *Processor) invalidateAccessControls() {
+	proc.aciLock.Lock()
+	defer proc.aciLock.Unlock()
+	proc.acis = nil
+}
+
+// restrictedAttributes are never disclosed to clients other than the administrator, whatever
+// the access control instructions
+var restrictedAttributes = []string{models.PwdHistoryAttribute, models.AuthPasswordAttribute}
+
+// accessChecker evaluates the access control instructions for the client of a session
+// during an operation, a nil accessChecker allows everything
+type accessChecker struct {
+	proc   *Processor
+	schema *models.Schema
+	// enforced is false if access control is not enforced, only the restricted attributes
+	// are then denied
+	enforced  bool
+	acis      models.AccessControls
+	requester *models.Requester
+	// groups caches the membership of the client in the groups evaluated, keyed by path
+	groups map[string]bool
+}
+
+// newAccessChecker returns the access checker of an operation of session, nil if the session
+// is authenticated as the administrator
+func (session *Session) newAccessChecker(schema *models.Schema) (*accessChecker, error) {
+	boundDN := session.BoundDN()
+	if session.isRootDN(boundDN) {
+		return nil, nil
+	}
+	checker := &accessChecker{proc: session.Processor, schema: schema, groups: map[string]bool{}}
+	if !session.AccessControl {
+		return checker, nil
+	}
+	acis, err := session.accessControls()
+	if err != nil {
+		return nil, err
+	}
+
+	checker.enforced, checker.acis = true, acis
+	checker.requester = &models.Requester{
+		Address:  net.ParseIP(session.clientAddress()),
+		IsMember: checker.isMember,
+	}
+	if boundDN != "" {
+		if checker.requester.DN, err = models.ParseDN(boundDN); err != nil {
+			return nil, err
+		}
+	}
+	return checker, nil
+}
+
+// isMember returns true if the member or uniqueMember values of the group entry named
+// group include the client, errors are logged and deny membership
+func (checker *accessChecker) isMember(group models.DN) bool {
+	if member, found := checker.groups[group.Path()]; found {
+		return member
+	}
+
+	member := false
+	entries, err := checker.proc.selectEntriesByDN(group)
+	if err != nil {
+		log.Println("Loading group", group.String(), "failed:", err)
+	}
+	for _, entry := range entries {
+		for _, name := range []string{models.MemberAttribute, models.UniqueMemberAttribute} {
+			attributeType := checker.schema.AttributeType(name)
+			if attributeType == nil {
+				continue
+			}
+			for _, value := range entry.Values(attributeType) {
+				if dn, err := models.ParseDN(value); err == nil && dn.Equal(checker.requester.DN) {
+					member = true
+				}
+			}
+		}
+	}
+	checker.groups[group.Path()] = member
+	return member
+}
+
+// allows returns true if the client has right on attributeType of entry, or on the entry
+// itself if attributeType is nil
+func (checker *accessChecker) allows(entry *models.Entry, attributeType *models.AttributeType, right models.AccessRight) bool {
+	if checker == nil {
+		return true
+	}
+	if attributeType != nil && right&(models.ReadRight|models.SearchRight|models.CompareRight) != 0 {
+		for _, name := range restrictedAttributes {
+			if attributeType.Name == name {
+				return false
+			}
+		}
+	}
+	if !checker.enforced {
+		return true
+	}
+	dn, err := models.ParseDN(entry.DN)
+	if err != nil {
+		return false
+	}
+	return checker.acis.Allows(checker.schema, checker.requester, dn, entry, attributeType, right)
+}
+
+// require returns an error unless the client has right on attributeType of entry, or on
+// the entry itself if attributeType is nil
+func (checker *accessChecker) require(entry *models.Entry, attributeType *models.AttributeType, right models.AccessRight) error {
+	switch {
+	case checker.allows(entry, attributeType, right):
+		return nil
+	case attributeType == nil:
+		return newLdapError(ldap.LDAPResultInsufficientAccessRights,
+			"Insufficient access rights to %s '%s'", right, entry.DN)
+	}
+	return newLdapError(ldap.LDAPResultInsufficientAccessRights,
+		"Insufficient access rights to %s '%s' of '%s'", right, attributeType.Name, entry.DN)
+}
+
+// canSearch returns true if the client may search the attributes of entry asserted by
+// filter, so that filters cannot disclose the values the client is denied
+func (checker *accessChecker) canSearch(entry *models.Entry, filter *models.Filter) bool {
+	if checker == nil {
+		return true
+	}
+	names := filter.Attributes()
+	if len(names) == 0 {
+		return checker.allows(entry, nil, models.SearchRight)
+	}
+	for _, name := range names {
+		attributeType := checker.schema.AttributeType(name)
+		if attributeType != nil && !checker.allows(entry, attributeType, models.SearchRight) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateACIs checks the syntax of the aci values held by the entry named dn
+func validateACIs(schema *models.Schema, dn models.DN, entry *models.Entry) error {
+	aciType := schema.AttributeType(models.ACIAttribute)
+	if aciType == nil {
+		return nil
+	}
+	for _, value := range entry.Values(aciType) {
+		if _, err := models.ParseACI(dn, value); err != nil {
+			return newLdapError(ldap.LDAPResultInvalidAttributeSyntax, "Invalid aci '%s'", value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/ldap"
+)
+
+func TestAccessChecker(t *testing.T) {
+	schema := newTestSchema()
+	holder, _ := models.ParseDN("dc=example,dc=org")
+	aci, err := models.ParseACI(holder,
+		`(targetattr!="userPassword")(version 3.0; acl "read"; allow (read,search,compare) userdn="ldap:///anyone";)`)
+	if err != nil {
+		t.Fatal("ParseACI failed:", err)
+	}
+	checker := &accessChecker{schema: schema, enforced: true, acis: models.AccessControls{aci}, requester: &models.Requester{}}
+	entry := &models.Entry{
+		DN:         "cn=Test User,cn=Users,dc=example,dc=org",
+		Classes:    models.StringSlice{models.PersonClass},
+		UserValues: models.AttributeValues{models.CommonNameAttribute: []string{"Test User"}},
+	}
+
+	for filter, expected := range map[string]bool{
+		"(cn=Test User)":                    true,
+		"(&(objectClass=person)(cn=Test*))": true,
+		"(userPassword=secret)":             false,
+		"(|(cn=x)(userPassword=secret))":    false,
+	} {
+		parsed, err := models.ParseFilter(filter)
+		if err != nil {
+			t.Fatal("ParseFilter failed:", filter, err)
+		}
+		if checker.canSearch(entry, parsed) != expected {
+			t.Error("For", filter, "expected canSearch", expected)
+		}
+	}
+
+	userPassword := schema.AttributeType(models.UserPasswordAttribute)
+	if ldapErr, ok := checker.require(entry, userPassword, models.CompareRight).(*ldapError); !ok ||
+		ldapErr.result != ldap.LDAPResultInsufficientAccessRights {
+		t.Error("Expected insufficientAccessRights comparing userPassword")
+	}
+	if err := checker.require(entry, schema.AttributeType(models.CommonNameAttribute), models.CompareRight); err != nil {
+		t.Error("Expected compare on cn to be allowed, got", err)
+	}
+
+	var unchecked *accessChecker
+	if err := unchecked.require(entry, userPassword, models.WriteRight); err != nil {
+		t.Error("Expected a nil checker to allow everything, got", err)
+	}
+
+	// the restricted attributes are withheld even if access control is not enforced
+	unenforced := &accessChecker{schema: schema}
+	if !unenforced.allows(entry, userPassword, models.ReadRight) {
+		t.Error("Expected reading userPassword to be allowed when access control is not enforced")
+	}
+	for _, name := range restrictedAttributes {
+		attributeType := schema.AttributeType(name)
+		if unenforced.allows(entry, attributeType, models.ReadRight) || checker.allows(entry, attributeType, models.CompareRight) {
+			t.Error("Expected", name, "to be withheld")
+		}
+	}
+	parsed, _ := models.ParseFilter("(pwdHistory=*)")
+	if unenforced.canSearch(entry, parsed) {
+		t.Error("Expected filters on pwdHistory to be refused")
+	}
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+func init() {
+	requestProcessors = append(requestProcessors,
+		requestProcessor{
+			ldapCode: ldap.ApplicationAddRequest,
+			handler:  handleAddRequest,
+		})
+}
+
+func handleAddRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	if err := session.checkAnonymousAccess(); err != nil {
+		return session.sendLdapResult(messageID, ldap.ApplicationAddResponse, err)
+	}
+	err := session.processAddRequest(request)
+	if err == nil {
+		session.invalidateAccessControls()
+	}
+	return session.sendLdapResult(messageID, ldap.ApplicationAddResponse, err)
+}
+
+func (session *Session) processAddRequest(request *ber.Packet) error {
+	if len(request.Children) != 2 {
+		return newLdapError(ldap.LDAPResultProtocolError, "Malformed AddRequest")
+	}
+
+	dn, err := models.ParseDN(request.Children[0].ValueString())
+	if err != nil || dn.IsEmpty() {
+		return newLdapError(ldap.LDAPResultInvalidDNSyntax, "Invalid DN '%s'", request.Children[0].ValueString())
+	}
+
+	attributes, err := decodeAttributeList(request.Children[1])
+	if err != nil {
+		return err
+	}
+
+	schema, err := session.getSchema()
+	if err != nil {
+		return err
+	}
+
+	entry, err := newEntry(schema, dn, attributes)
+	if err != nil {
+		return err
+	}
+	now := models.GeneralizedTime(time.Now())
+	entry.OperValues[models.CreateTimestampAttribute] = []string{now}
+	entry.OperValues[models.ModifyTimestampAttribute] = []string{now}
+
+	// adding an entry holding access control instructions also requires the right to write them
+	checker, err := session.newAccessChecker(schema)
+	if err != nil {
+		return err
+	}
+	if err = checker.require(entry, nil, models.AddRight); err != nil {
+		return err
+	}
+	if aciType := schema.AttributeType(models.ACIAttribute); aciType != nil && len(entry.Values(aciType)) > 0 {
+		if err = checker.require(entry, aciType, models.WriteRight); err != nil {
+			return err
+		}
+	}
+
+	if err = session.hashPasswordValues(schema, entry, nil); err != nil {
+		return err
+	}
+
+	existing, err := session.selectEntriesByDN(dn)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return newLdapError(ldap.LDAPResultEntryAlreadyExists, "Entry already exists")
+	}
+
+	if !entry.Parent.Valid {
+		return newLdapError(ldap.LDAPResultUnwillingToPerform, "Cannot add a naming context")
+	}
+	parents, err := session.selectEntriesByDN(dn.Parent())
+	if err != nil {
+		return err
+	}
+	if len(parents) == 0 {
+		return session.noSuchObjectError(dn.Parent())
+	}
+
+	switch err = session.DC.InsertEntry(entry); err {
+	case datacontext.ErrEntryAlreadyExists:
+		return newLdapError(ldap.LDAPResultEntryAlreadyExists, "Entry already exists")
+	case datacontext.ErrNoSuchParent:
+		return session.noSuchObjectError(dn.Parent())
+	}
+	return err
+}
@@ -1,81 +1,335 @@
 package processor
 
 import (
+	"errors"
 	"log"
+	"time"
 
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
 	"github.com/mavricknz/asn1-ber"
 	"github.com/mavricknz/ldap"
 )
 
+// tags of the AuthenticationChoice of a BindRequest
+const (
+	authSimple = 0
+	authSasl   = 3
+)
+
+// errPasswordModified aborts the rehash of a password value modified since the bind
+var errPasswordModified = errors.New("Password modified")
+
 func init() {
 	requestProcessors = append(requestProcessors,
 		requestProcessor{
-			ldapCode: ldap.ApplicationBindRequest,
-			handler:  handleBindRequest,
+			ldapCode:  ldap.ApplicationBindRequest,
+			handler:   handleBindRequest,
+			exclusive: true,
 		})
 }
 
-func handleBindRequest(proc *Processor, messageID uint64, request *ber.Packet) error {
-	response, result, err := proc.getBindResponse(messageID, request)
+func handleBindRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	// the connection is unauthenticated until the bind succeeds
+	session.setBoundDN("")
+
+	// BindRequest ::= [APPLICATION 0] SEQUENCE {
+	//      version                 INTEGER (1 ..  127),
+	//      name                    LDAPDN,
+	//      authentication          AuthenticationChoice }
+	// AuthenticationChoice ::= CHOICE {
+	//      simple                  [0] OCTET STRING,
+	//      sasl                    [3] SaslCredentials,
+	//      ...  }
+	if len(request.Children) != 3 || request.Children[2].ClassType != ber.ClassContext {
+		return session.sendBindResult(messageID, newLdapError(ldap.LDAPResultProtocolError, "Malformed BindRequest"), nil)
+	}
+
+	var response *ber.Packet
+	var boundDN string
+	var controls []responseControl
+	var err error
+	switch auth := request.Children[2]; auth.Tag {
+	case authSimple:
+		// a simple bind aborts any SASL bind in progress
+		session.abortSaslBind()
+		response, boundDN, controls, err = session.getBindResponse(messageID, session.clientAddress(), request)
+	case authSasl:
+		response, boundDN, err = session.getSaslBindResponse(messageID, auth)
+	default:
+		session.abortSaslBind()
+		return session.sendBindResult(messageID, newLdapError(ldap.LDAPResultAuthMethodNotSupported,
+			"Authentication method %d not supported", auth.Tag), nil)
+	}
 	if err != nil {
 		return err
 	}
 
-	if result != ldap.LDAPResultSuccess {
-		defer proc.conn.Close()
+	if boundDN != "" {
+		session.setBoundDN(boundDN)
 	}
-	proc.sendLdapResponse(response)
+	session.sendLdapResponse(session.withResponseControls(messageID, response, controls...))
 
 	return nil
 }
 
-func (proc *Processor) getBindResponse(messageID uint64, request *ber.Packet) (response *ber.Packet, result int, err error) {
-	username := request.Children[1].ValueString()
+// getBindResponse authenticates a simple bind by client, returning the response along with
+// the identity established, empty unless the bind succeeded, and the response controls
+func (proc *Processor) getBindResponse(messageID uint64, client string, request *ber.Packet) (response *ber.Packet, boundDN string, controls []responseControl, err error) {
+	name := request.Children[1].ValueString()
 	auth := request.Children[2]
 	password := auth.Data.String()
-	result = ldap.LDAPResultProtocolError
 
-	users, err := proc.DC.SelectUsersByUsername(username)
+	var result int
+	switch {
+	case name == "" && password == "":
+		result = proc.authenticateAnonymous()
+	case password == "":
+		result = proc.authenticateUnauthenticated(name)
+	default:
+		result, boundDN, controls, err = proc.authenticatePassword(client, name, password)
+	}
 	if err != nil {
-		return nil, result, err
+		return nil, "", nil, err
 	}
 
-	if len(users) == 1 {
-		log.Println("User found:", username)
+	switch {
+	case result != ldap.LDAPResultSuccess:
+		log.Println("Bind failed for:", name)
+		boundDN = ""
+	case boundDN == "":
+		log.Println("Anonymous bind")
+	default:
+		log.Println("Password valid for:", name)
+	}
+	response = proc.buildBindResponse(messageID, result)
 
-		match, err := users[0].ComparePassword(password)
-		switch {
-		case err != nil:
-			return nil, result, err
-		case match:
-			log.Println("Password for user valid:", username)
-			result = ldap.LDAPResultSuccess
-		default:
-			log.Println("Password for user invalid:", username)
-			result = ldap.LDAPResultInvalidCredentials
+	return response, boundDN, controls, nil
+}
+
+// authenticatePassword checks the password of the administrator or entry named name for
+// client, returning the identity established along with the password policy response controls
+// binds refused by the bind throttle are not checked
+func (proc *Processor) authenticatePassword(client string, name string, password string) (result int, boundDN string, controls []responseControl, err error) {
+	if name == "" {
+		return ldap.LDAPResultInvalidCredentials, "", nil, nil
+	}
+	if throttled := proc.throttleBind(client, name); throttled != nil {
+		return throttled.result, "", nil, nil
+	}
+
+	if proc.isRootDN(name) {
+		result, err = proc.authenticateRootDN(password)
+		boundDN = proc.RootDN
+	} else {
+		result, boundDN, controls, err = proc.authenticateEntry(name, password)
+	}
+	if err == nil {
+		proc.recordBind(client, name, result)
+	}
+	return result, boundDN, controls, err
+}
+
+// authenticateAnonymous returns the result of an anonymous bind (empty name & password)
+// https://tools.ietf.org/html/rfc4513#section-5.1.1
+func (proc *Processor) authenticateAnonymous() int {
+	if proc.DisallowAnonymous {
+		return ldap.LDAPResultInappropriateAuthentication
+	}
+	return ldap.LDAPResultSuccess
+}
+
+// authenticateUnauthenticated returns the result of an unauthenticated bind (a name with
+// an empty password), which is treated as anonymous only if explicitly allowed
+// https://tools.ietf.org/html/rfc4513#section-5.1.2
+func (proc *Processor) authenticateUnauthenticated(name string) int {
+	if !proc.AllowUnauthenticated {
+		return ldap.LDAPResultUnwillingToPerform
+	}
+	log.Println("Unauthenticated bind for:", name)
+	return proc.authenticateAnonymous()
+}
+
+// authenticateRootDN checks password against the administrator held in the users table
+func (proc *Processor) authenticateRootDN(password string) (result int, err error) {
+	users, err := proc.DC.SelectAdminUsers()
+	if err != nil {
+		return ldap.LDAPResultOther, err
+	}
+	if len(users) != 1 {
+		return ldap.LDAPResultInvalidCredentials, nil
+	}
+
+	match, err := users[0].ComparePassword(password)
+	switch {
+	case err != nil:
+		return ldap.LDAPResultOther, err
+	case match:
+		if users[0].HasWeakPassword() || proc.Scram && !users[0].HasScramKeys() {
+			proc.rehashRootDNPassword(users[0].User, password)
 		}
+		return ldap.LDAPResultSuccess, nil
+	}
+	return ldap.LDAPResultInvalidCredentials, nil
+}
 
+// rehashRootDNPassword replaces the weak password hash of the administrator (or its missing
+// SCRAM keys) by a hash using the configured scheme, failures are logged as the bind has
+// succeeded regardless
+func (proc *Processor) rehashRootDNPassword(user *models.User, password string) {
+	if err := proc.setRootDNPassword(user, password); err != nil {
+		log.Println("Rehashing the password of", proc.RootDN, "failed:", err)
+		return
+	}
+	if err := proc.DC.UpdateUserPassword(user); err != nil {
+		log.Println("Rehashing the password of", proc.RootDN, "failed:", err)
+		return
+	}
+	log.Println("Password of", proc.RootDN, "rehashed using", proc.passwordScheme())
+}
+
+// setRootDNPassword sets the password of the administrator, along with its SCRAM keys when
+// SCRAM is enabled
+func (proc *Processor) setRootDNPassword(user *models.User, password string) error {
+	if err := user.SetHashedPassword(proc.passwordScheme(), password); err != nil {
+		return err
+	}
+	if proc.Scram {
+		return user.SetScramKeys(password)
+	}
+	return nil
+}
+
+// authenticateEntry checks password against the userPassword values of the entry named
+// name, returning the normalized DN of the entry along with the password policy response
+// controls
+func (proc *Processor) authenticateEntry(name string, password string) (result int, boundDN string, controls []responseControl, err error) {
+	dn, err := models.ParseDN(name)
+	if err != nil {
+		return ldap.LDAPResultInvalidDNSyntax, "", nil, nil
+	}
+
+	schema, err := proc.getSchema()
+	if err != nil {
+		return ldap.LDAPResultOther, "", nil, err
+	}
+
+	entries, err := proc.selectEntriesByDN(dn)
+	if err != nil {
+		return ldap.LDAPResultOther, "", nil, err
+	}
+	userPassword := schema.AttributeType(models.UserPasswordAttribute)
+	// a missing entry is not disclosed
+	if len(entries) == 0 || userPassword == nil {
+		return ldap.LDAPResultInvalidCredentials, "", nil, nil
+	}
+
+	policy, err := proc.passwordPolicy(entries[0].Entry)
+	if err != nil {
+		return ldap.LDAPResultOther, "", nil, err
+	}
+
+	var matched string
+	if policy == nil {
+		values := schema.EntryValues(entries[0].Entry, userPassword)
+		if i := models.MatchPasswordValue(values, password); i >= 0 {
+			matched, result = values[i], ldap.LDAPResultSuccess
+		} else {
+			result = ldap.LDAPResultInvalidCredentials
+		}
 	} else {
-		log.Println("User not found:", username)
-		result = ldap.LDAPResultInvalidCredentials
+		// the outcome is recorded in the entry, which is locked meanwhile
+		var response *passwordPolicyResponse
+		err = proc.modifyEntry(dn, func(entry *models.Entry) error {
+			values := schema.EntryValues(entry, userPassword)
+			matched, result, response = checkPasswordPolicy(policy, values, entry, password, time.Now())
+			return nil
+		})
+		switch {
+		case err == datacontext.ErrNoSuchEntry:
+			return ldap.LDAPResultInvalidCredentials, "", nil, nil
+		case err != nil:
+			return ldap.LDAPResultOther, "", nil, err
+		}
+		if response.policyError >= 0 {
+			log.Println("Password policy", policy.DN, "refused bind for:", dn.String(), "error", response.policyError)
+		}
+		controls = response.control()
 	}
 
-	response = proc.buildBindResponse(messageID, result)
+	if result != ldap.LDAPResultSuccess {
+		return result, "", controls, nil
+	}
+	if models.IsWeakPasswordValue(matched) || proc.Scram && !proc.hasAuthPassword(schema, entries[0].Entry) {
+		proc.rehashEntryPassword(dn, schema, matched, password)
+	}
+	return ldap.LDAPResultSuccess, dn.String(), controls, nil
+}
 
-	return
+// hasAuthPassword returns true if entry holds the SCRAM keys of every mechanism
+func (proc *Processor) hasAuthPassword(schema *models.Schema, entry *models.Entry) bool {
+	authPassword := schema.AttributeType(models.AuthPasswordAttribute)
+	return authPassword == nil || models.HasAuthPasswordScramKeys(schema.EntryValues(entry, authPassword))
 }
 
-func (proc *Processor) buildBindResponse(messageID uint64, ldapResult int) *ber.Packet {
-	ldapResponse := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
-	ldapResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, messageID, "MessageID"))
+// rehashEntryPassword replaces the weak userPassword value of the entry named dn by a hash of
+// password using the configured scheme, storing its SCRAM keys when SCRAM is enabled, the
+// value is left untouched if it has been modified meanwhile, failures are logged as the bind
+// has succeeded regardless
+func (proc *Processor) rehashEntryPassword(dn models.DN, schema *models.Schema, value string, password string) {
+	rehashed := value
+	if models.IsWeakPasswordValue(value) {
+		var err error
+		if rehashed, err = models.HashPassword(proc.passwordScheme(), password); err != nil {
+			log.Println("Rehashing the password of", dn.String(), "failed:", err)
+			return
+		}
+	}
 
-	bindResponse := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindResponse, nil, "Bind Response")
-	bindResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagEnumerated, uint64(ldapResult), "LDAP Result"))
+	userPassword := schema.AttributeType(models.UserPasswordAttribute)
+	err := proc.modifyEntry(dn, func(entry *models.Entry) error {
+		values := append([]string{}, entry.Values(userPassword)...)
+		for i := range values {
+			if values[i] == value {
+				values[i] = rehashed
+				entry.SetValues(userPassword, values)
+				return proc.setAuthPassword(schema, entry, password)
+			}
+		}
+		return errPasswordModified
+	})
+	switch {
+	case err == nil && rehashed == value:
+		log.Println("SCRAM keys of", dn.String(), "stored")
+	case err == nil:
+		log.Println("Password of", dn.String(), "rehashed using", proc.passwordScheme())
+	case err == errPasswordModified:
+	default:
+		log.Println("Rehashing the password of", dn.String(), "failed:", err)
+	}
+}
 
-	bindResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "", "Matched DN"))
-	bindResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "", "Error Message"))
+func (proc *Processor) buildBindResponse(messageID uint64, ldapResult int) *ber.Packet {
+	return buildSaslBindResponse(messageID, &ldapError{result: ldapResult}, nil)
+}
 
-	ldapResponse.AppendChild(bindResponse)
+// buildSaslBindResponse builds a BindResponse describing result along with the optional
+// serverSaslCreds
+func buildSaslBindResponse(messageID uint64, result *ldapError, serverSaslCreds []byte) *ber.Packet {
+	// BindResponse ::= [APPLICATION 1] SEQUENCE {
+	//      COMPONENTS OF LDAPResult,
+	//      serverSaslCreds    [7] OCTET STRING OPTIONAL }
+	bindResponse := newLdapResult(ldap.ApplicationBindResponse, result)
+	if serverSaslCreds != nil {
+		bindResponse.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimative, 7, string(serverSaslCreds), "Server SASL Credentials"))
+	}
+	return buildLdapMessage(messageID, bindResponse)
+}
 
-	return ldapResponse
+// sendBindResult sends a BindResponse describing err along with the optional serverSaslCreds
+// errors other than ldapError are reported to the client as "other" and returned
+func (session *Session) sendBindResult(messageID uint64, err error, serverSaslCreds []byte) error {
+	result, err := resultOf(err)
+	session.sendLdapResponse(buildSaslBindResponse(messageID, result, serverSaslCreds))
+	return err
 }
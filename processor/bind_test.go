@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
 	"github.com/mavricknz/asn1-ber"
 	"github.com/mavricknz/ldap"
 )
@@ -19,19 +20,36 @@ type credentials struct {
 	result   int
 }
 
+const rootDN = "cn=admin,dc=example,dc=org"
+
 var testCredentials = []credentials{
-	{"admin", "admin", ldap.LDAPResultSuccess},
-	{"admin", "admin2", ldap.LDAPResultInvalidCredentials},
-	{"admin2", "admin", ldap.LDAPResultInvalidCredentials},
+	{rootDN, "admin", ldap.LDAPResultSuccess},
+	{"CN=Admin, dc=example,dc=org", "admin", ldap.LDAPResultSuccess},
+	{rootDN, "admin2", ldap.LDAPResultInvalidCredentials},
+	{"cn=admin2,dc=example,dc=org", "admin", ldap.LDAPResultInvalidCredentials},
+	{"cn=Test User,cn=Users,dc=example,dc=org", "password", ldap.LDAPResultSuccess},
+	{"cn=Test User,cn=Users,dc=example,dc=org", "password2", ldap.LDAPResultInvalidCredentials},
+	{"cn=Test User2,cn=Users,dc=example,dc=org", "password", ldap.LDAPResultInvalidCredentials},
+	{"cn=Test User,cn=Users,dc=example,dc=org", "", ldap.LDAPResultUnwillingToPerform},
+	{"", "", ldap.LDAPResultSuccess},
+	{"", "password", ldap.LDAPResultInvalidCredentials},
+	{"admin", "admin", ldap.LDAPResultInvalidDNSyntax},
 }
 
 var dc = &datacontext.DataContext{DBName: dbname, DBUser: dbuser}
-var proc = &Processor{DC: dc}
+var proc = &Processor{DC: dc, RootDN: rootDN}
 
 func TestMain(t *testing.T) {
 	dc.InitDb()
 	defer dc.CloseDb()
 	dc.SeedDb()
+
+	// the Test User is seeded without password
+	testUserDN, _ := models.ParseDN("cn=Test User,cn=Users,dc=example,dc=org")
+	dc.ModifyEntry(testUserDN, func(entry *models.Entry) error {
+		entry.UserValues[models.UserPasswordAttribute] = []string{"password"}
+		return nil
+	})
 }
 
 func TestBuildBindResponse(t *testing.T) {
@@ -56,6 +74,29 @@ func TestGetBindResponse(t *testing.T) {
 	}
 }
 
+func TestAnonymousBind(t *testing.T) {
+	tests := []struct {
+		proc     *Processor
+		username string
+		result   int
+	}{
+		{&Processor{}, "", ldap.LDAPResultSuccess},
+		{&Processor{DisallowAnonymous: true}, "", ldap.LDAPResultInappropriateAuthentication},
+		{&Processor{}, rootDN, ldap.LDAPResultUnwillingToPerform},
+		{&Processor{AllowUnauthenticated: true}, rootDN, ldap.LDAPResultSuccess},
+		{&Processor{AllowUnauthenticated: true, DisallowAnonymous: true}, rootDN, ldap.LDAPResultInappropriateAuthentication},
+	}
+	for _, test := range tests {
+		response, boundDN, _, err := test.proc.getBindResponse(1, "", buildBindRequest(test.username, ""))
+		if err != nil {
+			t.Fatal("getBindResponse failed:", err)
+		}
+		if actual, _ := parseLDAPResult(response); actual != test.result || boundDN != "" {
+			t.Error("For", test.username, "expected", test.result, "got", actual, boundDN)
+		}
+	}
+}
+
 func BenchmarkGetBindResponse(b *testing.B) {
 	dc.OpenDb()
 	defer dc.CloseDb()
@@ -68,7 +109,7 @@ func BenchmarkGetBindResponse(b *testing.B) {
 
 func testGetBindResponse(tb testing.TB, messageID uint64, creds credentials) {
 	request := buildBindRequest(creds.username, creds.password)
-	response, _, _ := proc.getBindResponse(messageID, request)
+	response, _, _, _ := proc.getBindResponse(messageID, "", request)
 	actual, found := parseLDAPResult(response)
 	if !found {
 		tb.Error("BindResponse malformed for", creds)
@@ -0,0 +1,41 @@
+package processor
+
+import (
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+const (
+	// https://tools.ietf.org/html/rfc3909
+	cancelOID = "1.3.6.1.1.8"
+)
+
+func init() {
+	extendedProcessors = append(extendedProcessors,
+		extendedProcessor{
+			oid:       cancelOID,
+			handler:   handleCancelRequest,
+			unlimited: true,
+		})
+}
+
+// handleCancelRequest cancels the operation identified by value
+// cancelRequestValue ::= SEQUENCE { cancelID MessageID }
+func handleCancelRequest(session *Session, messageID uint64, value []byte) error {
+	return session.sendExtendedResult(messageID, session.processCancelRequest(value))
+}
+
+func (session *Session) processCancelRequest(value []byte) error {
+	if len(value) == 0 {
+		return newLdapError(ldap.LDAPResultProtocolError, "Missing cancelRequestValue")
+	}
+	packet := ber.DecodePacket(value)
+	if packet == nil || len(packet.Children) != 1 {
+		return newLdapError(ldap.LDAPResultProtocolError, "Malformed cancelRequestValue")
+	}
+	cancelID, ok := packet.Children[0].Value.(uint64)
+	if !ok {
+		return newLdapError(ldap.LDAPResultProtocolError, "Malformed cancelRequestValue")
+	}
+	return session.cancelOperation(cancelID)
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+func init() {
+	requestProcessors = append(requestProcessors,
+		requestProcessor{
+			ldapCode: ldap.ApplicationCompareRequest,
+			handler:  handleCompareRequest,
+		})
+}
+
+func handleCompareRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	if err := session.checkAnonymousAccess(); err != nil {
+		return session.sendLdapResult(messageID, ldap.ApplicationCompareResponse, err)
+	}
+	// compareTrue and compareFalse are reported through ldapError like any other result
+	err := session.processCompareRequest(request)
+	return session.sendLdapResult(messageID, ldap.ApplicationCompareResponse, err)
+}
+
+func (session *Session) processCompareRequest(request *ber.Packet) error {
+	if len(request.Children) != 2 || len(request.Children[1].Children) != 2 {
+		return newLdapError(ldap.LDAPResultProtocolError, "Malformed CompareRequest")
+	}
+
+	dn, err := models.ParseDN(request.Children[0].ValueString())
+	if err != nil {
+		return newLdapError(ldap.LDAPResultInvalidDNSyntax, "Invalid DN '%s'", request.Children[0].ValueString())
+	}
+	name := request.Children[1].Children[0].ValueString()
+	assertion := request.Children[1].Children[1].ValueString()
+
+	schema, err := session.getSchema()
+	if err != nil {
+		return err
+	}
+	checker, err := session.newAccessChecker(schema)
+	if err != nil {
+		return err
+	}
+
+	entries, err := session.selectEntriesByDN(dn)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return session.noSuchObjectError(dn)
+	}
+
+	attributeType := schema.AttributeType(name)
+	if attributeType == nil {
+		return newLdapError(ldap.LDAPResultUndefinedAttributeType, "Attribute type '%s' undefined", name)
+	}
+	if err = checker.require(entries[0].Entry, attributeType, models.CompareRight); err != nil {
+		return err
+	}
+
+	values := schema.EntryValues(entries[0].Entry, attributeType)
+	if len(values) == 0 {
+		return newLdapError(ldap.LDAPResultNoSuchAttribute, "No such attribute '%s'", name)
+	}
+
+	for _, value := range values {
+		match, err := schema.ValuesMatch(attributeType, value, assertion)
+		switch {
+		case err == models.ErrNoMatchingRule:
+			return newLdapError(ldap.LDAPResultInappropriateMatching, "No equality matching rule for '%s'", name)
+		case err != nil:
+			return newLdapError(ldap.LDAPResultInvalidAttributeSyntax, "Invalid assertion value for '%s'", name)
+		case match:
+			return &ldapError{result: ldap.LDAPResultCompareTrue}
+		}
+	}
+
+	return &ldapError{result: ldap.LDAPResultCompareFalse}
+}
@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"sort"
+
+	"github.com/mavricknz/asn1-ber"
+)
+
+// control is a control attached to a request
+// https://tools.ietf.org/html/rfc4511#section-4.1.11
+type control struct {
+	oid      string
+	critical bool
+	value    []byte
+}
+
+// responseControl is a control attached to a response, only sent to clients that attached
+// a control of the same type to their request
+type responseControl struct {
+	oid   string
+	value *ber.Packet
+}
+
+// supportedControlOIDs holds the OIDs of the controls understood by the server
+var supportedControlOIDs = make([]string, 0)
+
+// supportedControls returns the OIDs of the supported controls
+func supportedControls() []string {
+	oids := append([]string{}, supportedControlOIDs...)
+	sort.Strings(oids)
+	return oids
+}
+
+// unsupportedCriticalControl returns the first critical control of controls which isn't
+// supported by the server, nil if there is none
+func unsupportedCriticalControl(controls []control) *control {
+	for i := range controls {
+		if !controls[i].critical {
+			continue
+		}
+		supported := false
+		for _, oid := range supportedControlOIDs {
+			if oid == controls[i].oid {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return &controls[i]
+		}
+	}
+	return nil
+}
+
+// decodeControls decodes the controls of an LDAPMessage, malformed controls are ignored
+func decodeControls(packet *ber.Packet) []control {
+	// Controls ::= SEQUENCE OF control Control
+	// Control ::= SEQUENCE {
+	//      controlType             LDAPOID,
+	//      criticality             BOOLEAN DEFAULT FALSE,
+	//      controlValue            OCTET STRING OPTIONAL }
+	controls := []control{}
+	for _, child := range packet.Children {
+		if len(child.Children) == 0 {
+			continue
+		}
+		ctrl := control{oid: child.Children[0].ValueString()}
+		for _, field := range child.Children[1:] {
+			switch field.Tag {
+			case ber.TagBoolean:
+				ctrl.critical, _ = field.Value.(bool)
+			case ber.TagOctetString:
+				ctrl.value = field.Data.Bytes()
+			}
+		}
+		controls = append(controls, ctrl)
+	}
+	return controls
+}
+
+// requestControl returns the control oid attached to the request with messageID, nil if absent
+func (session *Session) requestControl(messageID uint64, oid string) *control {
+	op := session.operation(messageID)
+	if op == nil {
+		return nil
+	}
+	for i := range op.controls {
+		if op.controls[i].oid == oid {
+			return &op.controls[i]
+		}
+	}
+	return nil
+}
+
+// withResponseControls appends to the LDAPMessage message the controls requested by the
+// request with messageID
+func (session *Session) withResponseControls(messageID uint64, message *ber.Packet, controls ...responseControl) *ber.Packet {
+	// controls [0] Controls OPTIONAL
+	controlsPacket := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+	for _, ctrl := range controls {
+		if session.requestControl(messageID, ctrl.oid) == nil {
+			continue
+		}
+		controlPacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+		controlPacket.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, ctrl.oid, "Control Type"))
+		if ctrl.value != nil {
+			controlPacket.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString,
+				string(ctrl.value.Bytes()), "Control Value"))
+		}
+		controlsPacket.AppendChild(controlPacket)
+	}
+	if len(controlsPacket.Children) > 0 {
+		message.AppendChild(controlsPacket)
+	}
+	return message
+}
@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+func init() {
+	requestProcessors = append(requestProcessors,
+		requestProcessor{
+			ldapCode: ldap.ApplicationDelRequest,
+			handler:  handleDeleteRequest,
+		})
+}
+
+func handleDeleteRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	if err := session.checkAnonymousAccess(); err != nil {
+		return session.sendLdapResult(messageID, ldap.ApplicationDelResponse, err)
+	}
+	err := session.processDeleteRequest(request)
+	if err == nil {
+		session.invalidateAccessControls()
+	}
+	return session.sendLdapResult(messageID, ldap.ApplicationDelResponse, err)
+}
+
+func (session *Session) processDeleteRequest(request *ber.Packet) error {
+	// DelRequest ::= [APPLICATION 10] LDAPDN
+	dn, err := models.ParseDN(request.Data.String())
+	if err != nil {
+		return newLdapError(ldap.LDAPResultInvalidDNSyntax, "Invalid DN '%s'", request.Data.String())
+	}
+	if dn.IsEmpty() {
+		return newLdapError(ldap.LDAPResultUnwillingToPerform, "Cannot delete the root DSE")
+	}
+	if err = session.checkDeleteAccess(dn); err != nil {
+		return err
+	}
+
+	switch err = session.DC.DeleteEntry(dn); err {
+	case datacontext.ErrNoSuchEntry:
+		return session.noSuchObjectError(dn)
+	case datacontext.ErrNotAllowedOnNonLeaf:
+		return newLdapError(ldap.LDAPResultNotAllowedOnNonLeaf, "Entry has subordinates")
+	}
+	return err
+}
+
+// checkDeleteAccess returns an error unless the client may delete the entry named dn
+func (session *Session) checkDeleteAccess(dn models.DN) error {
+	schema, err := session.getSchema()
+	if err != nil {
+		return err
+	}
+	checker, err := session.newAccessChecker(schema)
+	if err != nil || checker == nil || !checker.enforced {
+		return err
+	}
+	entries, err := session.selectEntriesByDN(dn)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return session.noSuchObjectError(dn)
+	}
+	return checker.require(entries[0].Entry, nil, models.DeleteRight)
+}
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"sort"
+
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+const (
+	// https://tools.ietf.org/html/rfc4511#section-4.4.1
+	noticeOfDisconnectionOID = "1.3.6.1.4.1.1466.20036"
+)
+
+func init() {
+	requestProcessors = append(requestProcessors,
+		requestProcessor{
+			ldapCode: ldap.ApplicationExtendedRequest,
+			handler:  handleExtendedRequest,
+		})
+}
+
+type extendedHandler func(session *Session, messageID uint64, value []byte) error
+
+type extendedProcessor struct {
+	oid     string
+	handler extendedHandler
+	// inline operations (e.g. StartTLS) are processed before any further request is read
+	inline bool
+	// unlimited operations (e.g. Cancel) do not wait for a free slot, so that they can stop
+	// the operations holding the slots
+	unlimited bool
+}
+
+var extendedProcessors = make([]extendedProcessor, 0)
+
+// findExtendedProcessor returns the processor of the extended operation oid, nil if the
+// operation is not supported
+func findExtendedProcessor(oid string) *extendedProcessor {
+	for i := range extendedProcessors {
+		if extendedProcessors[i].oid == oid {
+			return &extendedProcessors[i]
+		}
+	}
+	return nil
+}
+
+// supportedExtensions returns the OIDs of the supported extended operations
+func supportedExtensions() []string {
+	oids := []string{}
+	for _, extProc := range extendedProcessors {
+		oids = append(oids, extProc.oid)
+	}
+	sort.Strings(oids)
+	return oids
+}
+
+// extendedRequestProcessor returns the processor of the extended operation requested by
+// request, nil if the request is malformed or the operation is not supported
+func extendedRequestProcessor(request *ber.Packet) *extendedProcessor {
+	name, _, ok := parseExtendedRequest(request)
+	if !ok {
+		return nil
+	}
+	return findExtendedProcessor(name)
+}
+
+// parseExtendedRequest returns the requestName & requestValue of request
+func parseExtendedRequest(request *ber.Packet) (name string, value []byte, ok bool) {
+	// ExtendedRequest ::= [APPLICATION 23] SEQUENCE {
+	//      requestName      [0] LDAPOID,
+	//      requestValue     [1] OCTET STRING OPTIONAL }
+	if len(request.Children) == 0 || request.Children[0].Tag != 0 {
+		return "", nil, false
+	}
+	name = request.Children[0].Data.String()
+	if len(request.Children) > 1 && request.Children[1].Tag == 1 {
+		value = request.Children[1].Data.Bytes()
+	}
+	return name, value, true
+}
+
+func handleExtendedRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	name, value, ok := parseExtendedRequest(request)
+	if !ok {
+		return session.sendExtendedResult(messageID,
+			newLdapError(ldap.LDAPResultProtocolError, "Malformed ExtendedRequest"))
+	}
+
+	extProc := findExtendedProcessor(name)
+	if extProc == nil {
+		return session.sendExtendedResult(messageID,
+			newLdapError(ldap.LDAPResultProtocolError, "Unsupported extended operation '%s'", name))
+	}
+	return extProc.handler(session, messageID, value)
+}
+
+// sendExtendedResult sends an ExtendedResponse describing err
+func (session *Session) sendExtendedResult(messageID uint64, err error) error {
+	return session.sendLdapResult(messageID, ldap.ApplicationExtendedResponse, err)
+}
+
+// buildExtendedResponse builds an ExtendedResponse describing result along with the
+// optional responseName & responseValue
+func buildExtendedResponse(messageID uint64, result *ldapError, name string, value []byte) *ber.Packet {
+	// ExtendedResponse ::= [APPLICATION 24] SEQUENCE {
+	//      COMPONENTS OF LDAPResult,
+	//      responseName     [10] LDAPOID OPTIONAL,
+	//      responseValue    [11] OCTET STRING OPTIONAL }
+	response := newLdapResult(ldap.ApplicationExtendedResponse, result)
+	if name != "" {
+		response.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimative, 10, name, "Response Name"))
+	}
+	if value != nil {
+		response.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimative, 11, string(value), "Response Value"))
+	}
+	return buildLdapMessage(messageID, response)
+}
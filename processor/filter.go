@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+// decodeFilter decodes a Filter packet of a SearchRequest
+// https://tools.ietf.org/html/rfc4511#section-4.5.1.7
+func decodeFilter(packet *ber.Packet) (*models.Filter, error) {
+	if packet.ClassType != ber.ClassContext || packet.Tag > ldap.FilterExtensibleMatch {
+		return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed filter")
+	}
+
+	filter := &models.Filter{Type: models.FilterType(packet.Tag)}
+
+	switch packet.Tag {
+	case ldap.FilterAnd, ldap.FilterOr, ldap.FilterNot:
+		for _, child := range packet.Children {
+			childFilter, err := decodeFilter(child)
+			if err != nil {
+				return nil, err
+			}
+			filter.Children = append(filter.Children, childFilter)
+		}
+		if packet.Tag == ldap.FilterNot && len(filter.Children) != 1 {
+			return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed not filter")
+		}
+
+	case ldap.FilterPresent:
+		filter.Attribute = packet.Data.String()
+
+	case ldap.FilterSubstrings:
+		if len(packet.Children) != 2 {
+			return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed substrings filter")
+		}
+		filter.Attribute = packet.Children[0].ValueString()
+		for _, substring := range packet.Children[1].Children {
+			switch substring.Tag {
+			case ldap.FilterSubstringsInitial:
+				filter.Initial = substring.Data.String()
+			case ldap.FilterSubstringsAny:
+				filter.Any = append(filter.Any, substring.Data.String())
+			case ldap.FilterSubstringsFinal:
+				filter.Final = substring.Data.String()
+			}
+		}
+
+	case ldap.FilterExtensibleMatch:
+		// MatchingRuleAssertion ::= SEQUENCE { matchingRule [1], type [2], matchValue [3], dnAttributes [4] }
+		for _, child := range packet.Children {
+			switch child.Tag {
+			case 1:
+				filter.MatchingRule = child.Data.String()
+			case 2:
+				filter.Attribute = child.Data.String()
+			case 3:
+				filter.Value = child.Data.String()
+			case 4:
+				filter.DNAttributes = child.Data.Len() > 0 && child.Data.Bytes()[0] != 0
+			}
+		}
+
+	default:
+		// equalityMatch, greaterOrEqual, lessOrEqual & approxMatch are AttributeValueAssertions
+		if len(packet.Children) != 2 {
+			return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed filter assertion")
+		}
+		filter.Attribute = packet.Children[0].ValueString()
+		filter.Value = packet.Children[1].ValueString()
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,225 @@
+package processor
+
+import (
+	"strconv"
+
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+const (
+	// modIncrement is the increment operation of a ModifyRequest change
+	// https://tools.ietf.org/html/rfc4525
+	modIncrement = 3
+)
+
+// modification is a single change of a ModifyRequest
+type modification struct {
+	operation int
+	name      string
+	values    []string
+}
+
+func init() {
+	requestProcessors = append(requestProcessors,
+		requestProcessor{
+			ldapCode: ldap.ApplicationModifyRequest,
+			handler:  handleModifyRequest,
+		})
+}
+
+func handleModifyRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	if err := session.checkAnonymousAccess(); err != nil {
+		return session.sendLdapResult(messageID, ldap.ApplicationModifyResponse, err)
+	}
+	err := session.processModifyRequest(request)
+	if err == nil {
+		session.invalidateAccessControls()
+	}
+	return session.sendLdapResult(messageID, ldap.ApplicationModifyResponse, err)
+}
+
+func (session *Session) processModifyRequest(request *ber.Packet) error {
+	if len(request.Children) != 2 {
+		return newLdapError(ldap.LDAPResultProtocolError, "Malformed ModifyRequest")
+	}
+
+	dn, err := models.ParseDN(request.Children[0].ValueString())
+	if err != nil || dn.IsEmpty() {
+		return newLdapError(ldap.LDAPResultInvalidDNSyntax, "Invalid DN '%s'", request.Children[0].ValueString())
+	}
+
+	modifications, err := decodeModifications(request.Children[1])
+	if err != nil {
+		return err
+	}
+
+	schema, err := session.getSchema()
+	if err != nil {
+		return err
+	}
+	checker, err := session.newAccessChecker(schema)
+	if err != nil {
+		return err
+	}
+
+	err = session.modifyEntry(dn, func(entry *models.Entry) error {
+		for _, mod := range modifications {
+			if attributeType := schema.AttributeType(mod.name); attributeType != nil {
+				if err := checker.require(entry, attributeType, models.WriteRight); err != nil {
+					return err
+				}
+			}
+		}
+		var previous []string
+		if userPassword := schema.AttributeType(models.UserPasswordAttribute); userPassword != nil {
+			previous = append(previous, entry.Values(userPassword)...)
+		}
+		if err := applyModifications(schema, dn, entry, modifications); err != nil {
+			return err
+		}
+		if err := session.checkModifiedPassword(schema, entry, previous); err != nil {
+			return err
+		}
+		return session.hashPasswordValues(schema, entry, previous)
+	})
+	if err == datacontext.ErrNoSuchEntry {
+		return session.noSuchObjectError(dn)
+	}
+	return err
+}
+
+func decodeModifications(packet *ber.Packet) ([]modification, error) {
+	modifications := []modification{}
+	for _, change := range packet.Children {
+		if len(change.Children) != 2 || len(change.Children[1].Children) != 2 {
+			return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed change")
+		}
+		operation, ok := change.Children[0].Value.(uint64)
+		if !ok || operation > modIncrement {
+			return nil, newLdapError(ldap.LDAPResultProtocolError, "Unknown modify operation")
+		}
+		mod := modification{
+			operation: int(operation),
+			name:      change.Children[1].Children[0].ValueString(),
+		}
+		for _, value := range change.Children[1].Children[1].Children {
+			mod.values = append(mod.values, value.ValueString())
+		}
+		modifications = append(modifications, mod)
+	}
+	return modifications, nil
+}
+
+// applyModifications applies all modifications to entry in order
+// entry is left in an undefined state if an error is returned
+func applyModifications(schema *models.Schema, dn models.DN, entry *models.Entry, modifications []modification) error {
+	for _, mod := range modifications {
+		attributeType := schema.AttributeType(mod.name)
+		if attributeType == nil {
+			return newLdapError(ldap.LDAPResultUndefinedAttributeType,
+				"Attribute type '%s' undefined", mod.name)
+		}
+		if attributeType.Flags&models.ATNoUserMods == models.ATNoUserMods {
+			return newLdapError(ldap.LDAPResultConstraintViolation,
+				"Attribute '%s' is not user modifiable", mod.name)
+		}
+
+		values, err := applyModification(schema, attributeType, entry.Values(attributeType), mod)
+		if err != nil {
+			return err
+		}
+		entry.SetValues(attributeType, values)
+	}
+
+	if atav, missing := missingRDNValue(schema, dn.RDN(), entry); missing {
+		return newLdapError(ldap.LDAPResultNotAllowedOnRDN,
+			"Cannot remove naming attribute '%s'", atav.Type)
+	}
+
+	if err := validateEntry(schema, dn, entry); err != nil {
+		return err
+	}
+
+	setModifyTimestamp(entry)
+	return nil
+}
+
+// applyModification returns the result of applying mod to the current values of an attribute
+func applyModification(schema *models.Schema, attributeType *models.AttributeType, current []string, mod modification) ([]string, error) {
+	switch mod.operation {
+	case ldap.ModAdd:
+		if len(mod.values) == 0 {
+			return nil, newLdapError(ldap.LDAPResultProtocolError,
+				"No values to add to '%s'", mod.name)
+		}
+		values := append([]string{}, current...)
+		for _, value := range mod.values {
+			if hasValue(schema, attributeType, values, value) {
+				return nil, newLdapError(ldap.LDAPResultAttributeOrValueExists,
+					"Attribute '%s' already has value '%s'", mod.name, value)
+			}
+			values = append(values, value)
+		}
+		return values, nil
+
+	case ldap.ModDelete:
+		if len(current) == 0 {
+			return nil, newLdapError(ldap.LDAPResultNoSuchAttribute,
+				"No such attribute '%s'", mod.name)
+		}
+		if len(mod.values) == 0 {
+			return nil, nil
+		}
+		values := append([]string{}, current...)
+		for _, value := range mod.values {
+			i := valueIndex(schema, attributeType, values, value)
+			if i < 0 {
+				return nil, newLdapError(ldap.LDAPResultNoSuchAttribute,
+					"Attribute '%s' has no value '%s'", mod.name, value)
+			}
+			values = append(values[:i], values[i+1:]...)
+		}
+		return values, nil
+
+	case ldap.ModReplace:
+		values := []string{}
+		for _, value := range mod.values {
+			if hasValue(schema, attributeType, values, value) {
+				return nil, newLdapError(ldap.LDAPResultAttributeOrValueExists,
+					"Duplicate value '%s' for '%s'", value, mod.name)
+			}
+			values = append(values, value)
+		}
+		return values, nil
+
+	case modIncrement:
+		if len(mod.values) != 1 {
+			return nil, newLdapError(ldap.LDAPResultProtocolError,
+				"Increment of '%s' requires exactly one value", mod.name)
+		}
+		delta, err := strconv.ParseInt(mod.values[0], 10, 64)
+		if err != nil {
+			return nil, newLdapError(ldap.LDAPResultInvalidAttributeSyntax,
+				"Invalid increment value '%s'", mod.values[0])
+		}
+		if len(current) == 0 {
+			return nil, newLdapError(ldap.LDAPResultNoSuchAttribute,
+				"No such attribute '%s'", mod.name)
+		}
+		values := make([]string, len(current))
+		for i, value := range current {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, newLdapError(ldap.LDAPResultConstraintViolation,
+					"Attribute '%s' cannot be incremented", mod.name)
+			}
+			values[i] = strconv.FormatInt(n+delta, 10)
+		}
+		return values, nil
+	}
+
+	return nil, newLdapError(ldap.LDAPResultProtocolError, "Unknown modify operation")
+}
@@ -0,0 +1,38 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/ldap"
+)
+
+func TestApplyIncrement(t *testing.T) {
+	schema := newTestSchema()
+	attributeType := schema.AttributeType(models.PwdMaxFailureAttribute)
+	tests := []struct {
+		current        []string
+		values         []string
+		expected       []string
+		expectedResult int
+	}{
+		{[]string{"3"}, []string{"2"}, []string{"5"}, ldap.LDAPResultSuccess},
+		{[]string{"3"}, []string{"-4"}, []string{"-1"}, ldap.LDAPResultSuccess},
+		{nil, []string{"1"}, nil, ldap.LDAPResultNoSuchAttribute},
+		{[]string{"3"}, []string{"one"}, nil, ldap.LDAPResultInvalidAttributeSyntax},
+		{[]string{"3"}, []string{"1", "2"}, nil, ldap.LDAPResultProtocolError},
+		{[]string{"three"}, []string{"1"}, nil, ldap.LDAPResultConstraintViolation},
+	}
+	for _, test := range tests {
+		mod := modification{operation: modIncrement, name: models.PwdMaxFailureAttribute, values: test.values}
+		values, err := applyModification(schema, attributeType, test.current, mod)
+		result, _ := resultOf(err)
+		if result.result != test.expectedResult {
+			t.Error("For", test.current, test.values, "expected result", test.expectedResult, "got", result.result)
+			continue
+		}
+		if len(values) != len(test.expected) || (len(values) > 0 && values[0] != test.expected[0]) {
+			t.Error("For", test.current, test.values, "expected", test.expected, "got", values)
+		}
+	}
+}
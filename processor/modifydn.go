@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+func init() {
+	requestProcessors = append(requestProcessors,
+		requestProcessor{
+			ldapCode: ldap.ApplicationModifyDNRequest,
+			handler:  handleModifyDNRequest,
+		})
+}
+
+func handleModifyDNRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	if err := session.checkAnonymousAccess(); err != nil {
+		return session.sendLdapResult(messageID, ldap.ApplicationModifyDNResponse, err)
+	}
+	err := session.processModifyDNRequest(request)
+	if err == nil {
+		session.invalidateAccessControls()
+	}
+	return session.sendLdapResult(messageID, ldap.ApplicationModifyDNResponse, err)
+}
+
+func (session *Session) processModifyDNRequest(request *ber.Packet) error {
+	if len(request.Children) < 3 {
+		return newLdapError(ldap.LDAPResultProtocolError, "Malformed ModifyDNRequest")
+	}
+
+	dn, err := models.ParseDN(request.Children[0].ValueString())
+	if err != nil || dn.IsEmpty() {
+		return newLdapError(ldap.LDAPResultInvalidDNSyntax, "Invalid DN '%s'", request.Children[0].ValueString())
+	}
+
+	newRDN, err := models.ParseDN(request.Children[1].ValueString())
+	if err != nil || len(newRDN) != 1 {
+		return newLdapError(ldap.LDAPResultInvalidDNSyntax, "Invalid RDN '%s'", request.Children[1].ValueString())
+	}
+
+	deleteOldRDN, ok := request.Children[2].Value.(bool)
+	if !ok {
+		return newLdapError(ldap.LDAPResultProtocolError, "Malformed deleteoldrdn")
+	}
+
+	newSuperior := dn.Parent()
+	if len(request.Children) > 3 {
+		// newSuperior [0] LDAPDN OPTIONAL
+		newSuperior, err = models.ParseDN(request.Children[3].Data.String())
+		if err != nil {
+			return newLdapError(ldap.LDAPResultInvalidDNSyntax, "Invalid DN '%s'", request.Children[3].Data.String())
+		}
+	}
+	if newSuperior.IsEmpty() {
+		return newLdapError(ldap.LDAPResultUnwillingToPerform, "Cannot rename a naming context")
+	}
+
+	newDN := append(models.DN{newRDN.RDN()}, newSuperior...)
+
+	schema, err := session.getSchema()
+	if err != nil {
+		return err
+	}
+	checker, err := session.newAccessChecker(schema)
+	if err != nil {
+		return err
+	}
+
+	// renaming an entry requires the rights to delete it & to add it under its new DN
+	err = session.DC.RenameEntry(dn, newDN, func(entry *models.Entry) error {
+		if err := checker.require(entry, nil, models.DeleteRight); err != nil {
+			return err
+		}
+		if err := applyNewRDN(schema, dn, newDN, entry, deleteOldRDN); err != nil {
+			return err
+		}
+		renamed := *entry
+		renamed.DN = newDN.String()
+		return checker.require(&renamed, nil, models.AddRight)
+	})
+
+	switch err {
+	case datacontext.ErrNoSuchEntry:
+		return session.noSuchObjectError(dn)
+	case datacontext.ErrNoSuchParent:
+		return session.noSuchObjectError(newSuperior)
+	case datacontext.ErrEntryAlreadyExists:
+		return newLdapError(ldap.LDAPResultEntryAlreadyExists, "Entry '%s' already exists", newDN)
+	case datacontext.ErrMoveIntoSubtree:
+		return newLdapError(ldap.LDAPResultUnwillingToPerform, "Cannot move entry beneath itself")
+	}
+	return err
+}
+
+// applyNewRDN updates the naming attribute values of entry to match the RDN of newDN
+// removing the values of the old RDN when deleteOldRDN is set
+func applyNewRDN(schema *models.Schema, dn models.DN, newDN models.DN, entry *models.Entry, deleteOldRDN bool) error {
+	for _, atav := range newDN.RDN() {
+		attributeType := schema.AttributeType(atav.Type)
+		if attributeType == nil {
+			return newLdapError(ldap.LDAPResultUndefinedAttributeType,
+				"Attribute type '%s' undefined", atav.Type)
+		}
+		if attributeType.Flags&models.ATNoUserMods == models.ATNoUserMods {
+			return newLdapError(ldap.LDAPResultConstraintViolation,
+				"Attribute '%s' is not user modifiable", atav.Type)
+		}
+		values := entry.Values(attributeType)
+		if !hasValue(schema, attributeType, values, atav.Value) {
+			entry.SetValues(attributeType, append(values, atav.Value))
+		}
+	}
+
+	if deleteOldRDN {
+		for _, atav := range dn.RDN() {
+			attributeType := schema.AttributeType(atav.Type)
+			if attributeType == nil || hasRDNValue(schema, newDN.RDN(), attributeType, atav.Value) {
+				continue
+			}
+			values := append([]string{}, entry.Values(attributeType)...)
+			if i := valueIndex(schema, attributeType, values, atav.Value); i >= 0 {
+				entry.SetValues(attributeType, append(values[:i], values[i+1:]...))
+			}
+		}
+	}
+
+	if err := validateEntry(schema, newDN, entry); err != nil {
+		return err
+	}
+
+	setModifyTimestamp(entry)
+	return nil
+}
+
+// hasRDNValue returns true if rdn asserts value for attributeType
+func hasRDNValue(schema *models.Schema, rdn models.RDN, attributeType *models.AttributeType, value string) bool {
+	for _, atav := range rdn {
+		if canonicalName(schema, atav.Type) == attributeType.Name && hasValue(schema, attributeType, []string{atav.Value}, value) {
+			return true
+		}
+	}
+	return false
+}
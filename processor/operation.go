@@ -0,0 +1,166 @@
+package processor
+
+import (
+	"context"
+
+	"github.com/mavricknz/ldap"
+)
+
+// result codes of the Cancel operation https://tools.ietf.org/html/rfc3909
+const (
+	ldapResultCanceled        = 118
+	ldapResultNoSuchOperation = 119
+	ldapResultTooLate         = 120
+	ldapResultCannotCancel    = 121
+)
+
+// operation is a request being processed by a session
+type operation struct {
+	messageID uint64
+	ldapCode  uint8
+	// requestName is the OID of an extended operation
+	requestName string
+	// controls are the controls attached to the request
+	controls []control
+	// ctx is done once the operation is abandoned or cancelled
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done is closed once the operation has completed
+	done chan struct{}
+	// abandoned is set when the responses of the operation are to be discarded
+	// guarded by the session lock
+	abandoned bool
+	// canceled is set by the operation when it stopped because ctx was cancelled
+	// only read once done is closed
+	canceled bool
+}
+
+// cancellable returns false for the operations that cannot be abandoned or cancelled: Bind,
+// Unbind, Abandon, Cancel & the extended operations processed inline (e.g. StartTLS)
+// https://tools.ietf.org/html/rfc3909#section-2
+func (op *operation) cancellable() bool {
+	switch op.ldapCode {
+	case ldap.ApplicationBindRequest, ldap.ApplicationUnbindRequest, ldap.ApplicationAbandonRequest:
+		return false
+	case ldap.ApplicationExtendedRequest:
+		extProc := findExtendedProcessor(op.requestName)
+		return extProc != nil && extProc.oid != cancelOID && !extProc.inline
+	}
+	return true
+}
+
+// startOperation records the request with messageID as outstanding, requestName is the
+// OID of an extended operation
+func (session *Session) startOperation(messageID uint64, ldapCode uint8, requestName string, controls []control) *operation {
+	ctx, cancel := context.WithCancel(context.Background())
+	op := &operation{
+		messageID:   messageID,
+		ldapCode:    ldapCode,
+		requestName: requestName,
+		controls:    controls,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	session.operations[messageID] = op
+	return op
+}
+
+func (session *Session) finishOperation(op *operation) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	if session.operations[op.messageID] == op {
+		delete(session.operations, op.messageID)
+		if len(session.operations) == 0 {
+			// the connection is idle from now on
+			session.resetIdleTimeout()
+		}
+	}
+	op.cancel()
+	close(op.done)
+}
+
+// operation returns the outstanding operation with messageID, nil if there is none
+func (session *Session) operation(messageID uint64) *operation {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.operations[messageID]
+}
+
+// operationContext returns the context of the operation with messageID
+func (session *Session) operationContext(messageID uint64) context.Context {
+	if op := session.operation(messageID); op != nil {
+		return op.ctx
+	}
+	return context.Background()
+}
+
+// operationCanceled records that the operation with messageID stopped after being cancelled
+// must be called by the operation itself
+func (session *Session) operationCanceled(messageID uint64) {
+	if op := session.operation(messageID); op != nil {
+		op.canceled = true
+	}
+}
+
+// abandonOperation stops the operation with messageID, discarding any further responses
+func (session *Session) abandonOperation(messageID uint64) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	if op := session.operations[messageID]; op != nil && op.cancellable() {
+		op.abandoned = true
+		op.cancel()
+	}
+}
+
+// abandonOperations abandons every outstanding operation
+func (session *Session) abandonOperations() {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	for _, op := range session.operations {
+		if op.cancellable() {
+			op.abandoned = true
+			op.cancel()
+		}
+	}
+}
+
+func (session *Session) isAbandoned(messageID uint64) bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	op := session.operations[messageID]
+	return op != nil && op.abandoned
+}
+
+// cancelOperation cancels the operation with messageID, waiting for it to complete
+// https://tools.ietf.org/html/rfc3909
+func (session *Session) cancelOperation(messageID uint64) error {
+	op := session.operation(messageID)
+	switch {
+	case op == nil:
+		return newLdapError(ldapResultNoSuchOperation, "No outstanding operation %d", messageID)
+	case !op.cancellable():
+		return newLdapError(ldapResultCannotCancel, "Operation %d cannot be cancelled", messageID)
+	}
+
+	op.cancel()
+	<-op.done
+	if !op.canceled {
+		return newLdapError(ldapResultTooLate, "Operation %d already completed", messageID)
+	}
+	// the cancelled operation reported canceled, the Cancel operation itself succeeded
+	return nil
+}
+
+// checkCanceled returns a canceled error once the operation with messageID has been
+// cancelled, recording that it stopped
+func (session *Session) checkCanceled(messageID uint64) error {
+	if session.operationContext(messageID).Err() == nil {
+		return nil
+	}
+	session.operationCanceled(messageID)
+	return newLdapError(ldapResultCanceled, "Operation %d cancelled", messageID)
+}
@@ -0,0 +1,265 @@
+package processor
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+const (
+	// https://tools.ietf.org/html/rfc3062
+	passwordModifyOID = "1.3.6.1.4.1.4203.1.11.1"
+)
+
+func init() {
+	extendedProcessors = append(extendedProcessors,
+		extendedProcessor{
+			oid:     passwordModifyOID,
+			handler: handlePasswordModifyRequest,
+		})
+}
+
+// passwordModifyRequest holds the fields of a PasswdModifyRequestValue, empty if absent
+type passwordModifyRequest struct {
+	userIdentity string
+	oldPassword  string
+	newPassword  string
+}
+
+// handlePasswordModifyRequest changes the password of the user identified by the request,
+// or of the bound user, generating one if no new password is requested
+func handlePasswordModifyRequest(session *Session, messageID uint64, value []byte) error {
+	passwdReq, err := decodePasswordModifyRequest(value)
+	if err != nil {
+		return session.sendExtendedResult(messageID, err)
+	}
+
+	generated := ""
+	if passwdReq.newPassword == "" {
+		if generated, err = models.GeneratePassword(); err != nil {
+			return session.sendExtendedResult(messageID, err)
+		}
+		passwdReq.newPassword = generated
+	}
+
+	if err = session.modifyPassword(messageID, passwdReq); err != nil {
+		return session.sendExtendedResult(messageID, err)
+	}
+
+	if generated == "" {
+		return session.sendExtendedResult(messageID, nil)
+	}
+	// PasswdModifyResponseValue ::= SEQUENCE { genPasswd [0] OCTET STRING OPTIONAL }
+	responseValue := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "PasswdModifyResponseValue")
+	responseValue.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimative, 0, generated, "genPasswd"))
+	return session.sendExtendedResponse(messageID, nil, "", responseValue.Bytes())
+}
+
+func decodePasswordModifyRequest(value []byte) (*passwordModifyRequest, error) {
+	// PasswdModifyRequestValue ::= SEQUENCE {
+	//      userIdentity    [0]  OCTET STRING OPTIONAL
+	//      oldPasswd       [1]  OCTET STRING OPTIONAL
+	//      newPasswd       [2]  OCTET STRING OPTIONAL }
+	passwdReq := &passwordModifyRequest{}
+	if len(value) == 0 {
+		return passwdReq, nil
+	}
+
+	packet := ber.DecodePacket(value)
+	if packet == nil {
+		return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed PasswdModifyRequestValue")
+	}
+	for _, child := range packet.Children {
+		if child.ClassType != ber.ClassContext {
+			return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed PasswdModifyRequestValue")
+		}
+		switch child.Tag {
+		case 0:
+			passwdReq.userIdentity = child.Data.String()
+		case 1:
+			passwdReq.oldPassword = child.Data.String()
+		case 2:
+			passwdReq.newPassword = child.Data.String()
+		default:
+			return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed PasswdModifyRequestValue")
+		}
+	}
+	return passwdReq, nil
+}
+
+// modifyPassword sets the new password of the user identified by passwdReq, unless the
+// operation with messageID is cancelled before the password is written
+// the administrator may change any password, other users may change the passwords the
+// access control instructions allow them to write, or only their own password when access
+// control is not enforced
+func (session *Session) modifyPassword(messageID uint64, passwdReq *passwordModifyRequest) error {
+	boundDN := session.BoundDN()
+	if boundDN == "" {
+		return newLdapError(ldap.LDAPResultUnwillingToPerform, "Authentication required to modify passwords")
+	}
+
+	target := boundDN
+	if passwdReq.userIdentity != "" {
+		target = parseUserIdentity(passwdReq.userIdentity)
+	}
+	administrator := session.isRootDN(boundDN)
+	if !administrator && (session.isRootDN(target) || !session.AccessControl && !sameDN(target, boundDN)) {
+		return newLdapError(ldap.LDAPResultInsufficientAccessRights,
+			"Insufficient access to modify the password of '%s'", target)
+	}
+
+	var err error
+	if session.isRootDN(target) {
+		err = session.modifyRootDNPassword(messageID, passwdReq)
+	} else {
+		err = session.modifyEntryPassword(messageID, target, passwdReq, administrator)
+	}
+	if err != nil {
+		return err
+	}
+	log.Println("Password modified for:", target, "by", boundDN)
+	return nil
+}
+
+// modifyRootDNPassword sets the password of the administrator held in the users table
+func (session *Session) modifyRootDNPassword(messageID uint64, passwdReq *passwordModifyRequest) error {
+	users, err := session.DC.SelectAdminUsers()
+	if err != nil {
+		return err
+	}
+	if len(users) != 1 {
+		return newLdapError(ldap.LDAPResultNoSuchObject, "No such user '%s'", session.RootDN)
+	}
+	user := users[0].User
+
+	if passwdReq.oldPassword != "" {
+		match, err := user.ComparePassword(passwdReq.oldPassword)
+		if err != nil {
+			return err
+		}
+		if !match {
+			return newLdapError(ldap.LDAPResultInvalidCredentials, "Old password does not match")
+		}
+	}
+
+	if err = session.setRootDNPassword(user, passwdReq.newPassword); err != nil {
+		return err
+	}
+	if err = session.checkCanceled(messageID); err != nil {
+		return err
+	}
+	return session.DC.UpdateUserPassword(user)
+}
+
+// modifyEntryPassword replaces the userPassword values of the entry named target, enforcing
+// its password policy & the access control instructions
+func (session *Session) modifyEntryPassword(messageID uint64, target string, passwdReq *passwordModifyRequest,
+	administrator bool) error {
+	dn, err := models.ParseDN(target)
+	if err != nil {
+		return newLdapError(ldap.LDAPResultInvalidDNSyntax, "Invalid DN '%s'", target)
+	}
+
+	schema, err := session.getSchema()
+	if err != nil {
+		return err
+	}
+	userPassword := schema.AttributeType(models.UserPasswordAttribute)
+	if userPassword == nil {
+		return newLdapError(ldap.LDAPResultUnwillingToPerform, "Attribute type '%s' undefined",
+			models.UserPasswordAttribute)
+	}
+
+	checker, err := session.newAccessChecker(schema)
+	if err != nil {
+		return err
+	}
+	newPassword, err := models.HashPassword(session.passwordScheme(), passwdReq.newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = session.modifyEntry(dn, func(entry *models.Entry) error {
+		if err := checker.require(entry, userPassword, models.WriteRight); err != nil {
+			return err
+		}
+		previous := entry.Values(userPassword)
+		if passwdReq.oldPassword != "" &&
+			!models.ComparePasswordValues(schema.EntryValues(entry, userPassword), passwdReq.oldPassword) {
+			return newLdapError(ldap.LDAPResultInvalidCredentials, "Old password does not match")
+		}
+
+		policy, err := session.passwordPolicy(entry)
+		if err != nil {
+			return err
+		}
+		if policy != nil {
+			if err := checkPasswordChange(policy, entry, previous, []string{passwdReq.newPassword}, administrator,
+				sameDN(target, session.BoundDN()), time.Now()); err != nil {
+				return err
+			}
+		}
+		if err := session.checkCanceled(messageID); err != nil {
+			return err
+		}
+		entry.SetValues(userPassword, []string{newPassword})
+		return session.setAuthPassword(schema, entry, passwdReq.newPassword)
+	})
+	if err == datacontext.ErrNoSuchEntry {
+		return session.noSuchObjectError(dn)
+	}
+	return err
+}
+
+// hashPasswordValues replaces the cleartext userPassword values of entry that are not among
+// previous by their hash using the password scheme, values already hashed are kept as-is
+// the authPassword values are replaced by the SCRAM keys of the first cleartext value once
+// the userPassword values have changed
+func (proc *Processor) hashPasswordValues(schema *models.Schema, entry *models.Entry, previous []string) error {
+	userPassword := schema.AttributeType(models.UserPasswordAttribute)
+	if userPassword == nil {
+		return nil
+	}
+	values := append([]string{}, entry.Values(userPassword)...)
+	changed := len(values) != len(previous)
+	cleartext := ""
+	for i, value := range values {
+		if hasValue(schema, userPassword, previous, value) {
+			continue
+		}
+		changed = true
+		if scheme, _, err := models.ParsePasswordValue(value); err != nil || scheme != "" {
+			continue
+		}
+		hashed, err := models.HashPassword(proc.passwordScheme(), value)
+		if err != nil {
+			return err
+		}
+		if cleartext == "" {
+			cleartext = value
+		}
+		values[i] = hashed
+	}
+	if !changed {
+		return nil
+	}
+	entry.SetValues(userPassword, values)
+	return proc.setAuthPassword(schema, entry, cleartext)
+}
+
+// parseUserIdentity returns the DN of an authorization identity, identity is returned
+// as-is if it has no "dn:" or "u:" prefix
+// https://tools.ietf.org/html/rfc4513#section-5.2.1.8
+func parseUserIdentity(identity string) string {
+	for _, prefix := range []string{"dn:", "u:"} {
+		if strings.HasPrefix(identity, prefix) {
+			return identity[len(prefix):]
+		}
+	}
+	return identity
+}
@@ -0,0 +1,53 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/idmworks/speedir/models"
+)
+
+func TestHashPasswordValues(t *testing.T) {
+	schema := newTestSchema()
+	proc := &Processor{PasswordScheme: "PBKDF2-SHA256", Scram: true}
+	prehashed, _ := models.HashPassword("SSHA", "prehashed")
+	entry := &models.Entry{
+		DN:         "cn=Test User,cn=Users,dc=example,dc=org",
+		UserValues: models.AttributeValues{models.UserPasswordAttribute: []string{"secret", prehashed, "previous"}},
+	}
+
+	if err := proc.hashPasswordValues(schema, entry, []string{"previous"}); err != nil {
+		t.Fatal("hashPasswordValues failed:", err)
+	}
+	values := entry.UserValues[models.UserPasswordAttribute]
+	if scheme, _, _ := models.ParsePasswordValue(values[0]); scheme != "PBKDF2-SHA256" ||
+		!models.ComparePasswordValues(values[:1], "secret") {
+		t.Error("Expected the cleartext password to be hashed, got", values[0])
+	}
+	if values[1] != prehashed {
+		t.Error("Expected the hashed password to be kept, got", values[1])
+	}
+	if values[2] != "previous" {
+		t.Error("Expected the previous password to be kept, got", values[2])
+	}
+
+	authPassword := entry.OperValues[models.AuthPasswordAttribute]
+	if keys := models.AuthPasswordScramKeys(models.ScramSHA256, authPassword); keys == nil ||
+		!keys.Verify(models.ScramHash(models.ScramSHA256), "secret") {
+		t.Error("Expected the SCRAM keys of the cleartext password, got", authPassword)
+	}
+
+	// unchanged passwords keep their keys, passwords hashed by the client discard them
+	if err := proc.hashPasswordValues(schema, entry, values); err != nil {
+		t.Fatal("hashPasswordValues failed:", err)
+	}
+	if len(entry.OperValues[models.AuthPasswordAttribute]) == 0 {
+		t.Error("Expected the SCRAM keys to be kept")
+	}
+	entry.UserValues[models.UserPasswordAttribute] = []string{prehashed}
+	if err := proc.hashPasswordValues(schema, entry, values); err != nil {
+		t.Fatal("hashPasswordValues failed:", err)
+	}
+	if authPassword := entry.OperValues[models.AuthPasswordAttribute]; len(authPassword) != 0 {
+		t.Error("Expected the SCRAM keys to be discarded, got", authPassword)
+	}
+}
@@ -0,0 +1,199 @@
+package processor
+
+import (
+	"log"
+	"time"
+
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+// passwordPolicyOID identifies the password policy request & response controls
+// https://tools.ietf.org/html/draft-behera-ldap-password-policy-11#section-6
+const passwordPolicyOID = "1.3.6.1.4.1.42.2.27.8.5.1"
+
+func init() {
+	supportedControlOIDs = append(supportedControlOIDs, passwordPolicyOID)
+}
+
+// passwordPolicyResponse is the value of a password policy response control
+type passwordPolicyResponse struct {
+	// timeBeforeExpiration & graceAuthNsRemaining are warnings, only sent if not negative
+	timeBeforeExpiration int
+	graceAuthNsRemaining int
+	// policyError is the reason the operation failed, only sent if not negative
+	policyError int
+}
+
+func newPasswordPolicyResponse() *passwordPolicyResponse {
+	return &passwordPolicyResponse{timeBeforeExpiration: -1, graceAuthNsRemaining: -1, policyError: -1}
+}
+
+// control returns the response control, nil if there is neither warning nor error to report
+func (response *passwordPolicyResponse) control() []responseControl {
+	// PasswordPolicyResponseValue ::= SEQUENCE {
+	//      warning [0] CHOICE {
+	//          timeBeforeExpiration [0] INTEGER (0 .. maxInt),
+	//          graceAuthNsRemaining [1] INTEGER (0 .. maxInt) } OPTIONAL,
+	//      error   [1] ENUMERATED { ... } OPTIONAL }
+	value := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Password Policy Response")
+	warning := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Warning")
+	switch {
+	case response.timeBeforeExpiration >= 0:
+		warning.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimative, 0,
+			uint64(response.timeBeforeExpiration), "Time Before Expiration"))
+		value.AppendChild(warning)
+	case response.graceAuthNsRemaining >= 0:
+		warning.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimative, 1,
+			uint64(response.graceAuthNsRemaining), "Grace AuthNs Remaining"))
+		value.AppendChild(warning)
+	}
+	if response.policyError >= 0 {
+		value.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimative, 1, uint64(response.policyError), "Error"))
+	}
+	if len(value.Children) == 0 {
+		return nil
+	}
+	return []responseControl{{oid: passwordPolicyOID, value: value}}
+}
+
+// newPasswordPolicyError returns an error also described by a password policy response control
+func newPasswordPolicyError(result int, policyError models.PasswordPolicyError, format string, a ...interface{}) *ldapError {
+	err := newLdapError(result, format, a...)
+	response := newPasswordPolicyResponse()
+	response.policyError = int(policyError)
+	err.controls = response.control()
+	return err
+}
+
+// passwordPolicy returns the password policy governing the password of entry: the policy
+// named by its pwdPolicySubentry or the default policy, nil if none applies
+// a policy entry that cannot be found is logged and not enforced
+func (proc *Processor) passwordPolicy(entry *models.Entry) (*models.PasswordPolicy, error) {
+	name := proc.PasswordPolicy
+	if values := entry.OperValues[models.PwdPolicySubentryAttribute]; len(values) > 0 {
+		name = values[0]
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	dn, err := models.ParseDN(name)
+	if err != nil {
+		log.Println("Invalid password policy DN:", name)
+		return nil, nil
+	}
+	entries, err := proc.selectEntriesByDN(dn)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		log.Println("Password policy not found:", name)
+		return nil, nil
+	}
+	return models.NewPasswordPolicy(entries[0].Entry), nil
+}
+
+// checkPasswordPolicy authenticates password against the userPassword values of entry
+// under policy, recording the outcome in entry
+// the matched value is returned if the bind succeeds, along with the warnings or the reason
+// of the failure to report
+func checkPasswordPolicy(policy *models.PasswordPolicy, values []string, entry *models.Entry,
+	password string, now time.Time) (matched string, result int, response *passwordPolicyResponse) {
+	// the password of locked accounts is not checked
+	i := -1
+	if !policy.IsLocked(entry, now) {
+		i = models.MatchPasswordValue(values, password)
+	}
+	if result, response = recordPasswordPolicyBind(policy, entry, i >= 0, now); result != ldap.LDAPResultSuccess {
+		return "", result, response
+	}
+	return values[i], result, response
+}
+
+// recordPasswordPolicyBind records in entry the outcome of a bind under policy, authenticated
+// if the credentials were verified, returning the result of the bind along with the warnings
+// or the reason of the failure to report
+func recordPasswordPolicyBind(policy *models.PasswordPolicy, entry *models.Entry, authenticated bool,
+	now time.Time) (result int, response *passwordPolicyResponse) {
+	response = newPasswordPolicyResponse()
+	if policy.IsLocked(entry, now) {
+		response.policyError = int(models.AccountLocked)
+		return ldap.LDAPResultInvalidCredentials, response
+	}
+	if !authenticated {
+		policy.RecordFailure(entry, now)
+		return ldap.LDAPResultInvalidCredentials, response
+	}
+	policy.RecordSuccess(entry)
+
+	if expiration := policy.Expiration(entry); !expiration.IsZero() {
+		switch remaining := expiration.Sub(now); {
+		case remaining <= 0:
+			grace, ok := policy.UseGraceAuthN(entry, now)
+			if !ok {
+				response.policyError = int(models.PasswordExpired)
+				return ldap.LDAPResultInvalidCredentials, response
+			}
+			response.graceAuthNsRemaining = grace
+		case remaining < policy.ExpireWarning:
+			response.timeBeforeExpiration = int(remaining / time.Second)
+		}
+	}
+	return ldap.LDAPResultSuccess, response
+}
+
+// checkPasswordChange enforces policy on the replacement of the previous userPassword values
+// of entry by passwords, recording the change in entry
+// the quality of the passwords is not checked when the administrator changes them, users
+// changing their own password (selfService) may not supply it hashed
+func checkPasswordChange(policy *models.PasswordPolicy, entry *models.Entry, previous []string,
+	passwords []string, administrator bool, selfService bool, now time.Time) error {
+	for i := 0; i < len(passwords) && !administrator; i++ {
+		if selfService && models.IsHashedPasswordValue(passwords[i]) {
+			return newPasswordPolicyError(ldap.LDAPResultConstraintViolation, models.InsufficientPasswordQuality,
+				"Password must not be supplied hashed")
+		}
+		switch policyError, refused := policy.CheckPassword(entry, previous, passwords[i]); {
+		case !refused:
+		case policyError == models.InsufficientPasswordQuality:
+			return newPasswordPolicyError(ldap.LDAPResultConstraintViolation, policyError,
+				"Password quality cannot be checked once hashed")
+		case policyError == models.PasswordTooShort:
+			return newPasswordPolicyError(ldap.LDAPResultConstraintViolation, policyError,
+				"Password shorter than %d characters", policy.MinLength)
+		default:
+			return newPasswordPolicyError(ldap.LDAPResultConstraintViolation, policyError,
+				"Password found in history")
+		}
+	}
+	policy.RecordPasswordChange(entry, previous, now)
+	return nil
+}
+
+// checkModifiedPassword enforces the password policy of entry on the userPassword values
+// added by a Modify, previous holds the values before the modifications
+func (session *Session) checkModifiedPassword(schema *models.Schema, entry *models.Entry, previous []string) error {
+	userPassword := schema.AttributeType(models.UserPasswordAttribute)
+	if userPassword == nil {
+		return nil
+	}
+	added := []string{}
+	for _, value := range entry.Values(userPassword) {
+		if !hasValue(schema, userPassword, previous, value) {
+			added = append(added, value)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	policy, err := session.passwordPolicy(entry)
+	if err != nil || policy == nil {
+		return err
+	}
+	boundDN := session.BoundDN()
+	return checkPasswordChange(policy, entry, previous, added, session.isRootDN(boundDN), sameDN(boundDN, entry.DN),
+		time.Now())
+}
@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+func TestPasswordPolicyResponseControl(t *testing.T) {
+	tests := []struct {
+		response passwordPolicyResponse
+		expected []byte
+	}{
+		{passwordPolicyResponse{-1, -1, -1}, nil},
+		{passwordPolicyResponse{300, -1, -1}, []byte{0x30, 0x06, 0xa0, 0x04, 0x80, 0x02, 0x01, 0x2c}},
+		{passwordPolicyResponse{-1, 2, -1}, []byte{0x30, 0x05, 0xa0, 0x03, 0x81, 0x01, 0x02}},
+		{passwordPolicyResponse{-1, -1, int(models.AccountLocked)}, []byte{0x30, 0x03, 0x81, 0x01, 0x01}},
+	}
+	for _, test := range tests {
+		controls := test.response.control()
+		switch {
+		case test.expected == nil && controls != nil:
+			t.Error("For", test.response, "expected no control")
+		case test.expected == nil:
+		case len(controls) != 1 || controls[0].oid != passwordPolicyOID:
+			t.Error("For", test.response, "unexpected controls", controls)
+		case !bytes.Equal(controls[0].value.Bytes(), test.expected):
+			t.Errorf("For %v expected %x got %x", test.response, test.expected, controls[0].value.Bytes())
+		}
+	}
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	policy := &models.PasswordPolicy{Lockout: true, MaxFailure: 2, MaxAge: time.Hour, ExpireWarning: time.Hour}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := &models.Entry{OperValues: models.AttributeValues{
+		models.PwdChangedTimeAttribute: []string{models.GeneralizedTime(now.Add(-50 * time.Minute))},
+	}}
+	values := []string{"secret"}
+
+	matched, result, response := checkPasswordPolicy(policy, values, entry, "secret", now)
+	if matched != "secret" || result != ldap.LDAPResultSuccess || response.timeBeforeExpiration != 600 {
+		t.Error("Expected an expiration warning, got", matched, result, response)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, result, _ = checkPasswordPolicy(policy, values, entry, "wrong", now); result != ldap.LDAPResultInvalidCredentials {
+			t.Error("Expected invalidCredentials, got", result)
+		}
+	}
+	_, result, response = checkPasswordPolicy(policy, values, entry, "secret", now)
+	if result != ldap.LDAPResultInvalidCredentials || response.policyError != int(models.AccountLocked) {
+		t.Error("Expected accountLocked, got", result, response)
+	}
+
+	delete(entry.OperValues, models.PwdAccountLockedTimeAttribute)
+	_, result, response = checkPasswordPolicy(policy, values, entry, "secret", now.Add(time.Hour))
+	if result != ldap.LDAPResultInvalidCredentials || response.policyError != int(models.PasswordExpired) {
+		t.Error("Expected passwordExpired, got", result, response)
+	}
+}
+
+func TestCheckPasswordChange(t *testing.T) {
+	hashed, _ := models.HashPassword("SSHA", "secret")
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		checkQuality  int
+		administrator bool
+		selfService   bool
+		expected      bool
+	}{
+		{0, false, false, true},
+		{0, false, true, false},
+		{2, false, false, false},
+		{2, true, false, true},
+	}
+	for _, test := range tests {
+		policy := &models.PasswordPolicy{CheckQuality: test.checkQuality, MinLength: 8}
+		err := checkPasswordChange(policy, &models.Entry{}, nil, []string{hashed}, test.administrator, test.selfService, now)
+		if (err == nil) != test.expected {
+			t.Error("For", test, "expected accepted", test.expected, "got", err)
+		}
+		if ldapErr, ok := err.(*ldapError); err != nil && (!ok || ldapErr.result != ldap.LDAPResultConstraintViolation) {
+			t.Error("For", test, "expected constraintViolation, got", err)
+		}
+	}
+}
+
+func TestWithResponseControls(t *testing.T) {
+	session := &Session{operations: map[uint64]*operation{}}
+	session.startOperation(1, ldap.ApplicationBindRequest, "", []control{{oid: passwordPolicyOID}})
+	session.startOperation(2, ldap.ApplicationBindRequest, "", nil)
+	controls := newPasswordPolicyError(ldap.LDAPResultConstraintViolation, models.PasswordTooShort, "").controls
+
+	for messageID, expected := range map[uint64]int{1: 3, 2: 2} {
+		message := session.withResponseControls(messageID, buildTestBindResponse(messageID), controls...)
+		if len(message.Children) != expected {
+			t.Error("For message", messageID, "expected", expected, "children, got", len(message.Children))
+		}
+	}
+}
+
+func buildTestBindResponse(messageID uint64) *ber.Packet {
+	return buildSaslBindResponse(messageID, &ldapError{result: ldap.LDAPResultSuccess}, nil)
+}
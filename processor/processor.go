@@ -1,109 +1,271 @@
 package processor
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
-	"log"
-	"net"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
 	"github.com/mavricknz/asn1-ber"
 	"github.com/mavricknz/ldap"
-	"io"
 )
 
 var ErrDecodingASN1 = errors.New("Error decoding asn1-ber packet: wrong port?")
 
+// Processor holds the dependencies shared by all client sessions
 type Processor struct {
 	// DC provides access to the data layer
 	DC *datacontext.DataContext
+	// RootDN is the identity of the directory administrator, authenticated against the
+	// users table rather than an entry
+	RootDN string
+	// DisallowAnonymous refuses anonymous binds & operations other than reading the root DSE
+	// or the subschema by sessions that are not authenticated
+	DisallowAnonymous bool
+	// AllowUnauthenticated accepts binds with a name but no password as anonymous binds
+	AllowUnauthenticated bool
 	// Verbose controls the verbosity of logging
 	Verbose bool
-	conn    net.Conn
+	// SizeLimit is the maximum number of entries returned by a search, 0 for no limit
+	SizeLimit int
+	// TimeLimit is the maximum duration of a search, 0 for no limit
+	TimeLimit time.Duration
+	// MaxOperations is the maximum number of operations processed concurrently for
+	// each connection, 0 for no limit
+	MaxOperations int
+	// TLSConfig is used to establish TLS on connections, nil if TLS is not available
+	TLSConfig *tls.Config
+	// IdleTimeout is the duration after which a connection without outstanding operations
+	// is closed, 0 for no timeout
+	IdleTimeout time.Duration
+	// WriteTimeout is the maximum duration of writing a response, after which the
+	// connection is closed, 0 for no timeout
+	WriteTimeout time.Duration
+	// PasswordScheme is the scheme used to hash new passwords & to rehash weak passwords
+	// once they are known, models.DefaultPasswordScheme if empty
+	PasswordScheme string
+	// PasswordPolicy is the DN of the pwdPolicy entry governing the passwords of entries
+	// without a pwdPolicySubentry, none if empty
+	PasswordPolicy string
+	// BindThrottle slows down repeated failed binds, shared by the sessions of all servers
+	// using the processor, nil to not throttle binds (speedir throttles binds by default)
+	BindThrottle *BindThrottle
+	// AccessControl enforces the aci values stored in the directory on the operations of
+	// clients other than the administrator, denying what they do not allow
+	// pwdHistory & authPassword are withheld from them regardless
+	AccessControl bool
+	// Scram enables the SCRAM SASL mechanisms & stores the SCRAM keys of passwords as they
+	// are set, trading the strength of the password scheme for that of PBKDF2 with
+	// models.ScramIterations should the keys be disclosed
+	Scram bool
+	// ScramSecret keys the salts given by SCRAM to unknown users, so that they cannot be told
+	// from existing users, a random secret is generated if empty (changing on each restart)
+	ScramSecret []byte
+
+	schema     *models.Schema
+	schemaLock sync.Mutex
+	// entrySearcher, entrySelector & entryModifier replace DC.SearchEntries,
+	// DC.SelectEntriesByDN & DC.ModifyEntry when set, e.g. by tests
+	entrySearcher func(ctx context.Context, base models.DN, scope datacontext.Scope, schema *models.Schema,
+		filter *models.Filter, fn func(entry *models.Entry) error) error
+	entrySelector func(dn models.DN) (datacontext.DBEntries, error)
+	entryModifier func(dn models.DN, modify func(entry *models.Entry) error) error
+	// acis holds the access control instructions once loaded
+	acis    models.AccessControls
+	aciLock sync.Mutex
+	// scramSecret is ScramSecret or the secret generated in its place
+	scramSecret     []byte
+	scramSecretErr  error
+	scramSecretOnce sync.Once
+}
+
+// ldapError is an error that is reported to the client as an LDAPResult
+type ldapError struct {
+	result    int
+	matchedDN string
+	message   string
+	// controls further describe the result to clients that requested them
+	controls []responseControl
+}
+
+func (err *ldapError) Error() string {
+	return fmt.Sprintf("LDAP result %d: %s", err.result, err.message)
+}
+
+func newLdapError(result int, format string, a ...interface{}) *ldapError {
+	return &ldapError{result: result, message: fmt.Sprintf(format, a...)}
 }
 
-type requestHandler func(proc *Processor, messageID uint64, request *ber.Packet) error
+type requestHandler func(session *Session, messageID uint64, request *ber.Packet) error
 
 type requestProcessor struct {
 	ldapCode uint8
 	handler  requestHandler
+	// exclusive requests wait for all outstanding operations to complete and
+	// block the connection while they are processed
+	exclusive bool
+	// inline requests are processed immediately, without waiting for a free slot
+	inline bool
 }
 
 var requestProcessors = make([]requestProcessor, 0)
 
-// HandleRequest handles incoming LDAPv3 requests
-func (proc *Processor) HandleRequest(conn net.Conn, errChan chan error) {
-	proc.conn = conn
-	// continuously read from the connection
-	for {
-		packet, err := ber.ReadPacket(bufio.NewReader(conn))
-
-		if err == io.EOF {
-			// connection closed by client
-			conn.Close()
-			return
-		}
+// sendLdapResult sends an LDAPResult response of type responseCode describing err
+// errors other than ldapError are reported to the client as "other" and returned
+func (session *Session) sendLdapResult(messageID uint64, responseCode uint8, err error) error {
+	result, err := resultOf(err)
+	session.sendLdapResponse(session.withResponseControls(messageID,
+		buildLdapResultResponse(messageID, responseCode, result), result.controls...))
+	return err
+}
 
-		if err != nil {
-			errChan <- err
-			continue
-		}
+// sendExtendedResponse sends an ExtendedResponse describing err along with the optional
+// responseName & responseValue
+// errors other than ldapError are reported to the client as "other" and returned
+func (session *Session) sendExtendedResponse(messageID uint64, err error, name string, value []byte) error {
+	result, err := resultOf(err)
+	session.sendLdapResponse(session.withResponseControls(messageID,
+		buildExtendedResponse(messageID, result, name, value), result.controls...))
+	return err
+}
 
-		if proc.Verbose && (packet != nil) {
-			ber.PrintPacket(packet)
-		}
+// resultOf returns the LDAPResult describing err, along with err unless it is an ldapError
+func resultOf(err error) (*ldapError, error) {
+	if err == nil {
+		return &ldapError{result: ldap.LDAPResultSuccess}, nil
+	}
+	if ldapErr, ok := err.(*ldapError); ok {
+		return ldapErr, nil
+	}
+	return &ldapError{result: ldap.LDAPResultOther}, err
+}
 
-		// required to catch issues like TLS/TCP port mis-matches
-		if len(packet.Children) == 0 {
-			errChan <- ErrDecodingASN1
-			continue
-		}
+func buildLdapResultResponse(messageID uint64, responseCode uint8, result *ldapError) *ber.Packet {
+	return buildLdapMessage(messageID, newLdapResult(responseCode, result))
+}
 
-		if err := proc.parsePacket(packet); err != nil {
-			errChan <- err
-		}
+// buildLdapMessage wraps the protocolOp response in an LDAPMessage
+func buildLdapMessage(messageID uint64, response *ber.Packet) *ber.Packet {
+	ldapResponse := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	ldapResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, messageID, "MessageID"))
+	ldapResponse.AppendChild(response)
+	return ldapResponse
+}
+
+// newLdapResult creates a response of type responseCode holding the LDAPResult components,
+// further components must be appended before the response is wrapped in an LDAPMessage
+func newLdapResult(responseCode uint8, result *ldapError) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, responseCode, nil, ldap.ApplicationMap[responseCode])
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagEnumerated, uint64(result.result), "LDAP Result"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, result.matchedDN, "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, result.message, "Error Message"))
+	return response
+}
+
+// checkAnonymousAccess returns an error if the session is not authenticated and anonymous
+// access is disallowed
+func (session *Session) checkAnonymousAccess() error {
+	if session.DisallowAnonymous && session.BoundDN() == "" {
+		return newLdapError(ldap.LDAPResultInsufficientAccessRights, "Anonymous access is not allowed")
 	}
+	return nil
 }
 
-func (proc *Processor) parsePacket(packet *ber.Packet) error {
-	messageID := packet.Children[0].Value.(uint64)
-	request := packet.Children[1]
-
-	if request.ClassType == ber.ClassApplication &&
-		request.TagType == ber.TypeConstructed {
-		var handled bool
-		for _, reqProc := range requestProcessors {
-			if reqProc.ldapCode == request.Tag {
-				if err := reqProc.handler(proc, messageID, request); err != nil {
-					return err
-				}
-				handled = true
-			}
-		}
-		if !handled {
-			log.Println("LDAPv3 app code not implemented:", ldap.ApplicationMap[request.Tag])
+// isRootDN returns true if dn is the identity of the directory administrator
+func (proc *Processor) isRootDN(dn string) bool {
+	return proc.RootDN != "" && sameDN(dn, proc.RootDN)
+}
+
+// passwordScheme returns the scheme used to hash passwords
+func (proc *Processor) passwordScheme() string {
+	if proc.PasswordScheme == "" {
+		return models.DefaultPasswordScheme
+	}
+	return proc.PasswordScheme
+}
+
+// sameDN returns true if the DNs a & b name the same entry, invalid DNs are compared
+// case-insensitively
+func sameDN(a, b string) bool {
+	dnA, errA := models.ParseDN(a)
+	dnB, errB := models.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return dnA.Equal(dnB)
+}
+
+// getSchema returns the directory schema, loading it on first use
+func (proc *Processor) getSchema() (*models.Schema, error) {
+	proc.schemaLock.Lock()
+	defer proc.schemaLock.Unlock()
+
+	if proc.schema == nil {
+		schema, err := proc.DC.SelectSchema()
+		if err != nil {
+			return nil, err
 		}
+		proc.schema = schema
 	}
+	return proc.schema, nil
+}
 
-	return nil
+// searchEntries calls fn with each entry within scope of base that may match filter
+// see datacontext.DataContext.SearchEntries
+func (proc *Processor) searchEntries(ctx context.Context, base models.DN, scope datacontext.Scope, schema *models.Schema,
+	filter *models.Filter, fn func(entry *models.Entry) error) error {
+	if proc.entrySearcher != nil {
+		return proc.entrySearcher(ctx, base, scope, schema, filter, fn)
+	}
+	return proc.DC.SearchEntries(ctx, base, scope, schema, filter, fn)
 }
 
-func (proc *Processor) sendLdapResponse(packet *ber.Packet) {
-	buf := packet.Bytes()
+// selectEntriesByDN returns the entry named dn, if any
+// see datacontext.DataContext.SelectEntriesByDN
+func (proc *Processor) selectEntriesByDN(dn models.DN) (datacontext.DBEntries, error) {
+	if proc.entrySelector != nil {
+		return proc.entrySelector(dn)
+	}
+	return proc.selectEntriesByDN(dn)
+}
 
-	if proc.Verbose {
-		ber.PrintPacket(packet)
+// modifyEntry atomically applies modify to the entry named dn
+// see datacontext.DataContext.ModifyEntry
+func (proc *Processor) modifyEntry(dn models.DN, modify func(entry *models.Entry) error) error {
+	if proc.entryModifier != nil {
+		return proc.entryModifier(dn, modify)
 	}
+	return proc.modifyEntry(dn, modify)
+}
 
-	for len(buf) > 0 {
-		n, err := proc.conn.Write(buf)
+// findMatchedDN returns the DN of the closest existing superior of dn
+func (proc *Processor) findMatchedDN(dn models.DN) (string, error) {
+	for ancestor := dn.Parent(); !ancestor.IsEmpty(); ancestor = ancestor.Parent() {
+		entries, err := proc.selectEntriesByDN(ancestor)
 		if err != nil {
-			log.Printf("Error Sending Message: %s\n", err)
-			return
+			return "", err
 		}
-		if n == len(buf) {
-			break
+		if len(entries) > 0 {
+			return ancestor.String(), nil
 		}
-		buf = buf[n:]
+	}
+	return "", nil
+}
+
+// noSuchObjectError returns a noSuchObject error for the missing dn
+func (proc *Processor) noSuchObjectError(dn models.DN) error {
+	matchedDN, err := proc.findMatchedDN(dn)
+	if err != nil {
+		return err
+	}
+	return &ldapError{
+		result:    ldap.LDAPResultNoSuchObject,
+		matchedDN: matchedDN,
+		message:   "No such object",
 	}
 }
@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"log"
+	"sort"
+
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+// saslExchange holds the state of a SASL authentication exchange
+// https://tools.ietf.org/html/rfc4422#section-3
+type saslExchange interface {
+	// step processes the credentials sent by the client, nil if absent, returning the
+	// challenge to send back & whether the exchange is complete, in which case boundDN
+	// is the identity established
+	// errors that are not an ldapError fail the exchange and are reported as "other"
+	step(credentials []byte) (challenge []byte, done bool, boundDN string, err error)
+}
+
+type saslMechanism struct {
+	name string
+	// newExchange starts an exchange authenticating the client of session
+	newExchange func(session *Session) saslExchange
+	// enabled returns false if the mechanism is disabled for proc, nil if always enabled
+	enabled func(proc *Processor) bool
+}
+
+var saslMechanisms = make([]saslMechanism, 0)
+
+// findSaslMechanism returns the SASL mechanism name, nil if the mechanism is not supported
+// or disabled
+func (proc *Processor) findSaslMechanism(name string) *saslMechanism {
+	for i := range saslMechanisms {
+		if saslMechanisms[i].name == name && proc.saslMechanismEnabled(&saslMechanisms[i]) {
+			return &saslMechanisms[i]
+		}
+	}
+	return nil
+}
+
+// supportedSaslMechanisms returns the names of the supported SASL mechanisms, which are
+// enabled
+func (proc *Processor) supportedSaslMechanisms() []string {
+	names := []string{}
+	for i := range saslMechanisms {
+		if proc.saslMechanismEnabled(&saslMechanisms[i]) {
+			names = append(names, saslMechanisms[i].name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (proc *Processor) saslMechanismEnabled(mechanism *saslMechanism) bool {
+	return mechanism.enabled == nil || mechanism.enabled(proc)
+}
+
+// getSaslBindResponse processes a step of a SASL bind, starting a new exchange unless one
+// using the same mechanism is in progress
+// https://tools.ietf.org/html/rfc4513#section-5.2
+func (session *Session) getSaslBindResponse(messageID uint64, auth *ber.Packet) (response *ber.Packet, boundDN string, err error) {
+	// SaslCredentials ::= SEQUENCE {
+	//      mechanism               LDAPString,
+	//      credentials             OCTET STRING OPTIONAL }
+	if len(auth.Children) == 0 || len(auth.Children) > 2 {
+		session.abortSaslBind()
+		return buildSaslBindResponse(messageID,
+			newLdapError(ldap.LDAPResultProtocolError, "Malformed SaslCredentials"), nil), "", nil
+	}
+	name := auth.Children[0].ValueString()
+	var credentials []byte
+	if len(auth.Children) == 2 {
+		credentials = auth.Children[1].Data.Bytes()
+	}
+
+	if session.saslExchange == nil || session.saslMechanism != name {
+		mechanism := session.findSaslMechanism(name)
+		if mechanism == nil {
+			session.abortSaslBind()
+			return buildSaslBindResponse(messageID, newLdapError(ldap.LDAPResultAuthMethodNotSupported,
+				"SASL mechanism '%s' not supported", name), nil), "", nil
+		}
+		session.saslMechanism, session.saslExchange = name, mechanism.newExchange(session)
+	}
+
+	challenge, done, boundDN, err := session.saslExchange.step(credentials)
+	if err != nil || done {
+		session.abortSaslBind()
+	}
+	result, err := resultOf(err)
+	switch {
+	case err != nil:
+		return nil, "", err
+	case result.result != ldap.LDAPResultSuccess:
+		log.Println("SASL", name, "bind failed:", result.message)
+		boundDN = ""
+	case !done:
+		result = &ldapError{result: ldap.LDAPResultSaslBindInProgress}
+	default:
+		log.Println("SASL", name, "bind succeeded for:", boundDN)
+	}
+	return buildSaslBindResponse(messageID, result, challenge), boundDN, nil
+}
+
+// abortSaslBind discards the SASL bind in progress, if any
+func (session *Session) abortSaslBind() {
+	session.saslMechanism, session.saslExchange = "", nil
+}
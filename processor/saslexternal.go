@@ -0,0 +1,96 @@
+package processor
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/ldap"
+)
+
+func init() {
+	saslMechanisms = append(saslMechanisms,
+		saslMechanism{
+			name: "EXTERNAL",
+			newExchange: func(session *Session) saslExchange {
+				return &externalExchange{session: session}
+			},
+		})
+}
+
+// externalExchange authenticates the entry named by the subject of the verified TLS
+// client certificate
+// https://tools.ietf.org/html/rfc4422#appendix-A
+type externalExchange struct {
+	session *Session
+}
+
+func (exchange *externalExchange) step(credentials []byte) (challenge []byte, done bool, boundDN string, err error) {
+	state := exchange.session.TLSState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, true, "", newLdapError(ldap.LDAPResultInappropriateAuthentication,
+			"No verified TLS client certificate")
+	}
+
+	schema, err := exchange.session.getSchema()
+	if err != nil {
+		return nil, true, "", err
+	}
+	dn, err := subjectDN(schema, state.VerifiedChains[0][0])
+	if err != nil {
+		return nil, true, "", newLdapError(ldap.LDAPResultInvalidCredentials, "%v", err)
+	}
+
+	if boundDN, err = exchange.session.certificateIdentity(dn); err != nil {
+		return nil, true, "", err
+	}
+	if err = checkAuthzID(string(credentials), boundDN); err != nil {
+		return nil, true, "", err
+	}
+	return nil, true, boundDN, nil
+}
+
+// certificateIdentity returns the identity of the administrator or entry named dn
+func (proc *Processor) certificateIdentity(dn models.DN) (string, error) {
+	if proc.isRootDN(dn.String()) {
+		return proc.RootDN, nil
+	}
+	entries, err := proc.selectEntriesByDN(dn)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "No entry for certificate subject '%s'", dn)
+	}
+	return dn.String(), nil
+}
+
+// subjectDN returns the subject of cert as a DN, naming attribute types through schema
+// attribute types missing from schema are named by their OID
+func subjectDN(schema *models.Schema, cert *x509.Certificate) (models.DN, error) {
+	var subject pkix.RDNSequence
+	if _, err := asn1.Unmarshal(cert.RawSubject, &subject); err != nil {
+		return nil, fmt.Errorf("Invalid certificate subject: %v", err)
+	}
+
+	// the certificate subject is ordered from the root down
+	dn := models.DN{}
+	for i := len(subject) - 1; i >= 0; i-- {
+		rdn := models.RDN{}
+		for _, atv := range subject[i] {
+			value, ok := atv.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("Unsupported value of '%s' in certificate subject", atv.Type)
+			}
+			name := atv.Type.String()
+			if attributeType := schema.AttributeType(name); attributeType != nil {
+				name = attributeType.Name
+			}
+			rdn = append(rdn, models.AttributeTypeAndValue{Type: name, Value: value})
+		}
+		dn = append(dn, rdn)
+	}
+	return dn, nil
+}
@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/idmworks/speedir/models"
+)
+
+func newTestSchema() *models.Schema {
+	attributeTypes := []*models.AttributeType{}
+	for i := range models.LDAPv3AttributeTypes {
+		attributeTypes = append(attributeTypes, &models.LDAPv3AttributeTypes[i])
+	}
+	objectClasses := []*models.ObjectClass{}
+	for i := range models.LDAPv3ObjectClasses {
+		objectClasses = append(objectClasses, &models.LDAPv3ObjectClasses[i])
+	}
+	return models.NewSchema(attributeTypes, objectClasses)
+}
+
+func TestSubjectDN(t *testing.T) {
+	domainComponent := asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: domainComponent, Value: "org"},
+				{Type: domainComponent, Value: "example"},
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "Users"},
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "Test User"},
+			},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("GenerateKey failed:", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("CreateCertificate failed:", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("ParseCertificate failed:", err)
+	}
+
+	dn, err := subjectDN(newTestSchema(), cert)
+	if err != nil {
+		t.Fatal("subjectDN failed:", err)
+	}
+	if expected := "cn=Test User,cn=Users,dc=example,dc=org"; dn.String() != expected {
+		t.Error("Expected", expected, "got", dn.String())
+	}
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"bytes"
+
+	"github.com/mavricknz/ldap"
+)
+
+func init() {
+	saslMechanisms = append(saslMechanisms,
+		saslMechanism{
+			name: "PLAIN",
+			newExchange: func(session *Session) saslExchange {
+				return &plainExchange{proc: session.Processor, client: session.clientAddress()}
+			},
+		})
+}
+
+// plainExchange authenticates a password for an authentication identity
+// https://tools.ietf.org/html/rfc4616
+type plainExchange struct {
+	proc *Processor
+	// client is the address of the client, throttled on failed binds
+	client string
+	// challenged is set once an empty challenge has been sent for the missing initial response
+	challenged bool
+}
+
+func (exchange *plainExchange) step(credentials []byte) (challenge []byte, done bool, boundDN string, err error) {
+	if credentials == nil && !exchange.challenged {
+		exchange.challenged = true
+		return []byte{}, false, "", nil
+	}
+
+	// message = [authzid] UTF8NUL authcid UTF8NUL passwd
+	fields := bytes.Split(credentials, []byte{0})
+	if len(fields) != 3 {
+		return nil, true, "", newLdapError(ldap.LDAPResultInvalidCredentials, "Malformed PLAIN message")
+	}
+	authzID, authcID, password := string(fields[0]), parseUserIdentity(string(fields[1])), string(fields[2])
+	if password == "" {
+		return nil, true, "", newLdapError(ldap.LDAPResultInvalidCredentials, "Empty password")
+	}
+
+	// the password policy is enforced but its response controls are not reported to PLAIN binds
+	result, boundDN, _, err := exchange.proc.authenticatePassword(exchange.client, authcID, password)
+	switch {
+	case err != nil:
+		return nil, true, "", err
+	case result == ldap.LDAPResultBusy || result == ldap.LDAPResultUnwillingToPerform:
+		// refused by the bind throttle
+		return nil, true, "", newLdapError(result, "Too many failed binds for '%s'", authcID)
+	case result != ldap.LDAPResultSuccess:
+		return nil, true, "", newLdapError(ldap.LDAPResultInvalidCredentials, "Invalid credentials for '%s'", authcID)
+	}
+	if err = checkAuthzID(authzID, boundDN); err != nil {
+		return nil, true, "", err
+	}
+	return nil, true, boundDN, nil
+}
+
+// checkAuthzID returns an error unless the requested authorization identity is empty or
+// the authenticated identity boundDN, acting as another identity is not supported
+func checkAuthzID(authzID string, boundDN string) error {
+	if authzID != "" && !sameDN(parseUserIdentity(authzID), boundDN) {
+		return newLdapError(ldap.LDAPResultInsufficientAccessRights,
+			"Not authorized to act as '%s'", authzID)
+	}
+	return nil
+}
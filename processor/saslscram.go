@@ -0,0 +1,457 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/ldap"
+)
+
+const (
+	// scramPlus is the suffix of the SCRAM mechanisms requiring channel binding
+	scramPlus = "-PLUS"
+	// scramNonceSize is the number of random bytes of the server nonce
+	scramNonceSize = 18
+	// scramSaltSize is the number of random bytes of the salts generated by the server
+	scramSaltSize = 16
+)
+
+func init() {
+	for _, mechanism := range []string{models.ScramSHA1, models.ScramSHA256} {
+		for _, plus := range []bool{false, true} {
+			mechanism, plus := mechanism, plus
+			name := mechanism
+			if plus {
+				name += scramPlus
+			}
+			saslMechanisms = append(saslMechanisms,
+				saslMechanism{
+					name: name,
+					newExchange: func(session *Session) saslExchange {
+						return &scramExchange{
+							session:   session,
+							mechanism: mechanism,
+							hash:      models.ScramHash(mechanism),
+							plus:      plus,
+						}
+					},
+					enabled: func(proc *Processor) bool { return proc.Scram },
+				})
+		}
+	}
+}
+
+// scramExchange authenticates a client proving knowledge of its password without sending it
+// https://tools.ietf.org/html/rfc5802
+type scramExchange struct {
+	session   *Session
+	mechanism string
+	hash      func() hash.Hash
+	// plus is set for the -PLUS variant requiring channel binding
+	plus bool
+
+	// the state of the exchange once the client-first-message has been received
+	started         bool
+	gs2Header       string
+	channelBinding  []byte
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	keys            *models.ScramKeys
+	// authcID is the authentication identity, authzID the requested authorization identity,
+	// boundDN the identity authenticated, empty if the user is unknown
+	authcID string
+	authzID string
+	boundDN string
+}
+
+func (exchange *scramExchange) step(credentials []byte) (challenge []byte, done bool, boundDN string, err error) {
+	if !exchange.started {
+		if credentials == nil {
+			// the client-first-message is sent in the next BindRequest
+			return []byte{}, false, "", nil
+		}
+		exchange.started = true
+		serverFirst, err := exchange.processClientFirst(string(credentials))
+		if err != nil {
+			return nil, true, "", err
+		}
+		return []byte(serverFirst), false, "", nil
+	}
+
+	serverFinal, err := exchange.processClientFinal(string(credentials))
+	if err != nil {
+		exchange.session.recordBind(exchange.session.clientAddress(), exchange.authcID, ldap.LDAPResultInvalidCredentials)
+		return scramError(err), true, "", err
+	}
+	exchange.session.recordBind(exchange.session.clientAddress(), exchange.authcID, ldap.LDAPResultSuccess)
+	if err = checkAuthzID(exchange.authzID, exchange.boundDN); err != nil {
+		return nil, true, "", err
+	}
+	return []byte(serverFinal), true, exchange.boundDN, nil
+}
+
+// scramError returns the server-final-message reporting err (server-error), nil unless err
+// is an ldapError
+func scramError(err error) []byte {
+	if ldapErr, ok := err.(*ldapError); ok {
+		return []byte("e=" + ldapErr.message)
+	}
+	return nil
+}
+
+// processClientFirst returns the server-first-message answering clientFirst
+func (exchange *scramExchange) processClientFirst(clientFirst string) (string, error) {
+	// client-first-message = gs2-header client-first-message-bare
+	// gs2-header = gs2-cbind-flag "," [ authzid ] ","
+	fields := strings.SplitN(clientFirst, ",", 3)
+	if len(fields) != 3 {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "other-error")
+	}
+	exchange.gs2Header = fields[0] + "," + fields[1] + ","
+	exchange.clientFirstBare = fields[2]
+
+	if err := exchange.checkChannelBinding(fields[0]); err != nil {
+		return "", err
+	}
+	if fields[1] != "" {
+		if !strings.HasPrefix(fields[1], "a=") {
+			return "", newLdapError(ldap.LDAPResultInvalidCredentials, "other-error")
+		}
+		authzID, ok := decodeSaslName(fields[1][2:])
+		if !ok {
+			return "", newLdapError(ldap.LDAPResultInvalidCredentials, "invalid-encoding")
+		}
+		exchange.authzID = authzID
+	}
+
+	// client-first-message-bare = [reserved-mext ","] username "," nonce ["," extensions]
+	attributes := strings.Split(exchange.clientFirstBare, ",")
+	if len(attributes) < 2 || !strings.HasPrefix(attributes[0], "n=") || !strings.HasPrefix(attributes[1], "r=") {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "extensions-not-supported")
+	}
+	username, ok := decodeSaslName(attributes[0][2:])
+	if !ok {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "invalid-username-encoding")
+	}
+	clientNonce := attributes[1][2:]
+
+	serverNonce, err := randomBytes(scramNonceSize)
+	if err != nil {
+		return "", err
+	}
+	exchange.nonce = clientNonce + base64.RawStdEncoding.EncodeToString(serverNonce)
+
+	// binds refused by the bind throttle are not looked up
+	exchange.authcID = parseUserIdentity(username)
+	if throttled := exchange.session.throttleBind(exchange.session.clientAddress(), exchange.authcID); throttled != nil {
+		return "", throttled
+	}
+	if err = exchange.lookupKeys(exchange.authcID); err != nil {
+		return "", err
+	}
+
+	exchange.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", exchange.nonce,
+		base64.StdEncoding.EncodeToString(exchange.keys.Salt), exchange.keys.Iterations)
+	return exchange.serverFirst, nil
+}
+
+// checkChannelBinding validates the gs2-cbind-flag of the client & records the channel
+// binding data of the connection
+func (exchange *scramExchange) checkChannelBinding(flag string) error {
+	tlsState := exchange.session.TLSState()
+	switch {
+	case flag == "n" && !exchange.plus:
+		return nil
+	case flag == "y" && !exchange.plus:
+		// the client supports channel binding but believes the server does not
+		if tlsState != nil {
+			return newLdapError(ldap.LDAPResultInvalidCredentials, "server-does-support-channel-binding")
+		}
+		return nil
+	case !strings.HasPrefix(flag, "p=") || !exchange.plus:
+		return newLdapError(ldap.LDAPResultInvalidCredentials, "channel-binding-not-supported")
+	case tlsState == nil:
+		return newLdapError(ldap.LDAPResultInvalidCredentials, "channel-binding-not-supported")
+	}
+
+	channelBinding, err := exchange.session.channelBinding(flag[2:])
+	if err != nil {
+		return err
+	}
+	exchange.channelBinding = channelBinding
+	return nil
+}
+
+// lookupKeys finds the SCRAM keys of the administrator or entry named name
+// unknown users are given random keys with the salt derived from their name, so that they are
+// indistinguishable until the proof fails
+func (exchange *scramExchange) lookupKeys(name string) error {
+	salt, err := exchange.session.scramSalt(exchange.mechanism, name)
+	if err != nil {
+		return err
+	}
+
+	var keys *models.ScramKeys
+	var boundDN string
+	if name != "" {
+		keys, boundDN, err = exchange.session.scramKeys(exchange.mechanism, name, salt)
+		if err != nil {
+			return err
+		}
+	}
+	if keys == nil {
+		password, err := randomBytes(scramSaltSize)
+		if err != nil {
+			return err
+		}
+		keys, boundDN = models.NewScramKeys(exchange.hash, string(password), salt, models.ScramIterations), ""
+	}
+	exchange.keys, exchange.boundDN = keys, boundDN
+	return nil
+}
+
+// processClientFinal verifies the proof of clientFinal, returning the server-final-message
+func (exchange *scramExchange) processClientFinal(clientFinal string) (string, error) {
+	// client-final-message = channel-binding "," nonce ["," extensions] "," proof
+	i := strings.LastIndex(clientFinal, ",p=")
+	if i < 0 {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "invalid-proof")
+	}
+	withoutProof, proof := clientFinal[:i], clientFinal[i+len(",p="):]
+	attributes := strings.Split(withoutProof, ",")
+	if len(attributes) < 2 || !strings.HasPrefix(attributes[0], "c=") || !strings.HasPrefix(attributes[1], "r=") {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "other-error")
+	}
+
+	expectedBinding := base64.StdEncoding.EncodeToString(append([]byte(exchange.gs2Header), exchange.channelBinding...))
+	if attributes[0][2:] != expectedBinding {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "channel-bindings-dont-match")
+	}
+	if attributes[1][2:] != exchange.nonce {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "other-error")
+	}
+
+	clientProof, err := base64.StdEncoding.DecodeString(proof)
+	if err != nil || len(clientProof) != len(exchange.keys.StoredKey) {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "invalid-proof")
+	}
+
+	// ClientKey = ClientProof XOR ClientSignature, StoredKey = H(ClientKey)
+	authMessage := exchange.clientFirstBare + "," + exchange.serverFirst + "," + withoutProof
+	clientSignature := models.ScramHMAC(exchange.hash, exchange.keys.StoredKey, authMessage)
+	clientKey := make([]byte, len(clientProof))
+	for i := range clientProof {
+		clientKey[i] = clientProof[i] ^ clientSignature[i]
+	}
+	storedKey := exchange.hash()
+	storedKey.Write(clientKey)
+	verified := hmac.Equal(storedKey.Sum(nil), exchange.keys.StoredKey)
+	if exchange.boundDN == "" {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "invalid-proof")
+	}
+	if err := exchange.session.recordScramBind(exchange.boundDN, verified); err != nil {
+		return "", err
+	}
+	if !verified {
+		return "", newLdapError(ldap.LDAPResultInvalidCredentials, "invalid-proof")
+	}
+
+	serverSignature := models.ScramHMAC(exchange.hash, exchange.keys.ServerKey, authMessage)
+	return "v=" + base64.StdEncoding.EncodeToString(serverSignature), nil
+}
+
+// scramKeys returns the SCRAM keys of mechanism for the administrator or entry named name,
+// held by the authPassword values of entries, or derived from cleartext passwords using salt
+func (proc *Processor) scramKeys(mechanism string, name string, salt []byte) (*models.ScramKeys, string, error) {
+	if proc.isRootDN(name) {
+		users, err := proc.DC.SelectAdminUsers()
+		if err != nil || len(users) != 1 {
+			return nil, "", err
+		}
+		keys, err := users[0].ScramKeys(mechanism)
+		return keys, proc.RootDN, err
+	}
+
+	dn, err := models.ParseDN(name)
+	if err != nil {
+		return nil, "", nil
+	}
+	schema, err := proc.getSchema()
+	if err != nil {
+		return nil, "", err
+	}
+	userPassword := schema.AttributeType(models.UserPasswordAttribute)
+	entries, err := proc.selectEntriesByDN(dn)
+	if err != nil || len(entries) == 0 || userPassword == nil {
+		return nil, "", err
+	}
+	// locked accounts are refused before the exchange proceeds, as simple binds are
+	policy, err := proc.passwordPolicy(entries[0].Entry)
+	if err != nil {
+		return nil, "", err
+	}
+	if policy != nil && policy.IsLocked(entries[0].Entry, time.Now()) {
+		log.Println("Password policy", policy.DN, "refused bind for:", dn.String(), "error", int(models.AccountLocked))
+		return nil, "", newPasswordPolicyError(ldap.LDAPResultInvalidCredentials, models.AccountLocked, "other-error")
+	}
+	if authPassword := schema.AttributeType(models.AuthPasswordAttribute); authPassword != nil {
+		if keys := models.AuthPasswordScramKeys(mechanism, schema.EntryValues(entries[0].Entry, authPassword)); keys != nil {
+			return keys, dn.String(), nil
+		}
+	}
+	keys := models.PasswordScramKeys(mechanism, schema.EntryValues(entries[0].Entry, userPassword), salt)
+	return keys, dn.String(), nil
+}
+
+// recordScramBind records the outcome of the SCRAM proof of the entry named boundDN under its
+// password policy, returning the error refusing the bind if the policy does, e.g. once the
+// account is locked or its password expired
+// the password policy response controls are not reported to SCRAM binds
+func (proc *Processor) recordScramBind(boundDN string, verified bool) error {
+	if proc.isRootDN(boundDN) {
+		return nil
+	}
+	dn, err := models.ParseDN(boundDN)
+	if err != nil {
+		return err
+	}
+	entries, err := proc.selectEntriesByDN(dn)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	policy, err := proc.passwordPolicy(entries[0].Entry)
+	if err != nil || policy == nil {
+		return err
+	}
+
+	// the outcome is recorded in the entry, which is locked meanwhile
+	var result int
+	var response *passwordPolicyResponse
+	err = proc.modifyEntry(dn, func(entry *models.Entry) error {
+		result, response = recordPasswordPolicyBind(policy, entry, verified, time.Now())
+		return nil
+	})
+	switch {
+	case err == datacontext.ErrNoSuchEntry:
+		return newLdapError(ldap.LDAPResultInvalidCredentials, "invalid-proof")
+	case err != nil:
+		return err
+	case result == ldap.LDAPResultSuccess:
+		return nil
+	}
+	if response.policyError >= 0 {
+		log.Println("Password policy", policy.DN, "refused bind for:", dn.String(), "error", response.policyError)
+		return newPasswordPolicyError(result, models.PasswordPolicyError(response.policyError), "other-error")
+	}
+	return newLdapError(result, "invalid-proof")
+}
+
+// scramSalt returns the salt of mechanism for the user named name when it is not held by its
+// keys, derived from name & the SCRAM secret so that it is the same on each attempt
+func (proc *Processor) scramSalt(mechanism string, name string) ([]byte, error) {
+	proc.scramSecretOnce.Do(func() {
+		proc.scramSecret = proc.ScramSecret
+		if len(proc.scramSecret) == 0 {
+			proc.scramSecret, proc.scramSecretErr = randomBytes(sha256.Size)
+		}
+	})
+	if proc.scramSecretErr != nil {
+		return nil, proc.scramSecretErr
+	}
+	if dn, err := models.ParseDN(name); err == nil {
+		name = dn.Path()
+	}
+	mac := hmac.New(sha256.New, proc.scramSecret)
+	mac.Write([]byte(mechanism + "\x00" + name))
+	return mac.Sum(nil)[:scramSaltSize], nil
+}
+
+// setAuthPassword replaces the authPassword values of entry by the SCRAM keys of password when
+// SCRAM is enabled, the values are removed if password is empty (e.g. hashed by the client)
+// so that they never outlive the password they were derived from
+func (proc *Processor) setAuthPassword(schema *models.Schema, entry *models.Entry, password string) error {
+	authPassword := schema.AttributeType(models.AuthPasswordAttribute)
+	if authPassword == nil {
+		return nil
+	}
+	var values []string
+	if proc.Scram && password != "" {
+		var err error
+		if values, err = models.NewAuthPasswordValues(password); err != nil {
+			return err
+		}
+	}
+	entry.SetValues(authPassword, values)
+	return nil
+}
+
+// channelBinding returns the channel binding data of type name for the TLS connection
+// https://tools.ietf.org/html/rfc5929 https://tools.ietf.org/html/rfc9266
+func (session *Session) channelBinding(name string) ([]byte, error) {
+	tlsState := session.TLSState()
+	switch name {
+	case "tls-unique":
+		if len(tlsState.TLSUnique) > 0 {
+			return tlsState.TLSUnique, nil
+		}
+	case "tls-exporter":
+		if data, err := tlsState.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32); err == nil {
+			return data, nil
+		}
+	case "tls-server-end-point":
+		if session.TLSConfig != nil && len(session.TLSConfig.Certificates) > 0 {
+			return serverEndPoint(session.TLSConfig.Certificates[0].Certificate[0])
+		}
+	}
+	return nil, newLdapError(ldap.LDAPResultInvalidCredentials, "unsupported-channel-binding-type")
+}
+
+// serverEndPoint returns the hash of the DER encoded certificate, using the hash of its
+// signature algorithm unless it is MD5 or SHA-1
+// https://tools.ietf.org/html/rfc5929#section-4.1
+func serverEndPoint(der []byte) ([]byte, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		h = sha512.New()
+	default:
+		h = sha256.New()
+	}
+	h.Write(der)
+	return h.Sum(nil), nil
+}
+
+// decodeSaslName decodes the "=2C" & "=3D" escapes of a SCRAM saslname
+func decodeSaslName(name string) (string, bool) {
+	decoded := strings.NewReplacer("=2C", ",", "=3D", "=").Replace(name)
+	if strings.Count(decoded, "=") != strings.Count(name, "=3D") {
+		return "", false
+	}
+	return decoded, true
+}
+
+func randomBytes(size int) ([]byte, error) {
+	buffer := make([]byte, size)
+	if _, err := rand.Read(buffer); err != nil {
+		return nil, fmt.Errorf("randomBytes failed: %v", err)
+	}
+	return buffer, nil
+}
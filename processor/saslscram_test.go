@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/ldap"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// TestScramClientFinal checks the example exchange of https://tools.ietf.org/html/rfc7677#section-3
+func TestScramClientFinal(t *testing.T) {
+	salt, _ := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	hash := models.ScramHash(models.ScramSHA256)
+	nonce := "rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+	// the user has no entry, so no password policy
+	proc := &Processor{entrySelector: func(dn models.DN) (datacontext.DBEntries, error) { return nil, nil }}
+	newExchange := func() *scramExchange {
+		return &scramExchange{
+			session:         proc.NewSession(nil),
+			mechanism:       models.ScramSHA256,
+			hash:            hash,
+			started:         true,
+			gs2Header:       "n,,",
+			clientFirstBare: "n=user,r=rOprNGfwEbeRWgbNEkqO",
+			serverFirst:     "r=" + nonce + ",s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			nonce:           nonce,
+			keys:            models.NewScramKeys(hash, "pencil", salt, 4096),
+			boundDN:         "cn=user",
+		}
+	}
+
+	serverFinal, err := newExchange().processClientFinal("c=biws,r=" + nonce + ",p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=")
+	if err != nil {
+		t.Fatal("processClientFinal failed:", err)
+	}
+	if expected := "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="; serverFinal != expected {
+		t.Error("Expected", expected, "got", serverFinal)
+	}
+
+	invalid := []string{
+		"c=biws,r=" + nonce + ",p=AHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		"c=eSws,r=" + nonce + ",p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		"c=biws,r=rOprNGfwEbeRWgbNEkqO,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		"c=biws,r=" + nonce,
+	}
+	for _, clientFinal := range invalid {
+		if _, err := newExchange().processClientFinal(clientFinal); err == nil {
+			t.Error("Expected", clientFinal, "to fail")
+		}
+	}
+}
+
+func TestScramSalt(t *testing.T) {
+	proc := &Processor{}
+	salt, err := proc.scramSalt(models.ScramSHA256, "cn=Unknown,dc=example,dc=org")
+	if err != nil || len(salt) != scramSaltSize {
+		t.Fatal("scramSalt failed:", salt, err)
+	}
+	for name, expected := range map[string]bool{
+		"CN=unknown, DC=Example,DC=org": true,
+		"cn=Other,dc=example,dc=org":    false,
+	} {
+		other, _ := proc.scramSalt(models.ScramSHA256, name)
+		if bytes.Equal(salt, other) != expected {
+			t.Error("For", name, "expected the same salt", expected)
+		}
+	}
+	if other, _ := (&Processor{}).scramSalt(models.ScramSHA256, "cn=Unknown,dc=example,dc=org"); bytes.Equal(salt, other) {
+		t.Error("Expected the salt to depend on the secret")
+	}
+}
+
+// scramTestBind runs a SCRAM-SHA-256 exchange authenticating name with password on session
+func scramTestBind(session *Session, name string, password string) (boundDN string, err error) {
+	hash := models.ScramHash(models.ScramSHA256)
+	exchange := &scramExchange{session: session, mechanism: models.ScramSHA256, hash: hash}
+	clientFirstBare := "n=" + strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name) + ",r=fyko+d2lbbFgONRv9qkxdawL"
+	serverFirst, _, _, err := exchange.step([]byte("n,," + clientFirstBare))
+	if err != nil {
+		return "", err
+	}
+
+	// server-first-message = "r=" nonce ",s=" salt ",i=" iteration-count
+	fields := strings.Split(string(serverFirst), ",")
+	salt, _ := base64.StdEncoding.DecodeString(fields[1][2:])
+	iterations, _ := strconv.Atoi(fields[2][2:])
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iterations, hash().Size(), hash)
+	clientKey := models.ScramHMAC(hash, saltedPassword, "Client Key")
+	storedKey := hash()
+	storedKey.Write(clientKey)
+
+	withoutProof := "c=biws," + fields[0]
+	clientSignature := models.ScramHMAC(hash, storedKey.Sum(nil), clientFirstBare+","+string(serverFirst)+","+withoutProof)
+	for i := range clientKey {
+		clientKey[i] ^= clientSignature[i]
+	}
+	_, _, boundDN, err = exchange.step([]byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(clientKey)))
+	return boundDN, err
+}
+
+func TestScramPasswordPolicy(t *testing.T) {
+	policyDN, _ := models.ParseDN("cn=Policy,dc=example,dc=org")
+	userDN, _ := models.ParseDN("cn=Test User,dc=example,dc=org")
+	entries := map[string]*models.Entry{
+		policyDN.Path(): {
+			DN: policyDN.String(),
+			UserValues: models.AttributeValues{
+				models.PwdLockoutAttribute:    []string{"TRUE"},
+				models.PwdMaxFailureAttribute: []string{"2"},
+			},
+		},
+		userDN.Path(): {
+			DN:         userDN.String(),
+			UserValues: models.AttributeValues{models.UserPasswordAttribute: []string{"secret"}},
+			OperValues: models.AttributeValues{models.PwdPolicySubentryAttribute: []string{policyDN.String()}},
+		},
+	}
+	proc := &Processor{schema: newTestSchema(), Scram: true}
+	proc.entrySelector = func(dn models.DN) (datacontext.DBEntries, error) {
+		if entry := entries[dn.Path()]; entry != nil {
+			return datacontext.DBEntries{&datacontext.DBEntry{Entry: entry}}, nil
+		}
+		return nil, nil
+	}
+	proc.entryModifier = func(dn models.DN, modify func(entry *models.Entry) error) error {
+		if entry := entries[dn.Path()]; entry != nil {
+			return modify(entry)
+		}
+		return datacontext.ErrNoSuchEntry
+	}
+	session := proc.NewSession(nil)
+	user := entries[userDN.Path()]
+
+	if boundDN, err := scramTestBind(session, userDN.String(), "secret"); err != nil || boundDN != userDN.String() {
+		t.Fatal("Expected the bind to succeed, got", boundDN, err)
+	}
+	if _, err := scramTestBind(session, userDN.String(), "wrong"); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+	if failures := user.OperValues[models.PwdFailureTimeAttribute]; len(failures) != 1 {
+		t.Error("Expected the failure to be recorded, got", failures)
+	}
+	scramTestBind(session, userDN.String(), "wrong")
+	if len(user.OperValues[models.PwdAccountLockedTimeAttribute]) == 0 {
+		t.Fatal("Expected the account to be locked")
+	}
+
+	// the locked account is refused despite the right password
+	_, err := scramTestBind(session, userDN.String(), "secret")
+	if ldapErr, ok := err.(*ldapError); !ok || ldapErr.result != ldap.LDAPResultInvalidCredentials {
+		t.Error("Expected invalidCredentials for the locked account, got", err)
+	}
+}
+
+func TestDecodeSaslName(t *testing.T) {
+	tests := []struct {
+		name    string
+		decoded string
+		valid   bool
+	}{
+		{"user", "user", true},
+		{"cn=3DTest User=2Cdc=3Dorg", "cn=Test User,dc=org", true},
+		{"=3D2C", "=2C", true},
+		{"cn=Test", "", false},
+	}
+	for _, test := range tests {
+		if decoded, valid := decodeSaslName(test.name); decoded != test.decoded || valid != test.valid {
+			t.Error("For", test.name, "expected", test.decoded, test.valid, "got", decoded, valid)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+// decodeAttributeList decodes an AttributeList into values keyed by attribute description
+func decodeAttributeList(packet *ber.Packet) (models.AttributeValues, error) {
+	attributes := models.AttributeValues{}
+	for _, attribute := range packet.Children {
+		if len(attribute.Children) != 2 {
+			return nil, newLdapError(ldap.LDAPResultProtocolError, "Malformed attribute")
+		}
+		name := attribute.Children[0].ValueString()
+		for _, value := range attribute.Children[1].Children {
+			attributes[name] = append(attributes[name], value.ValueString())
+		}
+	}
+	return attributes, nil
+}
+
+// newEntry builds an entry named dn from the user supplied attributes, validating
+// it against schema
+func newEntry(schema *models.Schema, dn models.DN, attributes models.AttributeValues) (*models.Entry, error) {
+	entry := &models.Entry{
+		DN:         dn.String(),
+		RDN:        dn.RDN().String(),
+		UserValues: models.AttributeValues{},
+		OperValues: models.AttributeValues{},
+	}
+	if parent := dn.Parent(); !parent.IsEmpty() {
+		entry.Parent.String, entry.Parent.Valid = parent.String(), true
+	}
+
+	for name, values := range attributes {
+		attributeType := schema.AttributeType(name)
+		if attributeType == nil {
+			return nil, newLdapError(ldap.LDAPResultUndefinedAttributeType,
+				"Attribute type '%s' undefined", name)
+		}
+		if attributeType.Flags&models.ATNoUserMods == models.ATNoUserMods {
+			return nil, newLdapError(ldap.LDAPResultConstraintViolation,
+				"Attribute '%s' is not user modifiable", name)
+		}
+		switch {
+		case attributeType.Name == models.ObjectClassAttribute:
+			entry.Classes = append(entry.Classes, values...)
+		case attributeType.IsOperational():
+			entry.OperValues[attributeType.Name] = append(entry.OperValues[attributeType.Name], values...)
+		default:
+			entry.UserValues[attributeType.Name] = append(entry.UserValues[attributeType.Name], values...)
+		}
+	}
+
+	if err := validateEntry(schema, dn, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// validateEntry checks entry against the object classes and attribute types in
+// schema, normalizing entry.Classes to include all superclasses (excluding top)
+func validateEntry(schema *models.Schema, dn models.DN, entry *models.Entry) error {
+	if len(entry.Classes) == 0 {
+		return newLdapError(ldap.LDAPResultObjectClassViolation, "No objectClass attribute")
+	}
+
+	classes := []*models.ObjectClass{}
+	seen := map[string]bool{}
+	structural := false
+	for _, name := range entry.Classes {
+		objectClass := schema.ObjectClass(name)
+		if objectClass == nil {
+			return newLdapError(ldap.LDAPResultObjectClassViolation,
+				"Unrecognized objectClass '%s'", name)
+		}
+		for _, class := range schema.SuperClasses(objectClass) {
+			if !seen[class.Name] {
+				seen[class.Name] = true
+				classes = append(classes, class)
+			}
+			if class.Flags == models.OCStructural {
+				structural = true
+			}
+		}
+	}
+	if !structural {
+		return newLdapError(ldap.LDAPResultObjectClassViolation, "No structural object class provided")
+	}
+
+	allowed := map[string]bool{}
+	entry.Classes = models.StringSlice{}
+	for _, class := range classes {
+		for _, name := range class.MustAttributes {
+			if name == models.ObjectClassAttribute {
+				continue
+			}
+			if _, found := entry.UserValues[canonicalName(schema, name)]; !found {
+				return newLdapError(ldap.LDAPResultObjectClassViolation,
+					"Object class '%s' requires attribute '%s'", class.Name, name)
+			}
+			allowed[canonicalName(schema, name)] = true
+		}
+		for _, name := range class.MayAttributes {
+			allowed[canonicalName(schema, name)] = true
+		}
+		if class.Name != models.TopClass {
+			entry.Classes = append(entry.Classes, class.Name)
+		}
+	}
+
+	for name, values := range entry.UserValues {
+		attributeType := schema.AttributeType(name)
+		if attributeType == nil {
+			return newLdapError(ldap.LDAPResultUndefinedAttributeType,
+				"Attribute type '%s' undefined", name)
+		}
+		if !allowed[attributeType.Name] && !seen[models.ExtensibleObjectClass] {
+			return newLdapError(ldap.LDAPResultObjectClassViolation,
+				"Attribute '%s' not allowed", name)
+		}
+		if len(values) == 0 {
+			return newLdapError(ldap.LDAPResultProtocolError,
+				"Attribute '%s' has no values", name)
+		}
+		if attributeType.Flags&models.ATSingleValue == models.ATSingleValue && len(values) > 1 {
+			return newLdapError(ldap.LDAPResultConstraintViolation,
+				"Attribute '%s' is single-valued", name)
+		}
+	}
+
+	if atav, missing := missingRDNValue(schema, dn.RDN(), entry); missing {
+		return newLdapError(ldap.LDAPResultNamingViolation,
+			"Naming attribute '%s' is not present in entry", atav.Type)
+	}
+
+	return validateACIs(schema, dn, entry)
+}
+
+// canonicalName returns the primary name of the attribute type for name
+func canonicalName(schema *models.Schema, name string) string {
+	if attributeType := schema.AttributeType(name); attributeType != nil {
+		return attributeType.Name
+	}
+	return name
+}
+
+// missingRDNValue returns the first assertion of rdn whose value is not held by entry
+func missingRDNValue(schema *models.Schema, rdn models.RDN, entry *models.Entry) (models.AttributeTypeAndValue, bool) {
+	for _, atav := range rdn {
+		attributeType := schema.AttributeType(atav.Type)
+		if attributeType == nil || !hasValue(schema, attributeType, entry.Values(attributeType), atav.Value) {
+			return atav, true
+		}
+	}
+	return models.AttributeTypeAndValue{}, false
+}
+
+func hasValue(schema *models.Schema, attributeType *models.AttributeType, values []string, value string) bool {
+	return valueIndex(schema, attributeType, values, value) >= 0
+}
+
+// valueIndex returns the index of the first of values matching value under the equality
+// matching rule of attributeType, falling back to an exact match when there is no rule
+func valueIndex(schema *models.Schema, attributeType *models.AttributeType, values []string, value string) int {
+	for i, v := range values {
+		match, err := schema.ValuesMatch(attributeType, v, value)
+		if err != nil {
+			match = v == value
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// setModifyTimestamp records the time entry was last modified
+func setModifyTimestamp(entry *models.Entry) {
+	if entry.OperValues == nil {
+		entry.OperValues = models.AttributeValues{}
+	}
+	entry.OperValues[models.ModifyTimestampAttribute] = []string{models.GeneralizedTime(time.Now())}
+}
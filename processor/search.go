@@ -1,9 +1,12 @@
 package processor
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"sort"
+	"math"
 	"strings"
+	"time"
 
 	"github.com/idmworks/speedir/datacontext"
 	"github.com/idmworks/speedir/models"
@@ -15,6 +18,21 @@ const (
 	cnSchema = "cn=schema"
 )
 
+// errSizeLimitExceeded stops a search once the size limit has been reached
+var errSizeLimitExceeded = errors.New("Size limit exceeded")
+
+// scopeSubordinateSubtree selects all subordinates of the base entry, excluding the base
+// https://tools.ietf.org/html/draft-sermersheim-ldap-subordinate-scope-02
+const scopeSubordinateSubtree = 3
+
+// searchRequest is an ldap.SearchRequest along with its decoded base, filter and attribute selection
+type searchRequest struct {
+	ldap.SearchRequest
+	base      models.DN
+	filter    *models.Filter
+	selection *models.AttributeSelection
+}
+
 func init() {
 	requestProcessors = append(requestProcessors,
 		requestProcessor{
@@ -23,98 +41,167 @@ func init() {
 		})
 }
 
-func handleSearchRequest(proc *Processor, messageID uint64, request *ber.Packet) error {
-	ldapResult, err := proc.processSearchRequest(messageID, request)
+func handleSearchRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	ldapResult, err := session.processSearchRequest(messageID, request)
 	if err != nil {
 		return err
 	}
-	proc.sendSearchDoneResponse(messageID, ldapResult)
+	session.sendSearchDoneResponse(messageID, ldapResult)
 	return nil
 }
 
-func (proc *Processor) sendSearchDoneResponse(messageID uint64, ldapResult int) {
-	ldapResponse := proc.buildSearchDoneResponse(messageID, ldapResult)
-	proc.sendLdapResponse(ldapResponse)
+func (session *Session) sendSearchDoneResponse(messageID uint64, ldapResult int) {
+	ldapResponse := session.buildSearchDoneResponse(messageID, ldapResult)
+	session.sendLdapResponse(ldapResponse)
 }
 
-func (proc *Processor) processSearchRequest(messageID uint64, request *ber.Packet) (ldapResult int, err error) {
-	searchReq := &ldap.SearchRequest{
+func (session *Session) processSearchRequest(messageID uint64, request *ber.Packet) (ldapResult int, err error) {
+	// SearchRequest ::= [APPLICATION 3] SEQUENCE { baseObject, scope, derefAliases, sizeLimit,
+	//      timeLimit, typesOnly, filter, attributes }
+	if len(request.Children) != 8 {
+		return ldap.LDAPResultProtocolError, nil
+	}
+	var numbers [4]uint64
+	for i := range numbers {
+		number, ok := request.Children[i+1].Value.(uint64)
+		if !ok {
+			return ldap.LDAPResultProtocolError, nil
+		}
+		numbers[i] = number
+	}
+	typesOnly, ok := request.Children[5].Value.(bool)
+	if !ok {
+		return ldap.LDAPResultProtocolError, nil
+	}
+
+	searchReq := &searchRequest{SearchRequest: ldap.SearchRequest{
 		BaseDN:       request.Children[0].ValueString(),
-		Scope:        int(request.Children[1].Value.(uint64)),
-		DerefAliases: int(request.Children[2].Value.(uint64)),
-		SizeLimit:    int(request.Children[3].Value.(uint64)),
-		TimeLimit:    int(request.Children[4].Value.(uint64)),
-		TypesOnly:    request.Children[5].Value.(bool),
+		Scope:        int(numbers[0]),
+		DerefAliases: int(numbers[1]),
+		SizeLimit:    int(numbers[2]),
+		TimeLimit:    int(numbers[3]),
+		TypesOnly:    typesOnly,
 		Attributes:   []string{},
+	}}
+	// sizeLimit & timeLimit are INTEGER (0 .. maxInt)
+	if numbers[0] > scopeSubordinateSubtree || numbers[2] > math.MaxInt32 || numbers[3] > math.MaxInt32 {
+		return ldap.LDAPResultProtocolError, nil
+	}
+	if searchReq.base, err = models.ParseDN(searchReq.BaseDN); err != nil {
+		return ldap.LDAPResultInvalidDNSyntax, nil
+	}
+	if searchReq.filter, err = decodeFilter(request.Children[6]); err != nil {
+		return ldap.LDAPResultProtocolError, nil
 	}
-	searchReq.Filter, _ = ldap.DecompileFilter(request.Children[6])
-
-	subschema := false
-	namingContexts := false
 
 	for _, attr := range request.Children[7].Children {
-		attrName := attr.ValueString()
-		switch {
-		case attrName == "1.1":
-			// http://www.alvestrand.no/objectid/1.1.html
-			searchReq.Attributes = nil
-			break
-		case strings.EqualFold(attrName, models.SubschemaSubentryAttribute):
-			subschema = true
-			break
-		case strings.EqualFold(attrName, models.NamingContextsAttribute):
-			namingContexts = true
-			break
-		default:
-			searchReq.Attributes = append(searchReq.Attributes, attrName)
-		}
+		searchReq.Attributes = append(searchReq.Attributes, attr.ValueString())
 	}
 
-	sort.Strings(searchReq.Attributes)
+	schema, err := session.getSchema()
+	if err != nil {
+		return ldap.LDAPResultOther, err
+	}
+	searchReq.selection = models.NewAttributeSelection(schema, searchReq.Attributes)
 
 	// TODO: derefFindingBaseObj vs derefInSearching
 
-	ldapResult = ldap.LDAPResultNoSuchObject
-
 	switch {
-	case subschema:
-		ldapResult = proc.sendSubschemaResponse(messageID, *searchReq)
-	case namingContexts:
-		ldapResult, err = proc.sendNamingContextsResponse(messageID, *searchReq)
+	case searchReq.BaseDN == "" && searchReq.Scope == ldap.ScopeBaseObject:
+		ldapResult, err = session.sendRootDSEResponse(messageID, *searchReq)
 	case strings.EqualFold(searchReq.BaseDN, cnSchema):
-		ldapResult, err = proc.sendSchemaResponse(messageID, *searchReq)
+		ldapResult, err = session.sendSchemaResponse(messageID, *searchReq)
+	case session.checkAnonymousAccess() != nil:
+		ldapResult = ldap.LDAPResultInsufficientAccessRights
 	default:
-		ldapResult, err = proc.sendSearchEntryResponse(messageID, *searchReq)
+		ldapResult, err = session.sendSearchEntryResponse(messageID, *searchReq)
 	}
 
 	return ldapResult, err
 }
 
-func (proc *Processor) sendSearchEntryResponse(messageID uint64, searchReq ldap.SearchRequest) (ldapResult int, err error) {
-	var entries datacontext.DBEntries
-	switch searchReq.Scope {
-	case ldap.ScopeBaseObject:
-		entries, err = proc.DC.SelectEntriesByDN(searchReq.BaseDN)
-	case ldap.ScopeSingleLevel:
-		entries, err = proc.DC.SelectEntriesByParent(searchReq.BaseDN)
-	case ldap.ScopeWholeSubtree:
-		entries, err = proc.DC.SelectEntryTreeByParent(searchReq.BaseDN)
+// searchLimits returns the size and time limits of searchReq, neither exceeding the limits
+// configured for the server
+func (proc *Processor) searchLimits(searchReq searchRequest) (sizeLimit int, timeLimit time.Duration) {
+	sizeLimit = searchReq.SizeLimit
+	if proc.SizeLimit > 0 && (sizeLimit == 0 || sizeLimit > proc.SizeLimit) {
+		sizeLimit = proc.SizeLimit
 	}
+	timeLimit = time.Duration(searchReq.TimeLimit) * time.Second
+	if proc.TimeLimit > 0 && (timeLimit == 0 || timeLimit > proc.TimeLimit) {
+		timeLimit = proc.TimeLimit
+	}
+	return sizeLimit, timeLimit
+}
+
+func (session *Session) sendSearchEntryResponse(messageID uint64, searchReq searchRequest) (ldapResult int, err error) {
+	schema, err := session.getSchema()
 	if err != nil {
 		return ldap.LDAPResultOther, err
 	}
-	if (searchReq.Scope == ldap.ScopeBaseObject) && (len(entries) == 0) {
-		return ldap.LDAPResultNoSuchObject, nil
+
+	checker, err := session.newAccessChecker(schema)
+	if err != nil {
+		return ldap.LDAPResultOther, err
 	}
 
-	for _, entry := range entries {
-		proc.processSearchEntryResult(messageID, entry)
+	sizeLimit, timeLimit := session.searchLimits(searchReq)
+	// the context is cancelled when the search is abandoned or cancelled
+	ctx := session.operationContext(messageID)
+	if timeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeLimit)
+		defer cancel()
+	}
+
+	found, sent := false, 0
+	err = session.searchEntries(ctx, searchReq.base, datacontext.Scope(searchReq.Scope), schema, searchReq.filter,
+		func(entry *models.Entry) error {
+			found = true
+			// filters are only partially evaluated by the database
+			if !searchReq.filter.Matches(schema, entry) || !checker.canSearch(entry, searchReq.filter) {
+				return nil
+			}
+			if sizeLimit > 0 && sent == sizeLimit {
+				return errSizeLimitExceeded
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			session.processSearchEntryResult(messageID, searchReq, schema, entry, checker)
+			sent++
+			return nil
+		})
+	switch {
+	case err == errSizeLimitExceeded:
+		return ldap.LDAPResultSizeLimitExceeded, nil
+	case err == context.DeadlineExceeded:
+		return ldap.LDAPResultTimeLimitExceeded, nil
+	case err == context.Canceled:
+		session.operationCanceled(messageID)
+		return ldapResultCanceled, nil
+	case err != nil:
+		return ldap.LDAPResultOther, err
+	}
+
+	if !found && !searchReq.base.IsEmpty() {
+		// the filter (or scope) may have excluded the base entry itself
+		exists, err := session.DC.EntryExists(searchReq.base)
+		if err != nil {
+			return ldap.LDAPResultOther, err
+		}
+		if !exists {
+			return ldap.LDAPResultNoSuchObject, nil
+		}
 	}
 
 	return ldap.LDAPResultSuccess, nil
 }
 
-func (proc *Processor) processSearchEntryResult(messageID uint64, entry *datacontext.DBEntry) {
+// processSearchEntryResult sends the attributes of entry selected by searchReq that checker
+// allows the client to read
+func (session *Session) processSearchEntryResult(messageID uint64, searchReq searchRequest,
+	schema *models.Schema, entry *models.Entry, checker *accessChecker) {
 	ldapResponse := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
 	ldapResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, messageID, "MessageID"))
 
@@ -123,88 +210,71 @@ func (proc *Processor) processSearchEntryResult(messageID uint64, entry *datacon
 
 	attributesPacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
 
-	// add attributes from entry.UserValues
-	// TODO: does not handle mult-value attributes - need to group
-	for key, value := range entry.UserValues {
-		attributesPacket.AppendChild(buildAttributePacket(key, value...))
-	}
-
-	// add attributes from entry.OperValues - may not be necessary since we normalized subschema
-	// TODO: does not handle mult-value attributes
-	for key, value := range entry.OperValues {
-		attributesPacket.AppendChild(buildAttributePacket(key, value...))
-	}
-
-	// we don't store "SUP top" in the DB - it's just a NULL SUP
-	values := []string{models.TopClass}
-	// add attributes from entry.Classes
-	for _, objectClass := range entry.Classes {
-		values = append(values, objectClass)
+	for _, attribute := range searchReq.selection.Attributes(schema, entry) {
+		// values of attribute types no longer in the schema cannot be checked and are withheld
+		attributeType := schema.AttributeType(attribute.Type)
+		if checker != nil && (attributeType == nil || !checker.allows(entry, attributeType, models.ReadRight)) {
+			continue
+		}
+		if searchReq.TypesOnly {
+			attributesPacket.AppendChild(buildAttributePacket(attribute.Type))
+		} else {
+			attributesPacket.AppendChild(buildAttributePacket(attribute.Type, attribute.Values...))
+		}
 	}
-	attributesPacket.AppendChild(buildAttributePacket(models.ObjectClassAttribute, values...))
 
 	searchResponse.AppendChild(attributesPacket)
 	ldapResponse.AppendChild(searchResponse)
 
-	proc.sendLdapResponse(ldapResponse)
+	session.sendLdapResponse(ldapResponse)
 }
 
-func (proc *Processor) sendSchemaResponse(messageID uint64, searchReq ldap.SearchRequest) (ldapResult int, err error) {
-	ldapResponse := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
-	ldapResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, messageID, "MessageID"))
-
-	searchResponse := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
-	searchResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, cnSchema, "objectName	LDAPDN"))
-
-	attributesPacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
-
-	appendSchemaAttributes(attributesPacket)
-
-	atts := searchReq.Attributes
-
-	if i := sort.SearchStrings(atts, models.LDAPSyntaxesAttribute); i < len(atts) {
-		if err := proc.appendSyntaxAttributes(attributesPacket); err != nil {
-			return ldap.LDAPResultOther, err
-		}
+// sendSchemaResponse sends the subschema subentry, loading only the selected schema elements
+func (session *Session) sendSchemaResponse(messageID uint64, searchReq searchRequest) (ldapResult int, err error) {
+	schema, err := session.getSchema()
+	if err != nil {
+		return ldap.LDAPResultOther, err
 	}
 
-	if i := sort.SearchStrings(atts, models.ObjectClassesAttribute); i < len(atts) {
-		if err := proc.appendObjectClassAttributes(attributesPacket); err != nil {
-			return ldap.LDAPResultOther, err
-		}
+	entry := &models.Entry{
+		DN:         cnSchema,
+		RDN:        cnSchema,
+		Classes:    models.StringSlice{"ldapSubentry", models.SubschemaClass},
+		UserValues: models.AttributeValues{models.CommonNameAttribute: []string{"schema"}},
+		OperValues: models.AttributeValues{},
 	}
 
-	if i := sort.SearchStrings(atts, models.MatchingRulesAttribute); i < len(atts) {
-		if err := proc.appendMatchingRuleAttributes(attributesPacket); err != nil {
-			return ldap.LDAPResultOther, err
-		}
+	descriptions := []struct {
+		attribute string
+		load      func() ([]string, error)
+	}{
+		{models.LDAPSyntaxesAttribute, session.syntaxDescriptions},
+		{models.MatchingRulesAttribute, session.matchingRuleDescriptions},
+		{models.AttributeTypesAttribute, session.attributeTypeDescriptions},
+		{models.ObjectClassesAttribute, session.objectClassDescriptions},
 	}
-
-	if i := sort.SearchStrings(atts, "attributesTypes"); i < len(atts) {
-		if err := proc.appendAttributeTypeAttributes(attributesPacket); err != nil {
+	for _, description := range descriptions {
+		if !searchReq.selection.Selects(schema, schema.AttributeType(description.attribute)) {
+			continue
+		}
+		values, err := description.load()
+		if err != nil {
 			return ldap.LDAPResultOther, err
 		}
+		entry.OperValues[description.attribute] = values
 	}
 
-	searchResponse.AppendChild(attributesPacket)
-	ldapResponse.AppendChild(searchResponse)
-
-	proc.sendLdapResponse(ldapResponse)
+	if searchReq.filter.Matches(schema, entry) {
+		session.processSearchEntryResult(messageID, searchReq, schema, entry, nil)
+	}
 
 	return ldap.LDAPResultSuccess, nil
 }
 
-func appendSchemaAttributes(attributesPacket *ber.Packet) {
-	attributesPacket.AppendChild(buildAttributePacket(models.CommonNameAttribute, "schema"))
-	attributesPacket.AppendChild(buildAttributePacket(models.ObjectClassAttribute, models.TopClass))
-	attributesPacket.AppendChild(buildAttributePacket(models.ObjectClassAttribute, "ldapSubentry"))
-	attributesPacket.AppendChild(buildAttributePacket(models.ObjectClassAttribute, models.SubschemaClass))
-}
-
-func (proc *Processor) appendMatchingRuleAttributes(attributesPacket *ber.Packet) error {
+func (proc *Processor) matchingRuleDescriptions() ([]string, error) {
 	rules, err := proc.DC.SelectAllMatchingRules()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	values := []string{}
 	for _, rule := range rules {
@@ -214,14 +284,13 @@ func (proc *Processor) appendMatchingRuleAttributes(attributesPacket *ber.Packet
 			rule.Name,
 			rule.Syntax))
 	}
-	attributesPacket.AppendChild(buildAttributePacket(models.MatchingRulesAttribute, values...))
-	return nil
+	return values, nil
 }
 
-func (proc *Processor) appendAttributeTypeAttributes(attributesPacket *ber.Packet) error {
+func (proc *Processor) attributeTypeDescriptions() ([]string, error) {
 	rules, err := proc.DC.SelectAllAttributeTypes()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	values := []string{}
 	for _, rule := range rules {
@@ -236,14 +305,13 @@ func (proc *Processor) appendAttributeTypeAttributes(attributesPacket *ber.Packe
 			rule.SyntaxString(),
 			rule.FlagsString()))
 	}
-	attributesPacket.AppendChild(buildAttributePacket(models.AttributeTypesAttribute, values...))
-	return nil
+	return values, nil
 }
 
-func (proc *Processor) appendSyntaxAttributes(attributesPacket *ber.Packet) error {
+func (proc *Processor) syntaxDescriptions() ([]string, error) {
 	syntaxes, err := proc.DC.SelectAllSyntaxes()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	values := []string{}
 	for _, syntax := range syntaxes {
@@ -252,14 +320,13 @@ func (proc *Processor) appendSyntaxAttributes(attributesPacket *ber.Packet) erro
 			syntax.OID,
 			syntax.Description))
 	}
-	attributesPacket.AppendChild(buildAttributePacket(models.LDAPSyntaxesAttribute, values...))
-	return nil
+	return values, nil
 }
 
-func (proc *Processor) appendObjectClassAttributes(attributesPacket *ber.Packet) error {
+func (proc *Processor) objectClassDescriptions() ([]string, error) {
 	objectClasses, err := proc.DC.SelectAllObjectClasses()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	values := []string{}
 	for _, objectClass := range objectClasses {
@@ -273,57 +340,42 @@ func (proc *Processor) appendObjectClassAttributes(attributesPacket *ber.Packet)
 			objectClass.MayString()),
 		)
 	}
-	attributesPacket.AppendChild(buildAttributePacket(models.ObjectClassesAttribute, values...))
-	return nil
+	return values, nil
 }
 
-func (proc *Processor) sendNamingContextsResponse(messageID uint64, searchReq ldap.SearchRequest) (ldapResult int, err error) {
-	ldapResponse := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
-	ldapResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, messageID, "MessageID"))
-
-	searchResponse := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
-	searchResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "", "objectName	LDAPDN"))
-
-	attributesPacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
-
-	if err := proc.appendNamingContextAttributes(attributesPacket); err != nil {
+// sendRootDSEResponse sends the root DSE describing the server
+// https://tools.ietf.org/html/rfc4512#section-5.1
+func (session *Session) sendRootDSEResponse(messageID uint64, searchReq searchRequest) (ldapResult int, err error) {
+	schema, err := session.getSchema()
+	if err != nil {
 		return ldap.LDAPResultOther, err
 	}
 
-	searchResponse.AppendChild(attributesPacket)
-	ldapResponse.AppendChild(searchResponse)
-
-	proc.sendLdapResponse(ldapResponse)
-
-	return ldap.LDAPResultSuccess, nil
-}
-
-func (proc *Processor) appendNamingContextAttributes(attributesPacket *ber.Packet) error {
-	entries, err := proc.DC.SelectAllNamingContexts()
+	namingContexts, err := session.DC.SelectAllNamingContexts()
 	if err != nil {
-		return err
+		return ldap.LDAPResultOther, err
 	}
-	values := []string{}
-	for _, entry := range entries {
-		values = append(values, entry.DN)
+	dns := []string{}
+	for _, entry := range namingContexts {
+		dns = append(dns, entry.DN)
 	}
-	attributesPacket.AppendChild(buildAttributePacket(models.NamingContextsAttribute, values...))
-	return nil
-}
 
-func (proc *Processor) sendSubschemaResponse(messageID uint64, searchReq ldap.SearchRequest) (ldapResult int) {
-	ldapResponse := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
-	ldapResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, messageID, "MessageID"))
-	searchResponse := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
-	searchResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "", "objectName	LDAPDN"))
-	attributesPacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
-	attributesPacket.AppendChild(buildAttributePacket(models.SubschemaSubentryAttribute, cnSchema))
-	searchResponse.AppendChild(attributesPacket)
-	ldapResponse.AppendChild(searchResponse)
+	entry := &models.Entry{
+		OperValues: models.AttributeValues{
+			models.NamingContextsAttribute:              dns,
+			models.SubschemaSubentryAttribute:           []string{cnSchema},
+			models.SupportedLDAPVersionAttribute:        []string{"3"},
+			models.SupportedExtensionAttribute:          supportedExtensions(),
+			models.SupportedControlAttribute:            supportedControls(),
+			models.SupportedLDAPSASLMechanismsAttribute: session.supportedSaslMechanisms(),
+		},
+	}
 
-	proc.sendLdapResponse(ldapResponse)
+	if searchReq.filter.Matches(schema, entry) {
+		session.processSearchEntryResult(messageID, searchReq, schema, entry, nil)
+	}
 
-	return ldap.LDAPResultSuccess
+	return ldap.LDAPResultSuccess, nil
 }
 
 func buildAttributePacket(name string, values ...string) *ber.Packet {
@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+type limitsTest struct {
+	requestSize  int
+	requestTime  int
+	serverSize   int
+	serverTime   time.Duration
+	expectedSize int
+	expectedTime time.Duration
+}
+
+var limitsTests = []limitsTest{
+	{0, 0, 0, 0, 0, 0},
+	{10, 5, 0, 0, 10, 5 * time.Second},
+	{0, 0, 500, time.Minute, 500, time.Minute},
+	{10, 5, 500, time.Minute, 10, 5 * time.Second},
+	{1000, 3600, 500, time.Minute, 500, time.Minute},
+}
+
+func TestSearchLimits(t *testing.T) {
+	for _, test := range limitsTests {
+		limitedProc := &Processor{SizeLimit: test.serverSize, TimeLimit: test.serverTime}
+		searchReq := searchRequest{SearchRequest: ldap.SearchRequest{SizeLimit: test.requestSize, TimeLimit: test.requestTime}}
+		sizeLimit, timeLimit := limitedProc.searchLimits(searchReq)
+		if sizeLimit != test.expectedSize || timeLimit != test.expectedTime {
+			t.Error("For", test, "got", sizeLimit, timeLimit)
+		}
+	}
+}
+
+// newSearchTestSession returns a session searching count entries, the search blocks after
+// sending entries until its context is done
+func newSearchTestSession(count int, block bool) *Session {
+	proc := &Processor{schema: newTestSchema()}
+	proc.entrySearcher = func(ctx context.Context, base models.DN, scope datacontext.Scope, schema *models.Schema,
+		filter *models.Filter, fn func(entry *models.Entry) error) error {
+		for i := 0; i < count; i++ {
+			entry := &models.Entry{
+				DN:         fmt.Sprintf("cn=User%d,dc=example,dc=org", i),
+				Classes:    models.StringSlice{models.PersonClass},
+				UserValues: models.AttributeValues{models.CommonNameAttribute: []string{fmt.Sprintf("User%d", i)}},
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if block {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+	return proc.NewSession(nil)
+}
+
+func TestProcessMalformedSearchRequest(t *testing.T) {
+	session := newSearchTestSession(0, false)
+	tests := map[string]func(request *ber.Packet){
+		"missing attributes": func(request *ber.Packet) {
+			request.Children = request.Children[:7]
+		},
+		"string scope": func(request *ber.Packet) {
+			request.Children[1] = ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "sub", "Scope")
+		},
+		"integer typesOnly": func(request *ber.Packet) {
+			request.Children[5] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, 1, "Types Only")
+		},
+		"sizeLimit out of range": func(request *ber.Packet) {
+			request.Children[3] = ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, uint64(1)<<40, "Size Limit")
+		},
+	}
+	for name, malform := range tests {
+		request := newTestSearchMessage(1).Children[1]
+		malform(request)
+		if result, err := session.processSearchRequest(1, request); result != ldap.LDAPResultProtocolError || err != nil {
+			t.Error("For", name, "expected protocolError, got", result, err)
+		}
+	}
+}
+
+func TestSendSearchEntryResponseLimits(t *testing.T) {
+	filter, _ := models.ParseFilter("(objectClass=*)")
+	tests := []struct {
+		count          int
+		block          bool
+		sizeLimit      int
+		timeLimit      time.Duration
+		expectedResult int
+		expectedSent   int
+	}{
+		{5, false, 0, 0, ldap.LDAPResultSuccess, 5},
+		{5, false, 5, 0, ldap.LDAPResultSuccess, 5},
+		{5, false, 2, 0, ldap.LDAPResultSizeLimitExceeded, 2},
+		{3, true, 0, 10 * time.Millisecond, ldap.LDAPResultTimeLimitExceeded, 3},
+	}
+	for _, test := range tests {
+		session := newSearchTestSession(test.count, test.block)
+		session.SizeLimit, session.TimeLimit = test.sizeLimit, test.timeLimit
+		searchReq := searchRequest{filter: filter, selection: models.NewAttributeSelection(session.schema, nil)}
+
+		result, err := session.sendSearchEntryResponse(1, searchReq)
+		if err != nil || result != test.expectedResult {
+			t.Error("For", test, "expected result", test.expectedResult, "got", result, err)
+		}
+		if sent := len(session.responses); sent != test.expectedSent {
+			t.Error("For", test, "expected", test.expectedSent, "entries, got", sent)
+		}
+	}
+}
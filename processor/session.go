@@ -0,0 +1,430 @@
+package processor
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+// responseQueueLength is the number of responses buffered for the writer of a session
+const responseQueueLength = 64
+
+// disconnectWriteTimeout bounds the time spent writing the responses queued ahead of a
+// Notice of Disconnection, so that clients which stopped reading cannot hold the session
+const disconnectWriteTimeout = 5 * time.Second
+
+// response is a PDU queued for the writer of a session
+type response struct {
+	packet *ber.Packet
+	// flushed, if set, is closed once packet has been written
+	flushed chan struct{}
+}
+
+// Session holds the state of a single client connection
+// each request is processed in its own goroutine, responses are written by a single
+// writer goroutine so that PDUs are never interleaved
+type Session struct {
+	*Processor
+	// reader buffers the requests read from conn, only used by the reader goroutine
+	reader *bufio.Reader
+
+	// responses queues the PDUs to be written, a response without PDU closes the connection
+	responses chan response
+	// written is closed once the writer has finished
+	written chan struct{}
+	// slots limits the number of operations processed concurrently, nil if unlimited
+	slots chan struct{}
+	// pending counts the operations being processed
+	pending sync.WaitGroup
+	// closers counts the calls to close queuing their packets
+	closers sync.WaitGroup
+
+	// saslMechanism & saslExchange hold the SASL bind in progress, only used by binds
+	// which are processed exclusively
+	saslMechanism string
+	saslExchange  saslExchange
+
+	// lock guards the fields below
+	lock sync.Mutex
+	// conn is replaced by the TLS connection once StartTLS succeeds
+	conn net.Conn
+	// boundDN is the identity established by the last successful bind, empty if anonymous
+	boundDN string
+	// tlsState describes the TLS connection, nil if the connection is not secure
+	tlsState *tls.ConnectionState
+	// operations holds the requests being processed, keyed by message ID
+	operations map[uint64]*operation
+	// closing is set once the server has decided to close the connection
+	closing bool
+}
+
+// NewSession creates a session serving the requests received on conn
+func (proc *Processor) NewSession(conn net.Conn) *Session {
+	session := &Session{
+		Processor:  proc,
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		responses:  make(chan response, responseQueueLength),
+		written:    make(chan struct{}),
+		operations: make(map[uint64]*operation),
+	}
+	if proc.MaxOperations > 0 {
+		session.slots = make(chan struct{}, proc.MaxOperations)
+	}
+	return session
+}
+
+// Serve handles incoming LDAPv3 requests until the connection is closed
+func (session *Session) Serve(errChan chan error) {
+	go session.writeResponses()
+	defer func() {
+		// outstanding operations are abandoned, wait for them to stop & their responses
+		// to be written
+		session.abandonOperations()
+		session.pending.Wait()
+		// nothing more can be queued once the session is closing & closers are done
+		session.lock.Lock()
+		session.closing = true
+		session.lock.Unlock()
+		session.closers.Wait()
+		close(session.responses)
+		<-session.written
+		session.connection().Close()
+	}()
+
+	if tlsConn, ok := session.conn.(*tls.Conn); ok {
+		if err := session.handshake(tlsConn); err != nil {
+			log.Println("TLS handshake failed:", err)
+			return
+		}
+	}
+
+	// continuously read from the connection
+	for {
+		session.lock.Lock()
+		session.resetIdleTimeout()
+		session.lock.Unlock()
+		packet, err := ber.ReadPacket(session.reader)
+
+		if err == io.EOF || session.isClosing() {
+			// connection closed by client or server
+			return
+		}
+
+		// the timeout is only armed while no operations are outstanding, a request may have
+		// been partially read so the connection cannot be read any further
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			session.disconnect(ldap.LDAPResultUnavailable, "Idle timeout exceeded")
+			return
+		}
+
+		if err != nil {
+			log.Println("Error reading from", session.conn.RemoteAddr(), err)
+			session.disconnect(ldap.LDAPResultProtocolError, "Malformed LDAPMessage")
+			return
+		}
+
+		if session.Verbose && (packet != nil) {
+			ber.PrintPacket(packet)
+		}
+
+		// required to catch issues like TLS/TCP port mis-matches
+		if len(packet.Children) == 0 {
+			errChan <- ErrDecodingASN1
+			session.disconnect(ldap.LDAPResultProtocolError, "Malformed LDAPMessage")
+			return
+		}
+
+		// LDAPMessage ::= SEQUENCE { messageID MessageID, protocolOp CHOICE {...}, ... }
+		if _, ok := packet.Children[0].Value.(uint64); !ok || len(packet.Children) < 2 {
+			session.disconnect(ldap.LDAPResultProtocolError, "Malformed LDAPMessage")
+			return
+		}
+
+		session.dispatch(packet, errChan)
+	}
+}
+
+// dispatch processes packet in a new goroutine, which waits for a free slot while the
+// session is processing its maximum number of operations so that further requests (e.g.
+// AbandonRequest) are still read
+// inline requests (e.g. AbandonRequest, StartTLS) are processed immediately by the reader while
+// exclusive requests (e.g. BindRequest) are processed alone once all outstanding
+// operations have completed
+func (session *Session) dispatch(packet *ber.Packet, errChan chan error) {
+	messageID := packet.Children[0].Value.(uint64)
+	request := packet.Children[1]
+
+	// most requests are constructed but some (e.g. DelRequest) are primitive
+	if request.ClassType != ber.ClassApplication {
+		return
+	}
+
+	var reqProc *requestProcessor
+	for i := range requestProcessors {
+		if requestProcessors[i].ldapCode == request.Tag {
+			reqProc = &requestProcessors[i]
+		}
+	}
+	if reqProc == nil {
+		log.Println("LDAPv3 app code not implemented:", ldap.ApplicationMap[request.Tag])
+		return
+	}
+
+	inline, limited, requestName := reqProc.inline, session.slots != nil, ""
+	if request.Tag == ldap.ApplicationExtendedRequest {
+		extProc := extendedRequestProcessor(request)
+		inline = extProc != nil && extProc.inline
+		limited = limited && (extProc == nil || !extProc.unlimited)
+		if extProc != nil {
+			requestName = extProc.oid
+		}
+	}
+
+	// controls [0] Controls OPTIONAL
+	var controls []control
+	if len(packet.Children) > 2 && packet.Children[2].ClassType == ber.ClassContext && packet.Children[2].Tag == 0 {
+		controls = decodeControls(packet.Children[2])
+	}
+	if ctrl := unsupportedCriticalControl(controls); ctrl != nil {
+		session.refuseRequest(messageID, request.Tag, newLdapError(ldap.LDAPResultUnavailableCriticalExtension,
+			"Unsupported critical control %s", ctrl.oid))
+		return
+	}
+
+	op := session.startOperation(messageID, request.Tag, requestName, controls)
+	process := func() {
+		defer session.finishOperation(op)
+		// a request the handler fails to process only disconnects its own client
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic processing %s %d: %v\n%s", ldap.ApplicationMap[request.Tag], messageID, r, debug.Stack())
+				session.disconnect(ldap.LDAPResultOther, "Internal error")
+			}
+		}()
+		if err := reqProc.handler(session, messageID, request); err != nil {
+			errChan <- err
+		}
+	}
+
+	switch {
+	case inline:
+		process()
+		return
+	case reqProc.exclusive:
+		session.pending.Wait()
+		process()
+		return
+	}
+
+	session.pending.Add(1)
+	go func() {
+		defer session.pending.Done()
+		if limited {
+			session.slots <- struct{}{}
+			defer func() { <-session.slots }()
+		}
+		process()
+	}()
+}
+
+// resultCodes maps the requests answered by an LDAPResult to the type of their response
+var resultCodes = map[uint8]uint8{
+	ldap.ApplicationBindRequest:     ldap.ApplicationBindResponse,
+	ldap.ApplicationSearchRequest:   ldap.ApplicationSearchResultDone,
+	ldap.ApplicationModifyRequest:   ldap.ApplicationModifyResponse,
+	ldap.ApplicationAddRequest:      ldap.ApplicationAddResponse,
+	ldap.ApplicationDelRequest:      ldap.ApplicationDelResponse,
+	ldap.ApplicationModifyDNRequest: ldap.ApplicationModifyDNResponse,
+	ldap.ApplicationCompareRequest:  ldap.ApplicationCompareResponse,
+}
+
+// refuseRequest answers the request of type requestCode with err without processing it,
+// requests without a response (e.g. AbandonRequest) are ignored
+func (session *Session) refuseRequest(messageID uint64, requestCode uint8, err error) {
+	if requestCode == ldap.ApplicationExtendedRequest {
+		session.sendExtendedResponse(messageID, err, "", nil)
+	} else if responseCode, ok := resultCodes[requestCode]; ok {
+		session.sendLdapResult(messageID, responseCode, err)
+	}
+}
+
+// sendLdapResponse queues packet to be written to the connection
+// responses to abandoned operations are discarded
+func (session *Session) sendLdapResponse(packet *ber.Packet) {
+	if messageID, ok := packet.Children[0].Value.(uint64); ok && session.isAbandoned(messageID) {
+		return
+	}
+	session.responses <- response{packet: packet}
+}
+
+// sendLdapResponseAndWait queues packet and waits for it to be written to the connection
+func (session *Session) sendLdapResponseAndWait(packet *ber.Packet) {
+	flushed := make(chan struct{})
+	session.responses <- response{packet: packet, flushed: flushed}
+	<-flushed
+}
+
+// closeConnection closes the connection once all previously queued responses are written
+func (session *Session) closeConnection() {
+	session.close()
+}
+
+// disconnect sends a Notice of Disconnection describing the reason the server is closing
+// the connection, outstanding operations are abandoned
+// https://tools.ietf.org/html/rfc4511#section-4.4.1
+func (session *Session) disconnect(result int, message string) {
+	session.abandonOperations()
+	if conn := session.connection(); conn != nil {
+		conn.SetWriteDeadline(time.Now().Add(disconnectWriteTimeout))
+	}
+	session.close(buildExtendedResponse(0, newLdapError(result, message), noticeOfDisconnectionOID, nil))
+}
+
+// Shutdown notifies the client that the server is shutting down & closes the connection
+func (session *Session) Shutdown() {
+	session.disconnect(ldap.LDAPResultUnavailable, "Server is shutting down")
+}
+
+// close queues packets followed by the closing of the connection
+// only the first call has any effect, the packets are queued without holding the lock as
+// the writer needs it to write the responses ahead of them
+func (session *Session) close(packets ...*ber.Packet) {
+	session.lock.Lock()
+	if session.closing {
+		session.lock.Unlock()
+		return
+	}
+	session.closing = true
+	session.closers.Add(1)
+	session.lock.Unlock()
+	defer session.closers.Done()
+
+	for _, packet := range packets {
+		session.responses <- response{packet: packet}
+	}
+	session.responses <- response{}
+}
+
+// writeResponses writes queued responses to the connection until the queue is closed
+// the connection is closed once a response cannot be written within the WriteTimeout
+func (session *Session) writeResponses() {
+	defer close(session.written)
+
+	var writeErr error
+	for response := range session.responses {
+		switch {
+		case writeErr != nil:
+			// keep draining the queue so operations are not blocked
+		case response.packet == nil:
+			session.connection().Close()
+			writeErr = io.ErrClosedPipe
+		default:
+			if session.Verbose {
+				ber.PrintPacket(response.packet)
+			}
+			conn := session.connection()
+			if timeout := session.writeTimeout(); timeout > 0 {
+				conn.SetWriteDeadline(time.Now().Add(timeout))
+			}
+			if _, writeErr = conn.Write(response.packet.Bytes()); writeErr != nil {
+				log.Printf("Error Sending Message: %s\n", writeErr)
+				// further responses cannot be delivered, stop reading requests
+				session.lock.Lock()
+				session.closing = true
+				session.lock.Unlock()
+				conn.Close()
+			}
+		}
+		if response.flushed != nil {
+			close(response.flushed)
+		}
+	}
+}
+
+// writeTimeout returns the maximum duration of writing the next response, bounded by
+// disconnectWriteTimeout once the connection is closing
+func (session *Session) writeTimeout() time.Duration {
+	if session.isClosing() && (session.WriteTimeout <= 0 || session.WriteTimeout > disconnectWriteTimeout) {
+		return disconnectWriteTimeout
+	}
+	return session.WriteTimeout
+}
+
+// outstandingOperations returns the number of operations being processed
+func (session *Session) outstandingOperations() int {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return len(session.operations)
+}
+
+// resetIdleTimeout arms the idle timeout of the connection while no operations are
+// outstanding & disarms it otherwise, so that reading a request only times out when the
+// connection is idle
+// must be called with the lock held
+func (session *Session) resetIdleTimeout() {
+	if session.IdleTimeout <= 0 {
+		return
+	}
+	deadline := time.Time{}
+	if len(session.operations) == 0 {
+		deadline = time.Now().Add(session.IdleTimeout)
+	}
+	session.conn.SetReadDeadline(deadline)
+}
+
+func (session *Session) isClosing() bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.closing
+}
+
+// BoundDN returns the identity the session is bound as, empty if anonymous
+func (session *Session) BoundDN() string {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.boundDN
+}
+
+func (session *Session) setBoundDN(dn string) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	session.boundDN = dn
+}
+
+// connection returns the connection of the session, which StartTLS may replace
+func (session *Session) connection() net.Conn {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.conn
+}
+
+// handshake runs the TLS handshake on tlsConn, serving further requests over it
+func (session *Session) handshake(tlsConn *tls.Conn) error {
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	state := tlsConn.ConnectionState()
+
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	session.conn = tlsConn
+	session.reader = bufio.NewReader(tlsConn)
+	session.tlsState = &state
+	return nil
+}
+
+// TLSState returns the state of the TLS connection, nil if the connection is not secure
+func (session *Session) TLSState() *tls.ConnectionState {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.tlsState
+}
@@ -0,0 +1,264 @@
+package processor
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+// newTestMessage wraps request in an LDAPMessage with messageID
+func newTestMessage(messageID uint64, request *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, messageID, "MessageID"))
+	packet.AppendChild(request)
+	return packet
+}
+
+// newTestSearchMessage returns a search of the whole directory for (objectClass=*)
+func newTestSearchMessage(messageID uint64) *ber.Packet {
+	request := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchRequest, nil, "Search Request")
+	request.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "", "Base DN"))
+	request.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagEnumerated, ldap.ScopeWholeSubtree, "Scope"))
+	request.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagEnumerated, 0, "Deref Aliases"))
+	request.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, 0, "Size Limit"))
+	request.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, 0, "Time Limit"))
+	request.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimative, ber.TagBoolean, false, "Types Only"))
+	request.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimative, ldap.FilterPresent, "objectClass", "Present"))
+	request.AppendChild(ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes"))
+	return newTestMessage(messageID, request)
+}
+
+// newTestAbandonMessage returns an AbandonRequest of the operation abandonID
+func newTestAbandonMessage(messageID uint64, abandonID uint64) *ber.Packet {
+	return newTestMessage(messageID,
+		ber.NewInteger(ber.ClassApplication, ber.TypePrimative, ldap.ApplicationAbandonRequest, abandonID, "Abandon Request"))
+}
+
+func TestDispatchWithBusySlots(t *testing.T) {
+	// searches block until abandoned, only one is processed at a time
+	session := newSearchTestSession(0, true)
+	session.slots = make(chan struct{}, 1)
+	errChan := make(chan error, 10)
+
+	dispatched := make(chan struct{})
+	go func() {
+		for messageID := uint64(1); messageID <= 3; messageID++ {
+			session.dispatch(newTestSearchMessage(messageID), errChan)
+		}
+		for messageID := uint64(1); messageID <= 3; messageID++ {
+			session.dispatch(newTestAbandonMessage(messageID+3, messageID), errChan)
+		}
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("Expected requests to be read while the slots are busy")
+	}
+
+	completed := make(chan struct{})
+	go func() {
+		session.pending.Wait()
+		close(completed)
+	}()
+	select {
+	case <-completed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the abandoned searches to complete")
+	}
+	if len(session.responses) != 0 {
+		t.Error("Expected the responses of abandoned searches to be discarded, got", len(session.responses))
+	}
+}
+
+func TestDispatchCriticalControls(t *testing.T) {
+	for _, test := range []struct {
+		oid            string
+		critical       bool
+		expectedResult uint64
+	}{
+		{"1.2.3.4", true, ldap.LDAPResultUnavailableCriticalExtension},
+		{"1.2.3.4", false, ldap.LDAPResultSuccess},
+		{passwordPolicyOID, true, ldap.LDAPResultSuccess},
+	} {
+		session := newSearchTestSession(0, false)
+		message := newTestSearchMessage(1)
+		controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		ctrl := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+		ctrl.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, test.oid, "Control Type"))
+		ctrl.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimative, ber.TagBoolean, test.critical, "Criticality"))
+		controls.AppendChild(ctrl)
+		message.AppendChild(controls)
+
+		session.dispatch(message, make(chan error, 1))
+		session.pending.Wait()
+		if len(session.responses) != 1 {
+			t.Error("For", test.oid, test.critical, "expected 1 response, got", len(session.responses))
+			continue
+		}
+		response := (<-session.responses).packet.Children[1]
+		if response.Tag != ldap.ApplicationSearchResultDone {
+			t.Error("For", test.oid, test.critical, "expected SearchResultDone, got", response.Tag)
+		} else if result := response.Children[0].Value.(uint64); result != test.expectedResult {
+			t.Error("For", test.oid, test.critical, "expected result", test.expectedResult, "got", result)
+		}
+	}
+}
+
+func TestDispatchRecoversPanic(t *testing.T) {
+	// SearchResultEntry is never a request, register a handler failing to process it
+	saved := requestProcessors
+	defer func() { requestProcessors = saved }()
+	requestProcessors = append(append([]requestProcessor{}, saved...), requestProcessor{
+		ldapCode: ldap.ApplicationSearchResultEntry,
+		handler: func(session *Session, messageID uint64, request *ber.Packet) error {
+			panic("index out of range")
+		},
+	})
+
+	session := (&Processor{}).NewSession(nil)
+	session.dispatch(newTestMessage(1,
+		ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")),
+		make(chan error, 1))
+	session.pending.Wait()
+
+	if !session.isClosing() {
+		t.Error("Expected the session to be closing")
+	}
+	if len(session.responses) != 2 {
+		t.Fatal("Expected a Notice of Disconnection followed by the closing of the connection, got", len(session.responses))
+	}
+	if notice := (<-session.responses).packet; notice.Children[1].Tag != ldap.ApplicationExtendedResponse {
+		t.Error("Expected a Notice of Disconnection, got", notice.Children[1].Tag)
+	}
+}
+
+func TestCancelOperation(t *testing.T) {
+	session := (&Processor{}).NewSession(nil)
+	for requestName, expected := range map[string]bool{
+		passwordModifyOID: true,
+		whoAmIOID:         true,
+		cancelOID:         false,
+		startTLSOID:       false,
+	} {
+		op := session.startOperation(1, ldap.ApplicationExtendedRequest, requestName, nil)
+		if op.cancellable() != expected {
+			t.Error("For", requestName, "expected cancellable", expected)
+		}
+		session.finishOperation(op)
+	}
+
+	op := session.startOperation(2, ldap.ApplicationExtendedRequest, passwordModifyOID, nil)
+	go func() {
+		defer session.finishOperation(op)
+		<-op.ctx.Done()
+		if err, ok := session.checkCanceled(2).(*ldapError); !ok || err.result != ldapResultCanceled {
+			t.Error("Expected the operation to be canceled, got", err)
+		}
+	}()
+	if err := session.cancelOperation(2); err != nil {
+		t.Error("Expected the Cancel operation to succeed, got", err)
+	}
+	if ldapErr, ok := session.cancelOperation(2).(*ldapError); !ok || ldapErr.result != ldapResultNoSuchOperation {
+		t.Error("Expected noSuchOperation once completed, got", ldapErr)
+	}
+}
+
+func TestCloseWithFullQueue(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	session := (&Processor{}).NewSession(serverConn)
+	for i := 0; i < responseQueueLength; i++ {
+		session.responses <- response{packet: buildLdapResultResponse(uint64(i), ldap.ApplicationSearchResultDone,
+			&ldapError{result: ldap.LDAPResultSuccess})}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		session.close(buildExtendedResponse(0, newLdapError(ldap.LDAPResultUnavailable, "Server is shutting down"),
+			noticeOfDisconnectionOID, nil))
+		close(closed)
+	}()
+	// the writer starts once close is waiting for the queue
+	time.Sleep(10 * time.Millisecond)
+	go session.writeResponses()
+	go io.Copy(ioutil.Discard, clientConn)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected close to queue its packets once the writer drains the queue")
+	}
+	if !session.isClosing() {
+		t.Error("Expected the session to be closing")
+	}
+	close(session.responses)
+	<-session.written
+}
+
+func TestShutdownWithStalledClient(t *testing.T) {
+	// the client never reads its responses
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	session := (&Processor{WriteTimeout: 20 * time.Millisecond}).NewSession(serverConn)
+
+	served := make(chan struct{})
+	go func() {
+		session.Serve(make(chan error, 1))
+		close(served)
+	}()
+	// responses are queued by an outstanding operation
+	shutdown := make(chan struct{})
+	session.pending.Add(1)
+	go func() {
+		defer session.pending.Done()
+		for i := 0; i < 2*responseQueueLength; i++ {
+			session.sendLdapResponse(buildLdapResultResponse(uint64(i), ldap.ApplicationSearchResultDone,
+				&ldapError{result: ldap.LDAPResultSuccess}))
+		}
+		session.Shutdown()
+		close(shutdown)
+	}()
+
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Shutdown not to wait for the client to read its responses")
+	}
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the connection to be closed")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	session := (&Processor{IdleTimeout: 20 * time.Millisecond}).NewSession(serverConn)
+	op := session.startOperation(1, ldap.ApplicationSearchRequest, "", nil)
+
+	served := make(chan struct{})
+	go func() {
+		session.Serve(make(chan error, 1))
+		close(served)
+	}()
+	select {
+	case <-served:
+		t.Fatal("Expected no idle timeout while an operation is outstanding")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	session.finishOperation(op)
+	go io.Copy(ioutil.Discard, clientConn)
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the idle connection to be closed")
+	}
+}
@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"crypto/tls"
+	"log"
+
+	"github.com/mavricknz/ldap"
+)
+
+const (
+	// https://tools.ietf.org/html/rfc4511#section-4.14
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+func init() {
+	extendedProcessors = append(extendedProcessors,
+		extendedProcessor{
+			oid:     startTLSOID,
+			handler: handleStartTLSRequest,
+			// no request may be read from the connection until TLS is established
+			inline: true,
+		})
+}
+
+// handleStartTLSRequest establishes TLS on the connection once the response has been sent
+func handleStartTLSRequest(session *Session, messageID uint64, value []byte) error {
+	if err := session.checkStartTLS(value); err != nil {
+		return session.sendExtendedResponse(messageID, err, startTLSOID, nil)
+	}
+	session.sendLdapResponseAndWait(
+		buildExtendedResponse(messageID, &ldapError{result: ldap.LDAPResultSuccess}, startTLSOID, nil))
+
+	if err := session.handshake(tls.Server(session.conn, session.TLSConfig)); err != nil {
+		// the state of the connection is unknown, it cannot be used any further
+		log.Println("StartTLS handshake failed:", err)
+		session.closeConnection()
+	}
+	return nil
+}
+
+// checkStartTLS returns an error if TLS cannot be established on the connection
+func (session *Session) checkStartTLS(value []byte) error {
+	switch {
+	case value != nil:
+		return newLdapError(ldap.LDAPResultProtocolError, "StartTLS takes no requestValue")
+	case session.TLSConfig == nil:
+		return newLdapError(ldap.LDAPResultUnavailable, "TLS is not available")
+	case session.TLSState() != nil:
+		return newLdapError(ldap.LDAPResultOperationsError, "TLS is already established")
+	case session.outstandingOperations() > 1:
+		// the StartTLS operation itself is outstanding
+		return newLdapError(ldap.LDAPResultOperationsError, "Operations are outstanding")
+	case session.reader.Buffered() > 0:
+		return newLdapError(ldap.LDAPResultProtocolError, "Requests received before the StartTLS response")
+	}
+	return nil
+}
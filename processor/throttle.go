@@ -0,0 +1,222 @@
+package processor
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/idmworks/speedir/models"
+	"github.com/mavricknz/ldap"
+)
+
+const (
+	// defaultForgetAfter is the duration after which failed binds are forgotten if
+	// BindThrottle.ForgetAfter is 0
+	defaultForgetAfter = time.Hour
+	// throttleSweepInterval is the minimum interval between removals of forgotten records
+	throttleSweepInterval = time.Minute
+)
+
+// BindThrottle slows down repeated failed binds per DN & per client address: once a bind
+// has failed, further binds are refused until a delay doubling with each consecutive failure
+// has elapsed, and the DN or address is banned for BanDuration after BanAfter failures
+// binds are refused before the password is checked, so that throttled clients consume
+// neither hashing nor database time
+// client addresses are only throttled once AddressTolerance failures have been recorded, as
+// the clients sharing an address (NAT, proxies) would otherwise be throttled by each other
+type BindThrottle struct {
+	// Delay is the delay following the first failure, 0 for no delays
+	Delay time.Duration
+	// MaxDelay caps the delay, which does not grow unless MaxDelay exceeds Delay
+	MaxDelay time.Duration
+	// BanAfter is the number of consecutive failures banning the DN or address, 0 to never ban
+	BanAfter    int
+	BanDuration time.Duration
+	// AddressTolerance is the number of consecutive failures from a client address before it
+	// is delayed & banned like a DN
+	AddressTolerance int
+	// ForgetAfter is the duration after which failures are forgotten, defaultForgetAfter if 0
+	ForgetAfter time.Duration
+	// Result is the result of refused binds, ldap.LDAPResultBusy if 0
+	Result int
+
+	lock sync.Mutex
+	// records holds the failures keyed by "dn:" & normalized DN or "ip:" & client address
+	records map[string]*throttleRecord
+	swept   time.Time
+}
+
+// throttleRecord holds the consecutive failed binds of a DN or client address
+type throttleRecord struct {
+	failures    int
+	lastFailure time.Time
+	bannedUntil time.Time
+	// tolerance is the number of failures not throttled
+	tolerance int
+}
+
+// wait returns the duration before a bind of the DN or client address of record is allowed
+func (throttle *BindThrottle) wait(record *throttleRecord, now time.Time) time.Duration {
+	if now.Before(record.bannedUntil) {
+		return record.bannedUntil.Sub(now)
+	}
+	if allowed := record.lastFailure.Add(throttle.delay(record.failures - record.tolerance)); now.Before(allowed) {
+		return allowed.Sub(now)
+	}
+	return 0
+}
+
+// delay returns the delay following the failures-th consecutive failure
+func (throttle *BindThrottle) delay(failures int) time.Duration {
+	if failures <= 0 || throttle.Delay <= 0 {
+		return 0
+	}
+	delay := throttle.Delay
+	for i := 1; i < failures && delay < throttle.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > throttle.MaxDelay && throttle.MaxDelay > throttle.Delay {
+		delay = throttle.MaxDelay
+	}
+	return delay
+}
+
+// forgotten returns true once the failures of record no longer matter at now
+func (throttle *BindThrottle) forgotten(record *throttleRecord, now time.Time) bool {
+	forgetAfter := throttle.ForgetAfter
+	if forgetAfter == 0 {
+		forgetAfter = defaultForgetAfter
+	}
+	return !now.Before(record.bannedUntil) && now.Sub(record.lastFailure) >= forgetAfter
+}
+
+// check returns the duration before a bind by client as the DN name is allowed, 0 if it is
+// allowed now
+// a nil BindThrottle allows every bind
+func (throttle *BindThrottle) check(client string, name string, now time.Time) time.Duration {
+	if throttle == nil {
+		return 0
+	}
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+
+	var wait time.Duration
+	for _, key := range throttleKeys(client, name) {
+		record := throttle.records[key]
+		if record == nil {
+			continue
+		}
+		if throttle.forgotten(record, now) {
+			delete(throttle.records, key)
+			continue
+		}
+		if w := throttle.wait(record, now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// record records the outcome of a bind by client as the DN name at now
+// a successful bind forgets the failures of the DN but not those of the client address, so
+// that a client cannot try other DNs in between successful binds
+func (throttle *BindThrottle) record(client string, name string, success bool, now time.Time) {
+	if throttle == nil {
+		return
+	}
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+
+	keys := throttleKeys(client, name)
+	if success {
+		delete(throttle.records, keys[len(keys)-1])
+		return
+	}
+
+	if throttle.records == nil {
+		throttle.records = make(map[string]*throttleRecord)
+	}
+	for _, key := range keys {
+		record := throttle.records[key]
+		if record == nil || throttle.forgotten(record, now) {
+			record = &throttleRecord{}
+			if strings.HasPrefix(key, "ip:") {
+				record.tolerance = throttle.AddressTolerance
+			}
+			throttle.records[key] = record
+		}
+		record.failures++
+		record.lastFailure = now
+		if throttle.BanAfter > 0 && record.failures >= throttle.BanAfter+record.tolerance {
+			log.Println("Banning", key, "for", throttle.BanDuration, "after", record.failures, "failed binds")
+			record.bannedUntil = now.Add(throttle.BanDuration)
+			// further failures are throttled until forgotten
+			record.failures = record.tolerance
+		}
+	}
+	throttle.sweep(now)
+}
+
+// sweep removes the forgotten records, at most once every throttleSweepInterval
+func (throttle *BindThrottle) sweep(now time.Time) {
+	if now.Sub(throttle.swept) < throttleSweepInterval {
+		return
+	}
+	throttle.swept = now
+	for key, record := range throttle.records {
+		if throttle.forgotten(record, now) {
+			delete(throttle.records, key)
+		}
+	}
+}
+
+// result returns the result of refused binds
+func (throttle *BindThrottle) result() int {
+	if throttle.Result == 0 {
+		return ldap.LDAPResultBusy
+	}
+	return throttle.Result
+}
+
+// throttleKeys returns the record keys of the client address, if known, & of the DN name
+func throttleKeys(client string, name string) []string {
+	key := strings.ToLower(name)
+	if dn, err := models.ParseDN(name); err == nil {
+		key = dn.Path()
+	}
+	if client == "" {
+		return []string{"dn:" + key}
+	}
+	return []string{"ip:" + client, "dn:" + key}
+}
+
+// throttleBind returns the error refusing a bind by client as name while the DN or the
+// client address is throttled, nil if the bind may proceed
+func (proc *Processor) throttleBind(client string, name string) *ldapError {
+	wait := proc.BindThrottle.check(client, name, time.Now())
+	if wait == 0 {
+		return nil
+	}
+	log.Println("Bind throttled for:", name, "from", client, "retry in", wait.Round(time.Second))
+	return newLdapError(proc.BindThrottle.result(), "Too many failed binds, retry in %v", wait.Round(time.Second))
+}
+
+// recordBind records the outcome of a bind by client as name
+func (proc *Processor) recordBind(client string, name string, result int) {
+	proc.BindThrottle.record(client, name, result == ldap.LDAPResultSuccess, time.Now())
+}
+
+// clientAddress returns the IP address of the client, empty if unknown
+func (session *Session) clientAddress() string {
+	conn := session.connection()
+	if conn == nil || conn.RemoteAddr() == nil {
+		return ""
+	}
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
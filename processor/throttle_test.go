@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBindThrottleDelay(t *testing.T) {
+	throttle := &BindThrottle{Delay: time.Second, MaxDelay: 4 * time.Second}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	name := "cn=Test User,cn=Users,dc=example,dc=org"
+
+	if wait := throttle.check("192.0.2.1", name, now); wait != 0 {
+		t.Error("Expected no delay before any failure, got", wait)
+	}
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		throttle.record("192.0.2.1", name, false, now)
+		if wait := throttle.check("192.0.2.1", name, now); wait != expected {
+			t.Error("Expected a delay of", expected, "got", wait)
+		}
+	}
+
+	// the DN is throttled from any address, regardless of its spelling
+	if wait := throttle.check("192.0.2.2", "CN=test user, cn=users,dc=example,dc=org", now); wait != 4*time.Second {
+		t.Error("Expected the DN to be throttled, got", wait)
+	}
+	// the address is throttled for any DN
+	if wait := throttle.check("192.0.2.1", "cn=other,dc=example,dc=org", now); wait != 4*time.Second {
+		t.Error("Expected the address to be throttled, got", wait)
+	}
+	if wait := throttle.check("192.0.2.1", name, now.Add(4*time.Second)); wait != 0 {
+		t.Error("Expected no delay once elapsed, got", wait)
+	}
+
+	// a successful bind forgets the failures of the DN only
+	throttle.record("192.0.2.1", name, true, now)
+	if wait := throttle.check("192.0.2.2", name, now); wait != 0 {
+		t.Error("Expected the DN failures to be forgotten, got", wait)
+	}
+	if wait := throttle.check("192.0.2.1", name, now); wait == 0 {
+		t.Error("Expected the address to remain throttled")
+	}
+
+	if wait := throttle.check("192.0.2.1", name, now.Add(defaultForgetAfter)); wait != 0 {
+		t.Error("Expected the failures to be forgotten, got", wait)
+	}
+}
+
+func TestBindThrottleBan(t *testing.T) {
+	throttle := &BindThrottle{BanAfter: 3, BanDuration: time.Minute}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	name := "cn=Test User,cn=Users,dc=example,dc=org"
+
+	for i := 0; i < 2; i++ {
+		throttle.record("192.0.2.1", name, false, now)
+	}
+	if wait := throttle.check("192.0.2.1", name, now); wait != 0 {
+		t.Error("Expected no ban before the third failure, got", wait)
+	}
+	throttle.record("192.0.2.1", name, false, now)
+	if wait := throttle.check("192.0.2.1", name, now.Add(time.Second)); wait != 59*time.Second {
+		t.Error("Expected a ban, got", wait)
+	}
+	if wait := throttle.check("192.0.2.1", name, now.Add(time.Minute)); wait != 0 {
+		t.Error("Expected the ban to be lifted, got", wait)
+	}
+}
+
+func TestBindThrottleAddressTolerance(t *testing.T) {
+	throttle := &BindThrottle{Delay: time.Second, MaxDelay: 4 * time.Second, BanAfter: 3, BanDuration: time.Minute,
+		AddressTolerance: 2}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// users sharing an address are not throttled by each other's failures
+	for _, name := range []string{"cn=User1,dc=example,dc=org", "cn=User2,dc=example,dc=org"} {
+		throttle.record("192.0.2.1", name, false, now)
+	}
+	if wait := throttle.check("192.0.2.1", "cn=User3,dc=example,dc=org", now); wait != 0 {
+		t.Error("Expected the address to be tolerated, got", wait)
+	}
+	throttle.record("192.0.2.1", "cn=User3,dc=example,dc=org", false, now)
+	if wait := throttle.check("192.0.2.1", "cn=User4,dc=example,dc=org", now); wait != time.Second {
+		t.Error("Expected the address to be throttled beyond its tolerance, got", wait)
+	}
+	for _, name := range []string{"cn=User4,dc=example,dc=org", "cn=User5,dc=example,dc=org"} {
+		throttle.record("192.0.2.1", name, false, now)
+	}
+	if wait := throttle.check("192.0.2.1", "cn=User6,dc=example,dc=org", now); wait != time.Minute {
+		t.Error("Expected the address to be banned, got", wait)
+	}
+}
+
+func TestNilBindThrottle(t *testing.T) {
+	var throttle *BindThrottle
+	throttle.record("192.0.2.1", "cn=admin,dc=example,dc=org", false, time.Now())
+	if wait := throttle.check("192.0.2.1", "cn=admin,dc=example,dc=org", time.Now()); wait != 0 {
+		t.Error("Expected a nil throttle to allow binds, got", wait)
+	}
+}
@@ -0,0 +1,24 @@
+package processor
+
+import (
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+)
+
+func init() {
+	requestProcessors = append(requestProcessors,
+		requestProcessor{
+			ldapCode: ldap.ApplicationUnbindRequest,
+			handler:  handleUnbindRequest,
+			inline:   true,
+		})
+}
+
+// handleUnbindRequest abandons all outstanding operations and closes the connection,
+// there is no response
+// https://tools.ietf.org/html/rfc4511#section-4.3
+func handleUnbindRequest(session *Session, messageID uint64, request *ber.Packet) error {
+	session.abandonOperations()
+	session.closeConnection()
+	return nil
+}
@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"github.com/mavricknz/ldap"
+)
+
+const (
+	// https://tools.ietf.org/html/rfc4532
+	whoAmIOID = "1.3.6.1.4.1.4203.1.11.3"
+)
+
+func init() {
+	extendedProcessors = append(extendedProcessors,
+		extendedProcessor{
+			oid:     whoAmIOID,
+			handler: handleWhoAmIRequest,
+		})
+}
+
+// handleWhoAmIRequest returns the authorization identity of the session, an empty
+// authzId if the session is anonymous
+func handleWhoAmIRequest(session *Session, messageID uint64, value []byte) error {
+	if value != nil {
+		return session.sendExtendedResult(messageID,
+			newLdapError(ldap.LDAPResultProtocolError, "WhoAmI takes no requestValue"))
+	}
+	return session.sendExtendedResponse(messageID, nil, "", []byte(session.authzID()))
+}
+
+// authzID returns the authorization identity of the session
+// https://tools.ietf.org/html/rfc4513#section-5.2.1.8
+func (session *Session) authzID() string {
+	if boundDN := session.BoundDN(); boundDN != "" {
+		return "dn:" + boundDN
+	}
+	return ""
+}
@@ -2,27 +2,42 @@ package server
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"log"
 	"net"
+	"os"
 	"strconv"
+	"sync"
 
 	"fmt"
+
+	"github.com/idmworks/speedir/processor"
 )
 
 const (
 	listenType = "tcp"
+	// clientCAFile holds the certificates of the CAs trusted to issue client certificates
+	clientCAFile = "ca.pem"
 )
 
-type requestHandler func(conn net.Conn, errChan chan error)
-
 type Server struct {
-	Port    int
-	Secure  bool
-	Handler requestHandler
-	ErrChan chan error
+	Port      int
+	Secure    bool
+	Processor *processor.Processor
+	ErrChan   chan error
+
+	// lock guards the fields below
+	lock     sync.Mutex
+	listener net.Listener
+	sessions map[*processor.Session]bool
+	closing  bool
+	// served counts the sessions being served
+	served sync.WaitGroup
 }
 
-// ServeTCP starts a TCP server on port, optionally secure with a requestHandler
+// ServeTCP starts a TCP server on port, optionally secure, serving each connection
+// with a new processor.Session
 func (server *Server) ServeTCP() {
 	listener, err := server.startListening()
 	if err != nil {
@@ -30,19 +45,48 @@ func (server *Server) ServeTCP() {
 		return
 	}
 	defer listener.Close()
+
+	server.lock.Lock()
+	if server.closing {
+		server.lock.Unlock()
+		return
+	}
+	server.listener = listener
+	server.lock.Unlock()
+
 	server.handleConnections(listener)
 }
 
+// Shutdown stops accepting connections, sends a Notice of Disconnection to every client
+// and waits for their connections to be closed
+// the sessions are shut down without holding the lock, which sessions need to finish
+func (server *Server) Shutdown() {
+	server.lock.Lock()
+	server.closing = true
+	if server.listener != nil {
+		server.listener.Close()
+	}
+	sessions := make([]*processor.Session, 0, len(server.sessions))
+	for session := range server.sessions {
+		sessions = append(sessions, session)
+	}
+	server.lock.Unlock()
+
+	for _, session := range sessions {
+		session.Shutdown()
+	}
+
+	server.served.Wait()
+}
+
 func (server *Server) startListening() (listener net.Listener, err error) {
 	service, tlsFlag := "0.0.0.0:"+strconv.Itoa(server.Port), "TCP"
 
 	if server.Secure {
-		var config *tls.Config
-		config, err = createTLSConfig()
-		if err != nil {
-			return nil, err
+		if server.Processor.TLSConfig == nil {
+			return nil, fmt.Errorf("TLS is not configured")
 		}
-		listener, err = tls.Listen(listenType, service, config)
+		listener, err = tls.Listen(listenType, service, server.Processor.TLSConfig)
 		tlsFlag = "TLS"
 	} else {
 		listener, err = net.Listen(listenType, service)
@@ -54,20 +98,42 @@ func (server *Server) startListening() (listener net.Listener, err error) {
 	return listener, err
 }
 
-func createTLSConfig() (config *tls.Config, err error) {
+// CreateTLSConfig loads the server certificate used for TLS connections & StartTLS
+// client certificates (used by SASL EXTERNAL) are requested & verified when the
+// certificates of the trusted CAs are found in clientCAFile
+func CreateTLSConfig() (config *tls.Config, err error) {
 	// cert generation tool: http://golang.org/src/crypto/tls/generate_cert.go
 	cert, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
 	if err != nil {
 		return nil, fmt.Errorf("Load key pair failed: %v", err)
 	}
+	config = &tls.Config{Certificates: []tls.Certificate{cert}}
 
-	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	clientCAs, err := ioutil.ReadFile(clientCAFile)
+	switch {
+	case os.IsNotExist(err):
+		return config, nil
+	case err != nil:
+		return nil, fmt.Errorf("Load client CAs failed: %v", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(clientCAs) {
+		return nil, fmt.Errorf("Load client CAs failed: no certificates in %s", clientCAFile)
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
 }
 
 func (server *Server) handleConnections(listener net.Listener) {
 	// continuously accept connections
 	for {
 		conn, err := listener.Accept()
+		if server.isClosing() {
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
 		if err != nil {
 			server.ErrChan <- fmt.Errorf("Accept connection failed: %v", err)
 			continue
@@ -77,6 +143,38 @@ func (server *Server) handleConnections(listener net.Listener) {
 			conn.RemoteAddr(),
 			conn.LocalAddr())
 
-		go server.Handler(conn, server.ErrChan)
+		server.serve(server.Processor.NewSession(conn))
+	}
+}
+
+// serve serves session in a new goroutine, tracking it until its connection is closed
+func (server *Server) serve(session *processor.Session) {
+	server.lock.Lock()
+	if server.sessions == nil {
+		server.sessions = make(map[*processor.Session]bool)
 	}
+	server.sessions[session] = true
+	server.served.Add(1)
+	closing := server.closing
+	server.lock.Unlock()
+
+	go func() {
+		defer server.served.Done()
+		session.Serve(server.ErrChan)
+
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		delete(server.sessions, session)
+	}()
+
+	// the server may have been shut down since the connection was accepted
+	if closing {
+		session.Shutdown()
+	}
+}
+
+func (server *Server) isClosing() bool {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.closing
 }
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/idmworks/speedir/datacontext"
+	"github.com/idmworks/speedir/models"
 	"github.com/idmworks/speedir/processor"
 	"github.com/idmworks/speedir/server"
+	"github.com/mavricknz/ldap"
 )
 
 const (
@@ -17,17 +25,53 @@ const (
 )
 
 var (
-	verbose = false
+	verbose      = false
+	rootDN       = "cn=admin,dc=example,dc=org"
+	anonymous    = true
+	unauthBind   = false
+	sizeLimit    = 500
+	timeLimit    = 3600 * time.Second
+	maxOps       = 16
+	idleTimeout  = time.Duration(0)
+	writeTimeout = 30 * time.Second
+	pwScheme     = models.DefaultPasswordScheme
+	pwPolicy     = ""
+
+	bindDelay       = time.Second
+	bindMaxDelay    = time.Minute
+	bindBanAfter    = 10
+	bindBanDuration = 15 * time.Minute
+	bindAddrAfter   = 20
+	bindThrottled   = "busy"
+
+	accessControl   = true
+	scram           = false
+	scramSecretFile = ""
 )
 
 func main() {
 	parseFlags()
+	if scheme := models.FindPasswordScheme(pwScheme); scheme == nil || scheme.Weak {
+		log.Fatalf("Unsupported password scheme '%s'", pwScheme)
+	}
+	if bindThrottled != "busy" && bindThrottled != "unwilling" {
+		log.Fatalf("Unsupported throttled bind result '%s'", bindThrottled)
+	}
 	dc, err := setupDb()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer dc.CloseDb()
-	proc := setupProcessor(dc)
+	tlsConfig, err := server.CreateTLSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	proc := setupProcessor(dc, tlsConfig)
+	if scramSecretFile != "" {
+		if proc.ScramSecret, err = ioutil.ReadFile(scramSecretFile); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if err = startServers(proc); err != nil {
 		log.Fatal(err)
 	}
@@ -36,10 +80,48 @@ func main() {
 func parseFlags() {
 	// register flags & pointers where values will be stored
 	verbosePtr := flag.Bool("verbose", false, "verbose output")
+	anonymousPtr := flag.Bool("anonymous", anonymous, "allow anonymous binds & access by unauthenticated clients")
+	unauthBindPtr := flag.Bool("unauthbind", unauthBind, "accept binds with a DN but no password as anonymous")
+	rootDNPtr := flag.String("rootdn", rootDN, "DN of the directory administrator, authenticated by the users table")
+	sizeLimitPtr := flag.Int("sizelimit", sizeLimit, "maximum entries returned by a search (0 for no limit)")
+	timeLimitPtr := flag.Duration("timelimit", timeLimit, "maximum duration of a search (0 for no limit)")
+	maxOpsPtr := flag.Int("maxops", maxOps, "maximum concurrent operations per connection (0 for no limit)")
+	idleTimeoutPtr := flag.Duration("idletimeout", idleTimeout, "duration after which idle connections are closed (0 for no timeout)")
+	writeTimeoutPtr := flag.Duration("writetimeout", writeTimeout, "duration after which connections of clients not reading their responses are closed (0 for no timeout)")
+	pwPolicyPtr := flag.String("ppolicy", pwPolicy, "DN of the default password policy entry (none if empty)")
+	pwSchemePtr := flag.String("passwordscheme", pwScheme, "scheme of new passwords, weak passwords are rehashed on bind (e.g. ARGON2, PBKDF2-SHA256)")
+	bindDelayPtr := flag.Duration("binddelay", bindDelay, "delay refusing binds of a DN or client address after a failed bind, doubled by each further failure (0 for no delay, binds are throttled by default)")
+	bindMaxDelayPtr := flag.Duration("bindmaxdelay", bindMaxDelay, "maximum delay refusing binds after failed binds")
+	bindBanAfterPtr := flag.Int("bindbanafter", bindBanAfter, "consecutive failed binds banning a DN or client address (0 to never ban)")
+	bindBanDurationPtr := flag.Duration("bindbanduration", bindBanDuration, "duration of bans following failed binds")
+	bindAddrAfterPtr := flag.Int("bindaddrafter", bindAddrAfter, "consecutive failed binds from a client address before it is throttled, as clients may share an address")
+	bindThrottledPtr := flag.String("bindthrottled", bindThrottled, "result of throttled binds: busy or unwilling")
+	accessControlPtr := flag.Bool("accesscontrol", accessControl, "enforce the aci values stored in the directory on clients other than the administrator")
+	scramPtr := flag.Bool("scram", scram, "enable the SCRAM SASL mechanisms, storing SCRAM keys only as strong as PBKDF2 alongside passwords")
+	scramSecretFilePtr := flag.String("scramsecretfile", scramSecretFile, "file holding the secret deriving the SCRAM salts of unknown users (random on each start if empty)")
 	// parse all flags - values now stored in pointers
 	flag.Parse()
 	// store flags for use throughout the app
 	verbose = *verbosePtr
+	rootDN = *rootDNPtr
+	anonymous = *anonymousPtr
+	unauthBind = *unauthBindPtr
+	sizeLimit = *sizeLimitPtr
+	timeLimit = *timeLimitPtr
+	maxOps = *maxOpsPtr
+	idleTimeout = *idleTimeoutPtr
+	writeTimeout = *writeTimeoutPtr
+	pwScheme = *pwSchemePtr
+	pwPolicy = *pwPolicyPtr
+	bindDelay = *bindDelayPtr
+	bindMaxDelay = *bindMaxDelayPtr
+	bindBanAfter = *bindBanAfterPtr
+	bindBanDuration = *bindBanDurationPtr
+	bindAddrAfter = *bindAddrAfterPtr
+	bindThrottled = *bindThrottledPtr
+	accessControl = *accessControlPtr
+	scram = *scramPtr
+	scramSecretFile = *scramSecretFilePtr
 }
 
 func setupDb() (dc *datacontext.DataContext, err error) {
@@ -51,8 +133,38 @@ func setupDb() (dc *datacontext.DataContext, err error) {
 	return dc, nil
 }
 
-func setupProcessor(dc *datacontext.DataContext) *processor.Processor {
-	proc := &processor.Processor{DC: dc, Verbose: verbose}
+func setupProcessor(dc *datacontext.DataContext, tlsConfig *tls.Config) *processor.Processor {
+	proc := &processor.Processor{
+		DC:                   dc,
+		Verbose:              verbose,
+		RootDN:               rootDN,
+		DisallowAnonymous:    !anonymous,
+		AllowUnauthenticated: unauthBind,
+		SizeLimit:            sizeLimit,
+		TimeLimit:            timeLimit,
+		MaxOperations:        maxOps,
+		IdleTimeout:          idleTimeout,
+		WriteTimeout:         writeTimeout,
+		PasswordScheme:       pwScheme,
+		PasswordPolicy:       pwPolicy,
+		AccessControl:        accessControl,
+		Scram:                scram,
+		TLSConfig:            tlsConfig,
+	}
+	// a single throttle is shared by the TLS & plain servers
+	if bindDelay > 0 || bindBanAfter > 0 {
+		proc.BindThrottle = &processor.BindThrottle{
+			Delay:            bindDelay,
+			MaxDelay:         bindMaxDelay,
+			BanAfter:         bindBanAfter,
+			BanDuration:      bindBanDuration,
+			AddressTolerance: bindAddrAfter,
+			Result:           ldap.LDAPResultBusy,
+		}
+		if bindThrottled == "unwilling" {
+			proc.BindThrottle.Result = ldap.LDAPResultUnwillingToPerform
+		}
+	}
 	return proc
 }
 
@@ -61,31 +173,51 @@ func startServers(proc *processor.Processor) error {
 
 	// start first TCP (TLS) server in a goroutine
 	tlsServer := &server.Server{
-		Port:    listenTLSPort,
-		Secure:  true,
-		Handler: proc.HandleRequest,
-		ErrChan: errChan,
+		Port:      listenTLSPort,
+		Secure:    true,
+		Processor: proc,
+		ErrChan:   errChan,
 	}
 	go tlsServer.ServeTCP()
 
 	// start second TCP server in a goroutine
 	tcpServer := &server.Server{
-		Port:    listenTCPPort,
-		Secure:  false,
-		Handler: proc.HandleRequest,
-		ErrChan: errChan,
+		Port:      listenTCPPort,
+		Secure:    false,
+		Processor: proc,
+		ErrChan:   errChan,
 	}
 	go tcpServer.ServeTCP()
 
-	// block waiting for either server to error
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	// block waiting for either server to error or a signal to stop
 	for {
 		select {
 		case err := <-errChan:
 			if err == processor.ErrDecodingASN1 {
 				log.Println(err)
 			} else {
+				shutdownServers(errChan, tlsServer, tcpServer)
 				return err
 			}
+		case sig := <-signals:
+			log.Println("Received", sig, "- shutting down")
+			shutdownServers(errChan, tlsServer, tcpServer)
+			return nil
+		}
+	}
+}
+
+// shutdownServers disconnects the clients of servers, errors reported meanwhile are logged
+func shutdownServers(errChan chan error, servers ...*server.Server) {
+	go func() {
+		for err := range errChan {
+			log.Println(err)
 		}
+	}()
+	for _, srv := range servers {
+		srv.Shutdown()
 	}
 }